@@ -31,7 +31,9 @@ export const SocketProvider = ({ children }) => {
             reconnectionAttempts: Infinity,
             reconnectionDelay: 1000,
             reconnectionDelayMax: 5000,
-            timeout: 20000
+            timeout: 20000,
+            // Read on every (re)connect so a login or logout is picked up
+            auth: (cb) => cb({ token: localStorage.getItem('token') })
         });
 
         // Handle connection errors gracefully to avoid console noise
@@ -50,6 +52,10 @@ export const SocketProvider = ({ children }) => {
             toast(notif.message);
         });
 
+        newSocket.on('alert:triggered', (alert) => {
+            toast(alert.message);
+        });
+
         newSocket.on('prediction_result', (data) => {
             const isDirect = data.precisionLevel === 'direct';
             let message = data.isCorrect
@@ -156,15 +156,22 @@ const Portfolio = () => {
                                             </div>
                                             <div className="h-stat">
                                                 <span className="l">MARKET VALUE</span>
-                                                <span className="v">${holding.currentValue.toFixed(2)}</span>
-                                            </div>
-                                            <div className={`h-stat ${holding.profitLoss >= 0 ? 'profit' : 'loss'}`}>
-                                                <span className="l">P/L</span>
-                                                <span className="v">
-                                                    {holding.profitLoss >= 0 ? '+' : ''}${Math.abs(holding.profitLoss).toFixed(2)}
-                                                    <span className="p">({holding.profitLossPercent.toFixed(2)}%)</span>
-                                                </span>
+                                                <span className="v">{holding.unconverted ? 'N/A' : `$${holding.currentValue.toFixed(2)}`}</span>
                                             </div>
+                                            {holding.unconverted ? (
+                                                <div className="h-stat">
+                                                    <span className="l">P/L</span>
+                                                    <span className="v">No USD rate yet</span>
+                                                </div>
+                                            ) : (
+                                                <div className={`h-stat ${holding.profitLoss >= 0 ? 'profit' : 'loss'}`}>
+                                                    <span className="l">P/L</span>
+                                                    <span className="v">
+                                                        {holding.profitLoss >= 0 ? '+' : ''}${Math.abs(holding.profitLoss).toFixed(2)}
+                                                        <span className="p">({holding.profitLossPercent.toFixed(2)}%)</span>
+                                                    </span>
+                                                </div>
+                                            )}
                                         </div>
                                     </Link>
                                 ))
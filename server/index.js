@@ -13,6 +13,7 @@ import validateEnv from './config/validateEnv.js';
 import Logger, { createServiceLogger } from './utils/logger.js';
 import requestLogger from './middleware/requestLogger.js';
 import { errorHandler } from './middleware/errorMiddleware.js';
+import { socketAuth } from './middleware/socketAuth.js';
 import { RATE_LIMIT, SERVER_CONFIG } from './config/constants.js';
 
 // Routes
@@ -29,6 +30,7 @@ import analyticsRoutes from './routes/analytics.js';
 // Sockets
 import { setupChatHandlers } from './sockets/chat.js';
 import { setupUpdateHandlers } from './sockets/updates.js';
+import { setupAlertRelay } from './sockets/alerts.js';
 
 // Jobs
 import { startPredictionEvaluator } from './jobs/predictionEvaluator.js';
@@ -115,6 +117,8 @@ app.use(errorHandler);
 const socketLogger = createServiceLogger('socket');
 
 // Socket.io connection
+io.use(socketAuth);
+
 io.on('connection', (socket) => {
     socketLogger.debug('New client connected', { socketId: socket.id });
 
@@ -122,6 +126,8 @@ io.on('connection', (socket) => {
     setupUpdateHandlers(io, socket);
 });
 
+setupAlertRelay(io);
+
 // Start background jobs
 startPredictionEvaluator();
 startReputationUpdater();
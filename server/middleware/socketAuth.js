@@ -0,0 +1,24 @@
+import jwt from 'jsonwebtoken';
+import { createServiceLogger } from '../utils/logger.js';
+
+const logger = createServiceLogger('socket-auth');
+
+// Identify the user behind a socket from the JWT the client sends in
+// `auth.token` and join their `user:<id>` room, where alerts and
+// notifications are pushed. Sockets without a valid token stay connected
+// anonymously for public rooms such as stock chat.
+export const socketAuth = (socket, next) => {
+    const token = socket.handshake.auth?.token;
+    if (!token) {
+        return next();
+    }
+
+    try {
+        const decoded = jwt.verify(token, process.env.JWT_SECRET);
+        socket.data.userId = decoded.id;
+        socket.join(`user:${decoded.id}`);
+    } catch (error) {
+        logger.debug('Ignoring invalid socket token', { socketId: socket.id, error: error.message });
+    }
+    next();
+};
@@ -1,5 +1,30 @@
 import mongoose from 'mongoose';
 
+export const PRICE_CONDITIONS = ['ABOVE', 'BELOW'];
+export const PERCENT_CONDITIONS = ['PERCENT_UP', 'PERCENT_DOWN', 'MOVE_UP', 'MOVE_DOWN', 'GAP_UP', 'GAP_DOWN'];
+export const WINDOW_CONDITIONS = ['MOVE_UP', 'MOVE_DOWN'];
+export const LEVEL_CONDITIONS = ['INDICATOR_ABOVE', 'INDICATOR_BELOW'];
+export const CROSS_CONDITIONS = ['CROSS_ABOVE', 'CROSS_BELOW'];
+export const ALERT_CONDITIONS = [
+    ...PRICE_CONDITIONS, ...PERCENT_CONDITIONS, 'HIGH_52W', 'LOW_52W',
+    ...LEVEL_CONDITIONS, ...CROSS_CONDITIONS, 'EXPRESSION',
+    'SENTIMENT_CHANGE', 'QUESTION_SPIKE'
+];
+
+export const SENTIMENT_LABELS = ['Bearish', 'Somewhat Bearish', 'Neutral', 'Somewhat Bullish', 'Bullish'];
+
+// Indicators alert-engine can compute, e.g. SMA50, EMA20, RSI14, looking
+// back at most MAX_INDICATOR_PERIOD bars (maxIndicatorPeriod in the engine)
+export const INDICATOR_PATTERN = /^(SMA|EMA|RSI)([0-9]{1,3})$/;
+export const MAX_INDICATOR_PERIOD = 500;
+
+export const isIndicator = (name) => {
+    const match = INDICATOR_PATTERN.exec(name || '');
+    if (!match) return false;
+    const period = Number(match[2]);
+    return period >= (match[1] === 'RSI' ? 2 : 1) && period <= MAX_INDICATOR_PERIOD;
+};
+
 const alertSchema = new mongoose.Schema({
     user: {
         type: mongoose.Schema.Types.ObjectId,
@@ -14,19 +39,103 @@ const alertSchema = new mongoose.Schema({
     },
     targetPrice: {
         type: Number,
-        required: true
+        required: function () { return PRICE_CONDITIONS.includes(this.condition); }
     },
     condition: {
         type: String,
-        enum: ['ABOVE', 'BELOW'],
+        enum: ALERT_CONDITIONS,
         required: true
     },
+    // Threshold of percentage conditions, e.g. 5 for a 5% move
+    percent: {
+        type: Number,
+        min: 0,
+        required: function () { return PERCENT_CONDITIONS.includes(this.condition); }
+    },
+    // Lookback of MOVE_UP / MOVE_DOWN
+    windowMinutes: {
+        type: Number,
+        min: 1,
+        required: function () { return WINDOW_CONDITIONS.includes(this.condition); }
+    },
+    indicator: {
+        type: String,
+        uppercase: true,
+        validate: {
+            validator: isIndicator,
+            message: `Indicator must be SMA, EMA or RSI with a period up to ${MAX_INDICATOR_PERIOD}, e.g. RSI14`
+        },
+        required: function () { return [...LEVEL_CONDITIONS, ...CROSS_CONDITIONS].includes(this.condition); }
+    },
+    // Other side of a crossover: another indicator or PRICE
+    compareTo: {
+        type: String,
+        uppercase: true,
+        validate: {
+            validator: value => value === 'PRICE' || isIndicator(value),
+            message: 'Compare to must be PRICE or an indicator'
+        },
+        required: function () { return CROSS_CONDITIONS.includes(this.condition); }
+    },
+    level: {
+        type: Number,
+        required: function () { return LEVEL_CONDITIONS.includes(this.condition); }
+    },
+    // Rule of EXPRESSION alerts, e.g. "AAPL > 200 AND sentimentLabel == Bullish".
+    // Checked by the alerts route (utils/alertRule.js) and parsed by alert-engine,
+    // which disables the alert and sets error if it still can't be evaluated.
+    expression: {
+        type: String,
+        trim: true,
+        maxlength: 500,
+        required: function () { return this.condition === 'EXPRESSION'; }
+    },
+    // SENTIMENT_CHANGE fires only on a change to this label if set
+    label: {
+        type: String,
+        enum: SENTIMENT_LABELS
+    },
+    // QUESTION_SPIKE threshold: new questions in the last hour
+    perHour: {
+        type: Number,
+        min: 1,
+        required: function () { return this.condition === 'QUESTION_SPIKE'; }
+    },
+    error: {
+        type: String
+    },
     isActive: {
         type: Boolean,
         default: true
     },
     triggeredAt: {
         type: Date
+    },
+    // Recurring alerts stay active and fire again once re-armed
+    recurring: {
+        type: Boolean,
+        default: false
+    },
+    cooldownMinutes: {
+        type: Number,
+        default: 60,
+        min: 0
+    },
+    rearmPercent: {
+        type: Number,
+        default: 1,
+        min: 0
+    },
+    awaitingRearm: {
+        type: Boolean,
+        default: false
+    },
+    triggerCount: {
+        type: Number,
+        default: 0
+    },
+    lastTriggeredAt: {
+        type: Date
     }
 }, {
     timestamps: true
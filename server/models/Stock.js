@@ -86,12 +86,44 @@ const stockSchema = new mongoose.Schema({
         type: String,
         enum: ['Bearish', 'Somewhat Bearish', 'Neutral', 'Somewhat Bullish', 'Bullish'],
         default: 'Neutral'
-    }
+    },
+    // Quote currency and listing exchange, maintained by price-updater.
+    // priceUSD = currentPrice * fxRate and is what balances are settled in.
+    currency: {
+        type: String
+    },
+    exchange: {
+        type: String
+    },
+    fxRate: {
+        type: Number
+    },
+    priceUSD: {
+        type: Number
+    },
+    // When price-updater last accepted a quote; the day's high24h/low24h
+    // start over at the first quote of each session
+    priceUpdatedAt: {
+        type: Date
+    },
+    // Maintained by `price-updater universe sync`; delisted stocks are kept
+    // for their history but no longer refreshed or listed
+    isActive: {
+        type: Boolean,
+        default: true
+    },
+    delistedAt: {
+        type: Date
+    },
+    universes: [{
+        type: String
+    }]
 }, {
     timestamps: true
 });
 
 // Calculate change and changePercent before saving
+// (price-updater computes these itself, as its direct updates bypass this hook)
 stockSchema.pre('save', function (next) {
     if (this.isModified('currentPrice') || this.isModified('previousClose')) {
         this.change = this.currentPrice - this.previousClose;
@@ -100,6 +132,15 @@ stockSchema.pre('save', function (next) {
     next();
 });
 
+// Price in USD, the currency balances are held in, or null for a listing in
+// another currency whose exchange rate isn't known yet. The native price is
+// never used in its place, as it would be off by the exchange rate.
+stockSchema.methods.usdPrice = function () {
+    if (this.priceUSD > 0) return this.priceUSD;
+    if (!this.currency || this.currency === 'USD') return this.currentPrice;
+    return null;
+};
+
 // Indexes
 stockSchema.index({ symbol: 'text', name: 'text' });
 stockSchema.index({ currentPrice: 1 });
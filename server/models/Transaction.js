@@ -13,7 +13,7 @@ const transactionSchema = new mongoose.Schema({
     },
     type: {
         type: String,
-        enum: ['buy', 'sell'],
+        enum: ['buy', 'sell', 'dividend'], // dividend is credited by price-updater
         required: true
     },
     quantity: {
@@ -1,6 +1,9 @@
 import mongoose from 'mongoose';
 import bcrypt from 'bcryptjs';
 
+// Ways the Alert Engine can deliver triggered alerts
+export const ALERT_CHANNELS = ['inApp', 'email', 'webhook', 'socket'];
+
 const userSchema = new mongoose.Schema({
     username: {
         type: String,
@@ -97,7 +100,24 @@ const userSchema = new mongoose.Schema({
     following: [{
         type: mongoose.Schema.Types.ObjectId,
         ref: 'User'
-    }]
+    }],
+    alertChannels: {
+        type: [{ type: String, enum: ALERT_CHANNELS }],
+        default: ['inApp', 'socket']
+    },
+    alertWebhook: {
+        url: {
+            type: String,
+            default: '',
+            trim: true
+        },
+        // HMAC key the Alert Engine signs webhook deliveries with
+        secret: {
+            type: String,
+            default: '',
+            select: false
+        }
+    }
 }, {
     timestamps: true
 });
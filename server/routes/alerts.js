@@ -1,21 +1,40 @@
 import express from 'express';
 import { body } from 'express-validator';
-import Alert from '../models/Alert.js';
+import Alert, {
+    ALERT_CONDITIONS, PRICE_CONDITIONS, PERCENT_CONDITIONS, WINDOW_CONDITIONS,
+    LEVEL_CONDITIONS, CROSS_CONDITIONS, MAX_INDICATOR_PERIOD, SENTIMENT_LABELS, isIndicator
+} from '../models/Alert.js';
 import Stock from '../models/Stock.js';
+import { checkRule } from '../utils/alertRule.js';
 import { protect } from '../middleware/auth.js';
 import { asyncHandler, ErrorResponse } from '../middleware/errorMiddleware.js';
 
 const router = express.Router();
 
 // @route   POST /api/alerts
-// @desc    Create a new price alert
+// @desc    Create a new price, sentiment or discussion alert
 // @access  Private
 router.post('/', protect, [
     body('symbol').trim().notEmpty().withMessage('Symbol is required').toUpperCase(),
-    body('targetPrice').isNumeric().withMessage('Target price must be a number'),
-    body('condition').isIn(['ABOVE', 'BELOW']).withMessage('Condition must be ABOVE or BELOW')
+    body('condition').isIn(ALERT_CONDITIONS).withMessage(`Condition must be one of ${ALERT_CONDITIONS.join(', ')}`),
+    body('targetPrice').if(body('condition').isIn(PRICE_CONDITIONS)).isNumeric().withMessage('Target price must be a number'),
+    body('percent').if(body('condition').isIn(PERCENT_CONDITIONS)).isFloat({ min: 0 }).withMessage('Percent must be a positive number'),
+    body('windowMinutes').if(body('condition').isIn(WINDOW_CONDITIONS)).isFloat({ min: 1 }).withMessage('Window must be at least 1 minute'),
+    body('indicator').if(body('condition').isIn([...LEVEL_CONDITIONS, ...CROSS_CONDITIONS])).toUpperCase().custom(isIndicator).withMessage(`Indicator must be like SMA50, EMA20 or RSI14, with a period up to ${MAX_INDICATOR_PERIOD}`),
+    body('compareTo').if(body('condition').isIn(CROSS_CONDITIONS)).toUpperCase().custom(value => value === 'PRICE' || isIndicator(value)).withMessage('Compare to must be PRICE or an indicator'),
+    body('level').if(body('condition').isIn(LEVEL_CONDITIONS)).isNumeric().withMessage('Level must be a number'),
+    body('expression').if(body('condition').equals('EXPRESSION')).trim().isLength({ min: 1, max: 500 }).withMessage('Expression must be 1-500 characters'),
+    body('label').optional().isIn(SENTIMENT_LABELS).withMessage(`Label must be one of ${SENTIMENT_LABELS.join(', ')}`),
+    body('perHour').if(body('condition').equals('QUESTION_SPIKE')).isInt({ min: 1 }).withMessage('Questions per hour must be at least 1'),
+    body('recurring').optional().isBoolean().withMessage('Recurring must be true or false'),
+    body('cooldownMinutes').optional().isFloat({ min: 0 }).withMessage('Cooldown must be a positive number of minutes'),
+    body('rearmPercent').optional().isFloat({ min: 0, max: 100 }).withMessage('Re-arm threshold must be between 0 and 100 percent')
 ], asyncHandler(async (req, res, next) => {
-    const { symbol, targetPrice, condition } = req.body;
+    const {
+        symbol, targetPrice, condition, percent, windowMinutes,
+        indicator, compareTo, level, expression, label, perHour,
+        recurring, cooldownMinutes, rearmPercent
+    } = req.body;
 
     // Check if stock exists
     const stock = await Stock.findOne({ symbol });
@@ -23,12 +42,31 @@ router.post('/', protect, [
         return next(new ErrorResponse('Stock not found', 404));
     }
 
+    // Reject rules alert-engine would only disable
+    if (condition === 'EXPRESSION') {
+        const ruleError = checkRule(expression, symbol);
+        if (ruleError) {
+            return next(new ErrorResponse(ruleError, 400));
+        }
+    }
+
     // Create alert
     const alert = await Alert.create({
         user: req.user._id,
         symbol,
         targetPrice,
-        condition
+        condition,
+        percent,
+        windowMinutes,
+        indicator,
+        compareTo,
+        level,
+        expression,
+        label,
+        perHour,
+        recurring,
+        cooldownMinutes,
+        rearmPercent
     });
 
     res.status(201).json({
@@ -87,7 +125,19 @@ router.put('/:id/toggle', protect, asyncHandler(async (req, res, next) => {
         return next(new ErrorResponse('Not authorized to modify this alert', 401));
     }
 
+    // A rule the engine disabled stays off until it is valid
+    if (!alert.isActive && alert.condition === 'EXPRESSION') {
+        const ruleError = checkRule(alert.expression, alert.symbol);
+        if (ruleError) {
+            return next(new ErrorResponse(ruleError, 400));
+        }
+    }
+
     alert.isActive = !alert.isActive;
+    if (alert.isActive) {
+        alert.awaitingRearm = false;
+        alert.error = undefined;
+    }
     await alert.save();
 
     res.json({
@@ -30,7 +30,10 @@ router.get('/diversification/:userId', protect, asyncHandler(async (req, res, ne
         if (!holding.stockId) return; // Skip if stock is deleted
 
         const stock = holding.stockId;
-        const value = holding.quantity * stock.currentPrice;
+        const price = stock.usdPrice();
+        if (price === null) return; // No exchange rate yet
+
+        const value = holding.quantity * price;
         const sector = stock.sector || 'Other';
 
         if (!sectorMap[sector]) {
@@ -20,12 +20,26 @@ router.get('/', protect, async (req, res) => {
 
         // Get all holdings
         const holdings = await Holding.find({ userId })
-            .populate('stockId', 'symbol name currentPrice change changePercent');
+            .populate('stockId', 'symbol name currentPrice priceUSD currency change changePercent');
 
-        // Calculate total portfolio value
+        // Calculate total portfolio value (in USD, like the cash balance).
+        // Holdings without a USD price yet are flagged and left out of the totals.
         let totalHoldingsValue = 0;
+        const unconverted = [];
         const formattedHoldings = holdings.map(holding => {
-            const currentVal = holding.quantity * holding.stockId.currentPrice;
+            const price = holding.stockId.usdPrice();
+            if (price === null) {
+                unconverted.push(holding.stockId.symbol);
+                return {
+                    ...holding.toObject(),
+                    currentValue: null,
+                    profitLoss: null,
+                    profitLossPercent: null,
+                    unconverted: true
+                };
+            }
+
+            const currentVal = holding.quantity * price;
             totalHoldingsValue += currentVal;
 
             const costBasis = holding.quantity * holding.averagePrice;
@@ -44,7 +58,8 @@ router.get('/', protect, async (req, res) => {
             balance: user.balance,
             totalValue: user.balance + totalHoldingsValue,
             holdingsValue: totalHoldingsValue,
-            holdings: formattedHoldings
+            holdings: formattedHoldings,
+            unconverted
         });
     } catch (error) {
         res.status(500).json({ message: error.message });
@@ -72,7 +87,13 @@ router.post('/trade', protect, async (req, res) => {
         }
 
         const user = await User.findById(userId).session(session);
-        const stockPrice = stock.currentPrice;
+        // Settle in USD so international listings debit the right amount
+        const stockPrice = stock.usdPrice();
+        if (stockPrice === null) {
+            await session.abortTransaction();
+            session.endSession();
+            return res.status(503).json({ message: `No USD price for ${stock.symbol} yet, try again shortly` });
+        }
         const totalCost = stockPrice * quantity;
 
         if (type === 'buy') {
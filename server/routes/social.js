@@ -45,10 +45,7 @@ router.post('/follow/:id', protect, async (req, res) => {
         });
 
         // Real-time Socket Notification
-        // Note: In a real scalable app, use Redis/Adapter to find the socket ID across nodes.
-        // For this single-node setup, we broadcast to the user's room if they joined one, 
-        // or just emit generally and let client filter (less secure/efficient) or use a map.
-        // Ideally, users join a room named `user:{userId}` upon connection.
+        // Authenticated sockets join `user:{userId}` on connection (see middleware/socketAuth.js)
         req.io.to(`user:${userToFollowId}`).emit('notification:new', notification);
 
         res.json({ message: 'User followed successfully' });
@@ -20,7 +20,7 @@ router.get('/', redisCache.route({ expire: 300 }), async (req, res) => {
         const skip = (page - 1) * limit;
         const { search, sector, sortBy } = req.query;
 
-        let query = {};
+        let query = { isActive: { $ne: false } };
 
         // 1. Filtering Logic
         if (search) {
@@ -1,11 +1,13 @@
+import crypto from 'crypto';
 import express from 'express';
 import mongoose from 'mongoose';
 import { protect } from '../middleware/auth.js';
-import User from '../models/User.js';
+import User, { ALERT_CHANNELS } from '../models/User.js';
 import Prediction from '../models/Prediction.js';
 import Question from '../models/Question.js';
 import Answer from '../models/Answer.js';
 import { getReputationTier } from '../utils/reputation.js';
+import { checkWebhookUrl } from '../utils/webhookUrl.js';
 
 const router = express.Router();
 
@@ -126,11 +128,22 @@ router.get('/:id/stats', async (req, res) => {
 });
 
 // @route   PUT /api/users/profile
-// @desc    Update user profile (avatar, bio, status)
+// @desc    Update user profile (avatar, bio, status, alert delivery)
 // @access  Private
 router.put('/profile', protect, async (req, res) => {
     try {
-        const { avatar, bio, status } = req.body;
+        const { avatar, bio, status, alertChannels, alertWebhookUrl } = req.body;
+
+        if (alertChannels !== undefined &&
+            (!Array.isArray(alertChannels) || alertChannels.some((c) => !ALERT_CHANNELS.includes(c)))) {
+            return res.status(400).json({ message: `Alert channels must be some of ${ALERT_CHANNELS.join(', ')}` });
+        }
+        if (alertWebhookUrl) {
+            const problem = await checkWebhookUrl(alertWebhookUrl);
+            if (problem) {
+                return res.status(400).json({ message: problem });
+            }
+        }
 
         const user = await User.findById(req.user._id);
 
@@ -142,6 +155,15 @@ router.put('/profile', protect, async (req, res) => {
         if (avatar !== undefined) user.avatar = avatar;
         if (bio !== undefined) user.bio = bio;
         if (status !== undefined) user.status = status;
+        if (alertChannels !== undefined) user.alertChannels = [...new Set(alertChannels)];
+
+        // A new webhook gets a new signing secret, shown only in this response
+        let alertWebhookSecret;
+        if (alertWebhookUrl !== undefined && alertWebhookUrl !== user.alertWebhook.url) {
+            user.alertWebhook.url = alertWebhookUrl;
+            alertWebhookSecret = alertWebhookUrl ? crypto.randomBytes(32).toString('hex') : '';
+            user.alertWebhook.secret = alertWebhookSecret;
+        }
 
         await user.save();
 
@@ -152,7 +174,10 @@ router.put('/profile', protect, async (req, res) => {
             reputation: user.reputation,
             avatar: user.avatar,
             bio: user.bio,
-            status: user.status
+            status: user.status,
+            alertChannels: user.alertChannels,
+            alertWebhookUrl: user.alertWebhook.url,
+            ...(alertWebhookSecret && { alertWebhookSecret })
         });
     } catch (error) {
         console.error('Update profile error:', error);
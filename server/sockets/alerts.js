@@ -0,0 +1,32 @@
+import { redisClient, isRedisAvailable } from '../middleware/cache.js';
+import { createServiceLogger } from '../utils/logger.js';
+
+const logger = createServiceLogger('socket-alerts');
+
+const ALERT_CHANNEL = process.env.ALERT_REDIS_CHANNEL || 'alerts:notifications';
+
+// Relay alerts published by the Alert Engine to the user's room
+export const setupAlertRelay = async (io) => {
+    if (!isRedisAvailable) {
+        logger.warn('Redis not available, triggered alerts will not be pushed to sockets');
+        return;
+    }
+
+    try {
+        const subscriber = redisClient.duplicate();
+        subscriber.on('error', (err) => logger.error('Alert relay Redis error', err));
+        await subscriber.connect();
+
+        await subscriber.subscribe(ALERT_CHANNEL, (message) => {
+            try {
+                const alert = JSON.parse(message);
+                io.to(`user:${alert.userId}`).emit('alert:triggered', alert);
+            } catch (error) {
+                logger.warn('Dropping malformed alert message', { error: error.message });
+            }
+        });
+        logger.info(`Relaying triggered alerts from ${ALERT_CHANNEL}`);
+    } catch (error) {
+        logger.warn('Alert relay could not subscribe to Redis', { error: error.message });
+    }
+};
@@ -0,0 +1,313 @@
+import { MAX_INDICATOR_PERIOD } from '../models/Alert.js';
+
+// Checks EXPRESSION alert rules the way alert-engine parses them (ParseRule
+// in services/alert-engine/rules.go), so a rule the engine would disable is
+// rejected when the alert is created. Keep the two in step: both are tested
+// against services/alert-engine/testdata/rules.json.
+
+const MAX_RULE_LENGTH = 500;
+const MAX_RULE_NODES = 100;
+
+const NUMBER = 'number';
+const TEXT = 'text';
+const CONDITION = 'condition';
+
+// Stock document fields a rule may reference (ruleFields in the engine)
+export const RULE_FIELDS = {
+    currentPrice: NUMBER,
+    previousClose: NUMBER,
+    change: NUMBER,
+    changePercent: NUMBER,
+    volume: NUMBER,
+    marketCap: NUMBER,
+    high24h: NUMBER,
+    low24h: NUMBER,
+    fiftyTwoWeekHigh: NUMBER,
+    fiftyTwoWeekLow: NUMBER,
+    peRatio: NUMBER,
+    dividendYield: NUMBER,
+    sentimentScore: NUMBER,
+    priceUSD: NUMBER,
+    sentimentLabel: TEXT,
+    sector: TEXT,
+    industry: TEXT,
+    exchange: TEXT,
+    currency: TEXT
+};
+
+// Average daily volume over the last 20 sessions, e.g. "volume > 2x avgVolume"
+export const AVG_VOLUME_TERMS = ['avgVolume', 'averageVolume'];
+
+const INDICATOR_NAME = /^(SMA|EMA|RSI)([0-9]+)$/;
+const OPERATORS = ['>=', '<=', '==', '!=', '&&', '||', '>', '<', '=', '!', '+', '-', '*', '/'];
+const ALIASES = { '&&': 'AND', '||': 'OR', '!': 'NOT', '=': '==' };
+
+const isIdentChar = (c) => /[\p{L}_]/u.test(c);
+const isDigit = (c) => /\p{Nd}/u.test(c);
+const same = (a, b) => a.toLowerCase() === b.toLowerCase();
+
+class RuleError extends Error {}
+
+const lex = (src) => {
+    const chars = Array.from(src);
+    const tokens = [];
+
+    for (let i = 0; i < chars.length;) {
+        const c = chars[i];
+        if (/\s/u.test(c)) {
+            i++;
+        } else if (c === '(' || c === ')') {
+            tokens.push({ kind: c, text: c, pos: i });
+            i++;
+        } else if (c === '"' || c === '\'') {
+            const end = chars.indexOf(c, i + 1);
+            if (end === -1) {
+                throw new RuleError(`unterminated string at position ${i + 1}`);
+            }
+            tokens.push({ kind: 'string', text: chars.slice(i + 1, end).join(''), pos: i });
+            i = end + 1;
+        } else if (isDigit(c) || (c === '.' && i + 1 < chars.length && isDigit(chars[i + 1]))) {
+            const start = i;
+            while (i < chars.length && (isDigit(chars[i]) || chars[i] === '.')) i++;
+            const text = chars.slice(start, i).join('');
+            if (!/^(\d+\.?\d*|\.\d+)$/.test(text)) {
+                throw new RuleError(`invalid number "${text}" at position ${start + 1}`);
+            }
+            tokens.push({ kind: 'number', text, pos: start });
+
+            // "2x avgVolume" is shorthand for 2 * avgVolume
+            if (i < chars.length && (chars[i] === 'x' || chars[i] === 'X') &&
+                (i + 1 === chars.length || !isIdentChar(chars[i + 1]))) {
+                tokens.push({ kind: 'op', text: '*', pos: i });
+                i++;
+            }
+        } else if (isIdentChar(c)) {
+            const start = i;
+            while (i < chars.length && (isIdentChar(chars[i]) || isDigit(chars[i]))) i++;
+            const word = chars.slice(start, i).join('');
+            const upper = word.toUpperCase();
+            if (upper === 'AND' || upper === 'OR' || upper === 'NOT') {
+                tokens.push({ kind: 'op', text: upper, pos: start });
+            } else {
+                tokens.push({ kind: 'ident', text: word, pos: start });
+            }
+        } else {
+            const rest = chars.slice(i, i + 2).join('');
+            const op = OPERATORS.find((candidate) => rest.startsWith(candidate));
+            if (!op) {
+                throw new RuleError(`unexpected character '${c}' at position ${i + 1}`);
+            }
+            tokens.push({ kind: 'op', text: ALIASES[op] || op, pos: i });
+            i += op.length;
+        }
+    }
+
+    tokens.push({ kind: 'eof', text: 'end of rule', pos: chars.length });
+    return tokens;
+};
+
+class Parser {
+    constructor(tokens, symbol) {
+        this.tokens = tokens;
+        this.pos = 0;
+        this.symbol = symbol;
+        this.nodes = 0;
+    }
+
+    peek() {
+        return this.tokens[this.pos];
+    }
+
+    next() {
+        const tok = this.tokens[this.pos];
+        if (tok.kind !== 'eof') this.pos++;
+        return tok;
+    }
+
+    accept(...ops) {
+        const tok = this.peek();
+        if (tok.kind === 'op' && ops.includes(tok.text)) {
+            return this.next();
+        }
+        return null;
+    }
+
+    node(type, extra = {}) {
+        this.nodes++;
+        if (this.nodes > MAX_RULE_NODES) {
+            throw new RuleError(`rule is too complex (more than ${MAX_RULE_NODES} terms)`);
+        }
+        return { type, ...extra };
+    }
+
+    parseOr() {
+        let left = this.parseAnd();
+        let tok;
+        while ((tok = this.accept('OR'))) {
+            left = this.logical(tok, left, this.parseAnd());
+        }
+        return left;
+    }
+
+    parseAnd() {
+        let left = this.parseNot();
+        let tok;
+        while ((tok = this.accept('AND'))) {
+            left = this.logical(tok, left, this.parseNot());
+        }
+        return left;
+    }
+
+    logical(tok, left, right) {
+        if (left.type !== CONDITION || right.type !== CONDITION) {
+            throw new RuleError(`${tok.text} at position ${tok.pos + 1} needs a condition on both sides`);
+        }
+        return this.node(CONDITION);
+    }
+
+    parseNot() {
+        const tok = this.accept('NOT');
+        if (tok) {
+            const x = this.parseNot();
+            if (x.type !== CONDITION) {
+                throw new RuleError(`NOT at position ${tok.pos + 1} needs a condition`);
+            }
+            return this.node(CONDITION);
+        }
+        return this.parseComparison();
+    }
+
+    parseComparison() {
+        const left = this.parseSum();
+        const tok = this.accept('>', '>=', '<', '<=', '==', '!=');
+        if (!tok) return left;
+        const right = this.parseSum();
+
+        if (left.type === CONDITION || right.type === CONDITION) {
+            throw new RuleError(`${tok.text} at position ${tok.pos + 1} compares conditions; use AND / OR`);
+        }
+        if (left.type !== right.type) {
+            // A misspelt field reads as text; say so rather than report a type clash
+            const word = [left, right].find((side) => side.bare);
+            if (word) {
+                throw new RuleError(`unknown field "${word.value}" (rules can use ${this.symbol}'s own fields)`);
+            }
+            throw new RuleError(`${tok.text} at position ${tok.pos + 1} compares ${left.type} with ${right.type}`);
+        }
+        if (left.type === TEXT && tok.text !== '==' && tok.text !== '!=') {
+            throw new RuleError(`text can only be compared with == or != (position ${tok.pos + 1})`);
+        }
+        return this.node(CONDITION);
+    }
+
+    parseSum() {
+        let left = this.parseProduct();
+        let tok;
+        while ((tok = this.accept('+', '-'))) {
+            left = this.arithmetic(tok, left, this.parseProduct());
+        }
+        return left;
+    }
+
+    parseProduct() {
+        let left = this.parseUnary();
+        let tok;
+        while ((tok = this.accept('*', '/'))) {
+            left = this.arithmetic(tok, left, this.parseUnary());
+        }
+        return left;
+    }
+
+    arithmetic(tok, left, right) {
+        if (left.type !== NUMBER || right.type !== NUMBER) {
+            throw new RuleError(`${tok.text} at position ${tok.pos + 1} needs numbers on both sides`);
+        }
+        return this.node(NUMBER);
+    }
+
+    parseUnary() {
+        const tok = this.accept('-');
+        if (tok) {
+            const x = this.parseUnary();
+            if (x.type !== NUMBER) {
+                throw new RuleError(`- at position ${tok.pos + 1} needs a number`);
+            }
+            return this.node(NUMBER);
+        }
+        return this.parsePrimary();
+    }
+
+    parsePrimary() {
+        const tok = this.next();
+        switch (tok.kind) {
+            case 'number':
+                return this.node(NUMBER);
+            case 'string':
+                return this.node(TEXT, { value: tok.text });
+            case 'ident':
+                return this.node(...this.identifier(tok.text));
+            case '(': {
+                const x = this.parseOr();
+                const closing = this.next();
+                if (closing.kind !== ')') {
+                    throw new RuleError(`expected ) at position ${closing.pos + 1}, got "${closing.text}"`);
+                }
+                return x;
+            }
+            default:
+                throw new RuleError(`unexpected "${tok.text}" at position ${tok.pos + 1}`);
+        }
+    }
+
+    // Resolves a name to a field, indicator or bare word
+    identifier(name) {
+        if (same(name, this.symbol) || same(name, 'price')) {
+            return [NUMBER];
+        }
+        if (AVG_VOLUME_TERMS.some((term) => same(name, term))) {
+            return [NUMBER];
+        }
+        const indicator = INDICATOR_NAME.exec(name.toUpperCase());
+        if (indicator) {
+            const minPeriod = indicator[1] === 'RSI' ? 2 : 1;
+            const period = Number(indicator[2]);
+            if (period < minPeriod || period > MAX_INDICATOR_PERIOD) {
+                throw new RuleError(`indicator ${name.toUpperCase()}: period must be between ${minPeriod} and ${MAX_INDICATOR_PERIOD}`);
+            }
+            return [NUMBER];
+        }
+        const field = Object.keys(RULE_FIELDS).find((f) => same(name, f));
+        if (field) {
+            return [RULE_FIELDS[field]];
+        }
+        return [TEXT, { value: name, bare: true }];
+    }
+}
+
+// Returns why a rule can't be used on an alert for symbol, or null if it can
+export const checkRule = (expression, symbol) => {
+    if (typeof expression !== 'string' || !expression.trim()) {
+        return 'Expression is required';
+    }
+    if (expression.length > MAX_RULE_LENGTH) {
+        return `Invalid expression: rule is longer than ${MAX_RULE_LENGTH} characters`;
+    }
+
+    try {
+        const parser = new Parser(lex(expression), String(symbol || '').toUpperCase());
+        const root = parser.parseOr();
+        const tok = parser.peek();
+        if (tok.kind !== 'eof') {
+            throw new RuleError(`unexpected "${tok.text}" at position ${tok.pos + 1}`);
+        }
+        if (root.type !== CONDITION) {
+            throw new RuleError(`rule must be a condition, not a ${root.type}`);
+        }
+    } catch (error) {
+        if (error instanceof RuleError) {
+            return `Invalid expression: ${error.message}`;
+        }
+        throw error;
+    }
+    return null;
+};
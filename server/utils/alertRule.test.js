@@ -0,0 +1,22 @@
+import { test } from 'node:test';
+import assert from 'node:assert/strict';
+import { readFileSync } from 'node:fs';
+import { checkRule } from './alertRule.js';
+
+// Shared with alert-engine's rules_test.go, so both parsers accept and
+// reject the same rules with the same messages
+const vectors = JSON.parse(readFileSync(
+    new URL('../../services/alert-engine/testdata/rules.json', import.meta.url), 'utf8'
+));
+
+for (const { rule, symbol, error } of vectors.cases) {
+    test(rule.slice(0, 60), () => {
+        const result = checkRule(rule, symbol || vectors.symbol);
+        assert.equal(result, error ? `Invalid expression: ${error}` : null);
+    });
+}
+
+test('an empty rule is required', () => {
+    assert.equal(checkRule('  ', 'AAPL'), 'Expression is required');
+    assert.equal(checkRule(undefined, 'AAPL'), 'Expression is required');
+});
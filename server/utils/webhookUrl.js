@@ -0,0 +1,50 @@
+import dns from 'dns/promises';
+import net from 'net';
+
+// Address ranges a webhook may not point at: loopback, private, link-local
+// (which includes cloud metadata at 169.254.169.254), carrier-grade NAT,
+// multicast and reserved. The Alert Engine checks the same at connect time
+// (publicAddressOnly in services/alert-engine/channels.go).
+const blocked = new net.BlockList();
+[
+    ['0.0.0.0', 8], ['10.0.0.0', 8], ['100.64.0.0', 10], ['127.0.0.0', 8],
+    ['169.254.0.0', 16], ['172.16.0.0', 12], ['192.0.0.0', 24], ['192.168.0.0', 16],
+    ['198.18.0.0', 15], ['224.0.0.0', 4], ['240.0.0.0', 4]
+].forEach(([address, prefix]) => blocked.addSubnet(address, prefix, 'ipv4'));
+[
+    ['::', 127], ['fc00::', 7], ['fe80::', 10], ['ff00::', 8]
+].forEach(([address, prefix]) => blocked.addSubnet(address, prefix, 'ipv6'));
+
+const isPublicAddress = (address) => !blocked.check(address, net.isIPv6(address) ? 'ipv6' : 'ipv4');
+
+// Returns why a webhook URL can't be used, or null if it can. The host is
+// resolved, and every address it resolves to must be public.
+// ALERT_WEBHOOK_ALLOW_PRIVATE=true skips the address check for local testing.
+export const checkWebhookUrl = async (value) => {
+    let url;
+    try {
+        url = new URL(value);
+    } catch {
+        return 'Webhook URL must be an http(s) URL';
+    }
+    if (url.protocol !== 'https:' && url.protocol !== 'http:') {
+        return 'Webhook URL must be an http(s) URL';
+    }
+    if (process.env.ALERT_WEBHOOK_ALLOW_PRIVATE === 'true') {
+        return null;
+    }
+
+    const host = url.hostname.replace(/^\[|\]$/g, '');
+    let addresses = [host];
+    if (!net.isIP(host)) {
+        try {
+            addresses = (await dns.lookup(host, { all: true })).map((a) => a.address);
+        } catch {
+            return `Webhook host ${host} could not be resolved`;
+        }
+    }
+    if (!addresses.every(isPublicAddress)) {
+        return 'Webhook URL must point to a public address';
+    }
+    return null;
+};
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Engine evaluates alerts against stock and discussion events
+type Engine struct {
+	alerts     *mongo.Collection
+	notifier   *Notifier
+	stocks     *mongo.Collection
+	questions  *mongo.Collection
+	index      *AlertIndex
+	pool       *WorkerPool
+	history    *PriceHistory
+	indicators *IndicatorBook
+	sentiment  *SentimentTracker
+
+	rulesMu sync.Mutex
+	rules   map[string]parsedRule
+
+	symbolsMu sync.Mutex
+	symbols   map[primitive.ObjectID]string
+}
+
+func NewEngine(db *mongo.Database, notifier *Notifier) *Engine {
+	e := &Engine{
+		alerts:     db.Collection("alerts"),
+		notifier:   notifier,
+		stocks:     db.Collection("stocks"),
+		questions:  db.Collection("questions"),
+		index:      NewAlertIndex(db),
+		history:    NewPriceHistory(db),
+		indicators: NewIndicatorBook(db),
+		sentiment:  NewSentimentTracker(db),
+		rules:      make(map[string]parsedRule),
+		symbols:    make(map[primitive.ObjectID]string),
+	}
+	e.pool = NewWorkerPool(loadPoolConfig(), e.checkAndProcessAlerts)
+	return e
+}
+
+// Observe records a stock event in the rolling windows, indicator bars and
+// sentiment labels, and fills in the label it replaced. A newly applied
+// split restates the windows and bars first. Events must be observed in
+// order.
+func (e *Engine) Observe(market Market) Market {
+	if market.Split.Ratio > 0 {
+		e.history.Split(market.Symbol, market.Split)
+		e.indicators.Split(market.Symbol, market.Split)
+	}
+	if market.Events&EventPrice != 0 {
+		e.history.Observe(market.Symbol, market.At, market.Price)
+		e.indicators.Observe(market.Symbol, market.At, market.Price)
+	}
+	if market.SentimentLabel != "" {
+		market.PreviousSentiment = e.sentiment.Swap(market.Symbol, market.SentimentLabel)
+	}
+	return market
+}
+
+// Dispatch queues the market's alerts for evaluation on the worker pool. It
+// blocks while the symbol's queue is full.
+func (e *Engine) Dispatch(market Market) {
+	e.pool.Submit(market)
+}
+
+func (e *Engine) checkAndProcessAlerts(market Market) {
+	ctx := context.Background()
+
+	// Look up the ACTIVE alerts for this stock symbol that the event can affect
+	for _, alert := range e.index.Match(market) {
+		// Measure the alert's condition; skip it until there is enough data
+		r, ok := e.readCondition(ctx, alert, market)
+		if !ok {
+			continue
+		}
+
+		if alert.Recurring {
+			if alert.AwaitingRearm {
+				if r.rearmed(alert) {
+					e.rearmAlert(alert, r)
+				}
+				continue
+			}
+			if r.met() && coolingDown(alert, market.At) {
+				continue
+			}
+		}
+
+		if r.met() {
+			e.executeAlert(alert, r, market)
+		}
+	}
+}
+
+// Recurring Alerts
+
+// coolingDown reports whether the alert fired less than its cooldown ago
+func coolingDown(alert Alert, now time.Time) bool {
+	if alert.LastTriggeredAt == nil || alert.CooldownMinutes <= 0 {
+		return false
+	}
+	cooldown := time.Duration(alert.CooldownMinutes * float64(time.Minute))
+	return now.Before(alert.LastTriggeredAt.Add(cooldown))
+}
+
+func (e *Engine) rearmAlert(alert Alert, r reading) {
+	result, err := e.alerts.UpdateOne(
+		context.Background(),
+		claimFilter(alert),
+		bson.M{"$set": bson.M{"awaitingRearm": false}},
+	)
+	if err != nil {
+		log.Printf("Failed to re-arm alert %s: %v", alert.ID.Hex(), err)
+		return
+	}
+	if result.ModifiedCount > 0 {
+		alert.AwaitingRearm = false
+		e.index.Put(alert)
+		fmt.Printf("Alert Re-armed: %s %s (%.2f vs %.2f)\n",
+			alert.Symbol, alert.Condition, r.value, r.threshold)
+	}
+}
+
+// disableAlert deactivates an alert that can never be evaluated and records
+// why on the alert
+func (e *Engine) disableAlert(alert Alert, reason error) {
+	log.Printf("Disabling alert %s (%s): %v", alert.ID.Hex(), alert.Symbol, reason)
+	e.index.Remove(alert.ID)
+
+	_, err := e.alerts.UpdateOne(
+		context.Background(),
+		bson.M{"_id": alert.ID},
+		bson.M{"$set": bson.M{"isActive": false, "error": reason.Error()}},
+	)
+	if err != nil {
+		log.Printf("Failed to disable alert %s: %v", alert.ID.Hex(), err)
+	}
+}
+
+// Alert Execution
+
+// errAlreadyTriggered aborts a transaction that lost the race to trigger an
+// alert
+var errAlreadyTriggered = errors.New("alert already triggered")
+
+// claimFilter matches the alert only in the state it was read in, so of two
+// events evaluating the same alert at once only one can change it
+func claimFilter(alert Alert) bson.M {
+	filter := bson.M{"_id": alert.ID, "isActive": true, "triggerCount": alert.TriggerCount}
+	if alert.TriggerCount == 0 {
+		// Alerts created before triggerCount existed have no such field
+		filter["triggerCount"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	if alert.Recurring {
+		filter["awaitingRearm"] = alert.AwaitingRearm
+		if !alert.AwaitingRearm {
+			filter["awaitingRearm"] = bson.M{"$ne": true}
+		}
+	}
+	return filter
+}
+
+// executeAlert marks the alert triggered and queues the user's
+// notifications in the outbox in a single transaction. The update is a
+// compare-and-set on the state the alert was read in, so concurrent events
+// trigger it at most once, and the notifications are never lost.
+func (e *Engine) executeAlert(alert Alert, r reading, market Market) {
+	// Step 1: Mark alert as inactive (prevent duplicate triggers).
+	// Recurring alerts stay active but wait to be re-armed instead.
+	now := time.Now()
+	set := bson.M{
+		"triggeredAt":     now,
+		"lastTriggeredAt": now,
+	}
+	if alert.Recurring {
+		set["awaitingRearm"] = true
+	} else {
+		set["isActive"] = false
+	}
+
+	// Step 2: Queue the user's notifications in the same transaction
+	message := alertTitle(alert.Condition) + ": " + describeTrigger(alert, r, market)
+	if alert.Recurring {
+		message += fmt.Sprintf(" - triggered %d times", alert.TriggerCount+1)
+	}
+
+	msg := AlertMessage{
+		Type:         "alert.triggered",
+		AlertID:      alert.ID.Hex(),
+		UserID:       alert.User.Hex(),
+		Symbol:       alert.Symbol,
+		Condition:    alert.Condition,
+		Title:        alertTitle(alert.Condition),
+		Message:      message,
+		Value:        r.value,
+		Threshold:    r.threshold,
+		TriggerCount: alert.TriggerCount + 1,
+		TriggeredAt:  now,
+	}
+
+	ctx := context.Background()
+	recipient := e.notifier.Recipient(ctx, alert.User)
+
+	session, err := e.alerts.Database().Client().StartSession()
+	if err != nil {
+		log.Printf("Failed to start session for alert %s: %v", alert.ID.Hex(), err)
+		return
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		claimed, err := e.alerts.UpdateOne(sc, claimFilter(alert), bson.M{
+			"$set": set,
+			"$inc": bson.M{"triggerCount": 1},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if claimed.ModifiedCount == 0 {
+			return nil, errAlreadyTriggered
+		}
+
+		entries := e.notifier.Entries(recipient, alert, msg)
+		if len(entries) == 0 {
+			return nil, nil
+		}
+		_, err = e.notifier.outbox.InsertMany(sc, entries)
+		return nil, err
+	})
+
+	switch {
+	case errors.Is(err, errAlreadyTriggered):
+		return
+	case err != nil:
+		log.Printf("Failed to trigger alert %s for user %s: %v", alert.ID.Hex(), alert.User.Hex(), err)
+		return
+	}
+
+	// Update the index now rather than when the change stream catches up
+	if alert.Recurring {
+		alert.AwaitingRearm = true
+		alert.TriggerCount++
+		alert.LastTriggeredAt = &now
+		e.index.Put(alert)
+	} else {
+		e.index.Remove(alert.ID)
+	}
+
+	e.notifier.Wake()
+
+	fmt.Printf("Alert Triggered! %s %s: %.2f vs threshold %.2f (User: %s)\n",
+		alert.Symbol, alert.Condition, r.value, r.threshold, alert.User.Hex())
+}
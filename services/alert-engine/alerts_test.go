@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testDatabase connects to ALERT_TEST_MONGODB_URI and returns a fresh
+// database that is dropped after the test. Transactions need a replica set,
+// so the test is skipped without one.
+func testDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("ALERT_TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("ALERT_TEST_MONGODB_URI not set (needs a replica set, e.g. mongodb://localhost:27017/?replicaSet=rs0)")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	var hello bson.M
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		t.Skipf("MongoDB not reachable: %v", err)
+	}
+	if _, ok := hello["setName"]; !ok {
+		t.Skip("MongoDB is not a replica set, transactions are unavailable")
+	}
+
+	db := client.Database(fmt.Sprintf("alertengine_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() { db.Drop(context.Background()) })
+	return db
+}
+
+// nopChannel accepts every message; the test only looks at the outbox
+type nopChannel struct{ name string }
+
+func (c nopChannel) Name() string { return c.name }
+
+func (c nopChannel) Send(context.Context, Recipient, AlertMessage) error { return nil }
+
+func TestExecuteAlertTriggersOnce(t *testing.T) {
+	db := testDatabase(t)
+	ctx := context.Background()
+
+	notifier := &Notifier{
+		users:       db.Collection("users"),
+		outbox:      db.Collection(outboxCollection),
+		deadLetters: db.Collection(deadLetterCollection),
+		channels: map[string]Channel{
+			ChannelInApp:  nopChannel{ChannelInApp},
+			ChannelEmail:  nopChannel{ChannelEmail},
+			ChannelSocket: nopChannel{ChannelSocket},
+		},
+		cfg:  loadDeliveryConfig(),
+		wake: make(chan struct{}, 1),
+	}
+	engine := NewEngine(db, notifier)
+	defer engine.pool.Close()
+
+	// Email and in-app, but not socket
+	user := primitive.NewObjectID()
+	if _, err := notifier.users.InsertOne(ctx, bson.M{
+		"_id":           user,
+		"username":      "alice",
+		"email":         "alice@example.com",
+		"alertChannels": bson.A{ChannelInApp, ChannelEmail},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	wantChannels := []string{ChannelInApp, ChannelEmail}
+
+	cases := []struct {
+		name  string
+		alert bson.M
+	}{
+		{"triggerCount 0", bson.M{"triggerCount": 0}},
+		{"without triggerCount", bson.M{}}, // created before the field existed
+		{"recurring", bson.M{"triggerCount": 0, "recurring": true, "awaitingRearm": false}},
+	}
+
+	const events = 16
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := bson.M{
+				"_id":         primitive.NewObjectID(),
+				"user":        user,
+				"symbol":      "AAPL",
+				"condition":   ConditionAbove,
+				"targetPrice": 100.0,
+				"isActive":    true,
+			}
+			for k, v := range tc.alert {
+				doc[k] = v
+			}
+			if _, err := engine.alerts.InsertOne(ctx, doc); err != nil {
+				t.Fatal(err)
+			}
+
+			// Every event read the alert in the same state
+			var alert Alert
+			if err := engine.alerts.FindOne(ctx, bson.M{"_id": doc["_id"]}).Decode(&alert); err != nil {
+				t.Fatal(err)
+			}
+			market := Market{Symbol: "AAPL", Events: EventPrice, Price: 101, At: time.Now()}
+			r := reading{value: 101, threshold: 100, rising: true}
+
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			for i := 0; i < events; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					engine.executeAlert(alert, r, market)
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			var stored Alert
+			if err := engine.alerts.FindOne(ctx, bson.M{"_id": alert.ID}).Decode(&stored); err != nil {
+				t.Fatal(err)
+			}
+			if stored.TriggerCount != 1 {
+				t.Errorf("triggerCount = %d, want 1", stored.TriggerCount)
+			}
+			if alert.Recurring {
+				if !stored.IsActive || !stored.AwaitingRearm {
+					t.Errorf("recurring alert isActive=%v awaitingRearm=%v, want active and awaiting re-arm", stored.IsActive, stored.AwaitingRearm)
+				}
+			} else if stored.IsActive {
+				t.Error("alert still active after triggering")
+			}
+
+			for _, channel := range wantChannels {
+				n, err := notifier.outbox.CountDocuments(ctx, bson.M{"alert": alert.ID, "channel": channel})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != 1 {
+					t.Errorf("%d %s outbox entries, want 1", n, channel)
+				}
+			}
+			total, err := notifier.outbox.CountDocuments(ctx, bson.M{"alert": alert.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != int64(len(wantChannels)) {
+				t.Errorf("%d outbox entries, want %d", total, len(wantChannels))
+			}
+		})
+	}
+}
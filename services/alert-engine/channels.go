@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// InAppChannel writes to the notifications collection shown in the app.
+// The notification takes the delivery's id, so a retry can't add it twice.
+type InAppChannel struct {
+	notifications *mongo.Collection
+}
+
+func NewInAppChannel(db *mongo.Database) *InAppChannel {
+	return &InAppChannel{notifications: db.Collection("notifications")}
+}
+
+func (c *InAppChannel) Name() string { return ChannelInApp }
+
+func (c *InAppChannel) Send(ctx context.Context, to Recipient, msg AlertMessage) error {
+	id, _ := primitive.ObjectIDFromHex(msg.DeliveryID)
+	_, err := c.notifications.InsertOne(ctx, Notification{
+		ID:        id,
+		Recipient: to.ID,
+		Type:      "PRICE_ALERT",
+		Content:   msg.Message,
+		IsRead:    false,
+		CreatedAt: msg.TriggeredAt,
+		UpdatedAt: msg.TriggeredAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// EmailChannel sends plain text mail over SMTP, configured with the same
+// variables as the API server: SMTP_HOST, SMTP_PORT, SMTP_USER, SMTP_PASS,
+// FROM_NAME and FROM_EMAIL. Port 465 uses implicit TLS; otherwise STARTTLS
+// is used when the server offers it.
+type EmailChannel struct {
+	host     string
+	port     string
+	auth     smtp.Auth
+	from     string // envelope sender
+	fromLine string // From header
+}
+
+// NewEmailChannelFromEnv returns nil when SMTP_HOST is not set
+func NewEmailChannelFromEnv() *EmailChannel {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	name := os.Getenv("FROM_NAME")
+	if name == "" {
+		name = "StockForumX"
+	}
+	from := os.Getenv("FROM_EMAIL")
+	if from == "" {
+		from = "noreply@stockforumx.com"
+	}
+
+	c := &EmailChannel{
+		host:     host,
+		port:     port,
+		from:     from,
+		fromLine: fmt.Sprintf("%s <%s>", name, from),
+	}
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		c.auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASS"), host)
+	}
+	return c
+}
+
+func (c *EmailChannel) Name() string { return ChannelEmail }
+
+func (c *EmailChannel) Send(ctx context.Context, to Recipient, msg AlertMessage) error {
+	if to.Email == "" {
+		return permanent(errors.New("user has no email address"))
+	}
+
+	addr := net.JoinHostPort(c.host, c.port)
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if c.port == "465" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: c.host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, c.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: c.host}); err != nil {
+			return err
+		}
+	}
+	if c.auth != nil {
+		if err := client.Auth(c.auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(c.from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Email); err != nil {
+		var reply *textproto.Error
+		if errors.As(err, &reply) && reply.Code >= 500 {
+			return permanent(err) // mailbox rejected
+		}
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(c.message(to, msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// headerSafe strips line breaks, so stored values can't inject headers
+var headerSafe = strings.NewReplacer("\r", "", "\n", "")
+
+func (c *EmailChannel) message(to Recipient, msg AlertMessage) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", c.fromLine)
+	fmt.Fprintf(&b, "To: %s\r\n", headerSafe.Replace(to.Email))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerSafe.Replace(msg.Title+": "+msg.Symbol))
+	fmt.Fprintf(&b, "Date: %s\r\n", msg.TriggeredAt.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+
+	greeting := "Hi"
+	if to.Username != "" {
+		greeting += " " + to.Username
+	}
+	fmt.Fprintf(&b, "%s,\r\n\r\n%s\r\n\r\n", greeting, msg.Message)
+	b.WriteString("You are receiving this because you set a price alert on StockForumX.\r\n")
+	return b.Bytes()
+}
+
+// WebhookChannel POSTs the message as JSON to the user's webhook URL. The
+// body is signed with HMAC-SHA256 over "<timestamp>.<body>" using the
+// user's webhook secret, or ALERT_WEBHOOK_SECRET if they have none:
+//
+//	X-StockForumX-Timestamp: 1700000000
+//	X-StockForumX-Signature: sha256=<hex digest>
+//
+// Receivers should recompute the digest and reject old timestamps.
+//
+// URLs are user supplied, so the channel refuses to connect to loopback,
+// private, link-local (cloud metadata) and other internal addresses, checked
+// on the resolved IP at connect time so DNS can't be used to get around it,
+// and doesn't follow redirects. ALERT_WEBHOOK_ALLOW_PRIVATE=true lifts the
+// address check for local development, e.g. with the stubs command.
+type WebhookChannel struct {
+	client *http.Client
+	secret string
+}
+
+// NewWebhookChannelFromEnv reads ALERT_WEBHOOK_SECRET, ALERT_WEBHOOK_TIMEOUT
+// (default 10s) and ALERT_WEBHOOK_ALLOW_PRIVATE
+func NewWebhookChannelFromEnv() *WebhookChannel {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if os.Getenv("ALERT_WEBHOOK_ALLOW_PRIVATE") != "true" {
+		dialer.Control = publicAddressOnly
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // a proxy would connect on our behalf, unchecked
+	transport.DialContext = dialer.DialContext
+
+	return &WebhookChannel{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   envDuration("ALERT_WEBHOOK_TIMEOUT", 10*time.Second),
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		secret: os.Getenv("ALERT_WEBHOOK_SECRET"),
+	}
+}
+
+// blockedAddressError is returned when a webhook resolves to an internal
+// address
+type blockedAddressError struct{ addr netip.Addr }
+
+func (e blockedAddressError) Error() string {
+	return fmt.Sprintf("webhook address %s is not public", e.addr)
+}
+
+// Ranges IsGlobalUnicast lets through that must not be reached either:
+// "this network", carrier-grade NAT (also used inside some clusters) and
+// reserved blocks. server/utils/webhookUrl.js rejects the same ranges.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// publicAddressOnly is a net.Dialer Control hook that rejects connections
+// to addresses that aren't publicly routable
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	addr := ap.Addr().Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return blockedAddressError{addr}
+	}
+	for _, prefix := range nonPublic {
+		if prefix.Contains(addr) {
+			return blockedAddressError{addr}
+		}
+	}
+	return nil
+}
+
+func (c *WebhookChannel) Name() string { return ChannelWebhook }
+
+func (c *WebhookChannel) Send(ctx context.Context, to Recipient, msg AlertMessage) error {
+	if to.Webhook.URL == "" {
+		return permanent(errors.New("user has no webhook URL"))
+	}
+	secret := to.Webhook.Secret
+	if secret == "" {
+		secret = c.secret
+	}
+	if secret == "" {
+		return permanent(errors.New("no webhook secret to sign with"))
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, to.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "StockForumX-Alerts/1.0")
+	req.Header.Set("X-StockForumX-Event", msg.Type)
+	req.Header.Set("X-StockForumX-Delivery", msg.DeliveryID)
+	req.Header.Set("X-StockForumX-Timestamp", timestamp)
+	req.Header.Set("X-StockForumX-Signature", "sha256="+signWebhook(secret, timestamp, body))
+
+	resp, err := c.client.Do(req)
+	var blocked blockedAddressError
+	if errors.As(err, &blocked) {
+		return permanent(blocked)
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 300 && resp.StatusCode < 400:
+		return permanent(fmt.Errorf("webhook redirected (%s); redirects are not followed", resp.Status))
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return permanent(fmt.Errorf("webhook rejected the delivery: %s", resp.Status))
+	default:
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
+
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RedisChannel publishes the message to ALERT_REDIS_CHANNEL (default
+// alerts:notifications); the API server relays it to the user's socket room
+type RedisChannel struct {
+	client  *redis.Client
+	channel string
+}
+
+// NewRedisChannelFromEnv returns nil when REDIS_URL is not set
+func NewRedisChannelFromEnv() (*RedisChannel, error) {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		return nil, nil
+	}
+
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+
+	channel := os.Getenv("ALERT_REDIS_CHANNEL")
+	if channel == "" {
+		channel = "alerts:notifications"
+	}
+
+	return &RedisChannel{client: redis.NewClient(opts), channel: channel}, nil
+}
+
+func (c *RedisChannel) Name() string { return ChannelSocket }
+
+func (c *RedisChannel) Send(ctx context.Context, to Recipient, msg AlertMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.client.Publish(ctx, c.channel, data).Err()
+}
+
+func (c *RedisChannel) Close() error {
+	return c.client.Close()
+}
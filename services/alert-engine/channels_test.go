@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPublicAddressOnly(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:80", false},
+		{"10.1.2.3:80", false},
+		{"172.16.0.1:80", false},
+		{"192.168.1.1:80", false},
+		{"169.254.169.254:80", false}, // cloud metadata
+		{"100.64.0.1:80", false},
+		{"0.0.0.0:80", false},
+		{"224.0.0.1:80", false},
+		{"[::1]:80", false},
+		{"[fd00:ec2::254]:80", false},
+		{"[fe80::1]:80", false},
+		{"[::ffff:127.0.0.1]:80", false},
+	}
+	for _, tt := range tests {
+		err := publicAddressOnly("tcp", tt.address, nil)
+		if (err == nil) != tt.allowed {
+			t.Errorf("publicAddressOnly(%s) = %v, want allowed %v", tt.address, err, tt.allowed)
+		}
+	}
+}
+
+func TestWebhookRefusesInternalAddressesAndRedirects(t *testing.T) {
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	to := Recipient{}
+	to.Webhook.URL = server.URL
+	to.Webhook.Secret = "secret"
+	msg := AlertMessage{Type: "alert.triggered", TriggeredAt: time.Now()}
+	ctx := context.Background()
+
+	t.Setenv("ALERT_WEBHOOK_ALLOW_PRIVATE", "")
+	err := NewWebhookChannelFromEnv().Send(ctx, to, msg)
+	if err == nil || !isPermanent(err) || hits != 0 {
+		t.Fatalf("loopback webhook: err = %v, hits = %d; want a permanent error and no request", err, hits)
+	}
+
+	t.Setenv("ALERT_WEBHOOK_ALLOW_PRIVATE", "true")
+	local := NewWebhookChannelFromEnv()
+	if err := local.Send(ctx, to, msg); err != nil {
+		t.Fatalf("with ALERT_WEBHOOK_ALLOW_PRIVATE: %v", err)
+	}
+
+	to.Webhook.URL = server.URL + "/redirect"
+	err = local.Send(ctx, to, msg)
+	if err == nil || !isPermanent(err) || hits != 2 {
+		t.Fatalf("redirect: err = %v, hits = %d; want a permanent error without following", err, hits)
+	}
+}
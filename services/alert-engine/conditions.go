@@ -0,0 +1,516 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Alert conditions
+const (
+	ConditionAbove       = "ABOVE"        // price at or above TargetPrice
+	ConditionBelow       = "BELOW"        // price at or below TargetPrice
+	ConditionPercentUp   = "PERCENT_UP"   // up Percent from the previous close
+	ConditionPercentDown = "PERCENT_DOWN" // down Percent from the previous close
+	ConditionMoveUp      = "MOVE_UP"      // up Percent from the low of the last WindowMinutes
+	ConditionMoveDown    = "MOVE_DOWN"    // down Percent from the high of the last WindowMinutes
+	ConditionGapUp       = "GAP_UP"       // session opened Percent above the previous close
+	ConditionGapDown     = "GAP_DOWN"     // session opened Percent below the previous close
+	ConditionHigh52W     = "HIGH_52W"     // trading at a new 52-week high
+	ConditionLow52W      = "LOW_52W"      // trading at a new 52-week low
+
+	ConditionIndicatorAbove = "INDICATOR_ABOVE" // Indicator at or above Level, e.g. RSI14 above 70
+	ConditionIndicatorBelow = "INDICATOR_BELOW" // Indicator at or below Level, e.g. RSI14 below 30
+	ConditionCrossAbove     = "CROSS_ABOVE"     // Indicator crosses above CompareTo, e.g. SMA50 over SMA200
+	ConditionCrossBelow     = "CROSS_BELOW"     // Indicator crosses below CompareTo
+
+	ConditionExpression = "EXPRESSION" // Expression holds, e.g. "AAPL > 200 AND sentimentLabel == Bullish"
+
+	ConditionSentimentChange = "SENTIMENT_CHANGE" // sentiment label changes, to Label if set
+	ConditionQuestionSpike   = "QUESTION_SPIKE"   // at least PerHour questions in the last hour
+)
+
+// EventKind is what an event changed; a stock update can be several kinds
+type EventKind int
+
+const (
+	EventPrice EventKind = 1 << iota
+	EventSentiment
+	EventQuestion
+)
+
+// eventConditions lists the conditions evaluated for each kind of event
+var eventConditions = map[EventKind][]string{
+	EventPrice: {
+		ConditionAbove, ConditionBelow,
+		ConditionPercentUp, ConditionPercentDown, ConditionMoveUp, ConditionMoveDown,
+		ConditionGapUp, ConditionGapDown, ConditionHigh52W, ConditionLow52W,
+		ConditionIndicatorAbove, ConditionIndicatorBelow, ConditionCrossAbove, ConditionCrossBelow,
+		ConditionExpression,
+	},
+	EventSentiment: {ConditionSentimentChange, ConditionExpression},
+	EventQuestion:  {ConditionQuestionSpike},
+}
+
+// conditionsFor returns the conditions affected by the given events
+func conditionsFor(events EventKind) []string {
+	seen := make(map[string]bool)
+	var list []string
+	for kind, conditions := range eventConditions {
+		if events&kind == 0 {
+			continue
+		}
+		for _, c := range conditions {
+			if !seen[c] {
+				seen[c] = true
+				list = append(list, c)
+			}
+		}
+	}
+	return list
+}
+
+// Market is the state of a stock at one event
+type Market struct {
+	Symbol            string
+	StockID           primitive.ObjectID
+	Events            EventKind
+	Price             float64
+	PreviousClose     float64
+	FiftyTwoWeekHigh  float64
+	FiftyTwoWeekLow   float64
+	SentimentLabel    string
+	PreviousSentiment string // label before this event; empty if unknown
+	At                time.Time
+	Fields            bson.M // the full stock document, for rule expressions
+
+	// Lowest and highest price since the symbol was last evaluated, when
+	// price events were coalesced; zero means just Price
+	Low, High float64
+
+	// The stock's last applied split; prices observed before it was applied
+	// aren't comparable with Price
+	Split Split
+
+	done []func() // called once the event is processed, for stream checkpoints
+}
+
+// Split is a stock split applied by the price-updater (the stock's
+// lastSplit field)
+type Split struct {
+	Ratio     float64
+	AppliedAt time.Time
+}
+
+// adjust restates a price observed at the given time in post-split terms
+func (s Split) adjust(at time.Time, price float64) float64 {
+	if s.Ratio > 0 && at.Before(s.AppliedAt) {
+		return price / s.Ratio
+	}
+	return price
+}
+
+// PriceRange returns the lowest and highest price the event covers
+func (m Market) PriceRange() (low, high float64) {
+	low, high = m.Price, m.Price
+	if m.Low > 0 && m.Low < low {
+		low = m.Low
+	}
+	if m.High > high {
+		high = m.High
+	}
+	return low, high
+}
+
+// SentimentChanged reports whether the event moved the sentiment label
+func (m Market) SentimentChanged() bool {
+	return m.PreviousSentiment != "" && m.SentimentLabel != m.PreviousSentiment
+}
+
+func marketFromDocument(doc bson.M, at time.Time) (Market, bool) {
+	symbol, ok := doc["symbol"].(string)
+	if !ok {
+		return Market{}, false
+	}
+
+	m := Market{
+		Symbol:           symbol,
+		Price:            number(doc, "currentPrice"),
+		PreviousClose:    number(doc, "previousClose"),
+		FiftyTwoWeekHigh: number(doc, "fiftyTwoWeekHigh"),
+		FiftyTwoWeekLow:  number(doc, "fiftyTwoWeekLow"),
+		At:               at,
+		Fields:           doc,
+	}
+	m.StockID, _ = doc["_id"].(primitive.ObjectID)
+	m.SentimentLabel, _ = doc["sentimentLabel"].(string)
+	if split, ok := doc["lastSplit"].(bson.M); ok {
+		m.Split.Ratio = number(split, "ratio")
+		if appliedAt, ok := split["appliedAt"].(primitive.DateTime); ok {
+			m.Split.AppliedAt = appliedAt.Time()
+		}
+	}
+	return m, m.Price > 0
+}
+
+// number reads a numeric field whatever BSON number type it was stored as
+func number(doc bson.M, key string) float64 {
+	switch v := doc[key].(type) {
+	case float64:
+		return v
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return 0
+}
+
+// reading is the measured value of an alert's condition. Rising conditions
+// are met at or above the threshold, falling ones at or below it.
+type reading struct {
+	value     float64
+	threshold float64
+	rising    bool
+	band      float64   // how far back past the threshold re-arms the alert
+	since     time.Time // start of the period measured; set for once-a-session conditions
+
+	// Crossovers are only met if the value was on the other side of the
+	// threshold at the last closed bar
+	crossing bool
+	prior    float64
+
+	// Price readings over a range of prices measure value at the price
+	// nearest the threshold and retreat at the one furthest back from it
+	ranged  bool
+	retreat float64
+}
+
+func (r reading) met() bool {
+	if r.rising {
+		return r.value >= r.threshold && (!r.crossing || r.prior < r.threshold)
+	}
+	return r.value <= r.threshold && (!r.crossing || r.prior > r.threshold)
+}
+
+// rearmed reports whether the value has moved back past the threshold by
+// the re-arm band, so a value hovering around the threshold doesn't fire on
+// every tick. Once-a-session conditions re-arm in the next session.
+func (r reading) rearmed(alert Alert) bool {
+	if !r.since.IsZero() && alert.LastTriggeredAt != nil && alert.LastTriggeredAt.Before(r.since) {
+		return true
+	}
+	value := r.value
+	if r.ranged {
+		value = r.retreat
+	}
+	if r.rising {
+		return value < r.threshold-r.band
+	}
+	return value > r.threshold+r.band
+}
+
+// priceReading measures a price condition over every price since the
+// symbol was last evaluated, so a threshold crossed by a price that was
+// coalesced away still counts
+func priceReading(m Market, rising bool, read func(price float64) reading) reading {
+	toward, away := m.PriceRange()
+	if rising {
+		toward, away = away, toward
+	}
+	r := read(toward)
+	r.ranged = true
+	r.retreat = read(away).value
+	return r
+}
+
+// readCondition measures an alert's condition at a price event. ok is false
+// when there isn't enough data yet, such as no previous close or an empty
+// window.
+func (e *Engine) readCondition(ctx context.Context, alert Alert, m Market) (reading, bool) {
+	switch alert.Condition {
+	case ConditionAbove, ConditionBelow:
+		rising := alert.Condition == ConditionAbove
+		return priceReading(m, rising, func(price float64) reading {
+			return reading{
+				value:     price,
+				threshold: alert.TargetPrice,
+				rising:    rising,
+				band:      alert.TargetPrice * alert.RearmPercent / 100,
+			}
+		}), true
+
+	case ConditionPercentUp, ConditionPercentDown:
+		if m.PreviousClose <= 0 {
+			return reading{}, false
+		}
+		return priceReading(m, alert.Condition == ConditionPercentUp, func(price float64) reading {
+			return percentReading(alert, percentChange(m.PreviousClose, price))
+		}), true
+
+	case ConditionMoveUp, ConditionMoveDown:
+		window := time.Duration(alert.WindowMinutes * float64(time.Minute))
+		if window <= 0 {
+			return reading{}, false
+		}
+		low, high, ok := e.history.Range(ctx, m.Symbol, m.At.Add(-window))
+		if !ok {
+			return reading{}, false
+		}
+		from := low
+		if alert.Condition == ConditionMoveDown {
+			from = high
+		}
+		return priceReading(m, alert.Condition == ConditionMoveUp, func(price float64) reading {
+			return percentReading(alert, percentChange(from, price))
+		}), true
+
+	case ConditionGapUp, ConditionGapDown:
+		// Gaps are only reported around the open, not all session long
+		open, ok := e.history.SessionOpen(ctx, m.Symbol, m.At)
+		if !ok || m.PreviousClose <= 0 || m.At.Sub(open.At) > e.history.gapWindow {
+			return reading{}, false
+		}
+		r := percentReading(alert, percentChange(m.PreviousClose, open.Price))
+		r.since = open.At
+		return r, true
+
+	case ConditionHigh52W:
+		if m.FiftyTwoWeekHigh <= 0 {
+			return reading{}, false
+		}
+		return priceReading(m, true, func(price float64) reading {
+			return reading{
+				value:     price,
+				threshold: m.FiftyTwoWeekHigh,
+				rising:    true,
+				band:      m.FiftyTwoWeekHigh * alert.RearmPercent / 100,
+			}
+		}), true
+
+	case ConditionLow52W:
+		if m.FiftyTwoWeekLow <= 0 {
+			return reading{}, false
+		}
+		return priceReading(m, false, func(price float64) reading {
+			return reading{
+				value:     price,
+				threshold: m.FiftyTwoWeekLow,
+				band:      m.FiftyTwoWeekLow * alert.RearmPercent / 100,
+			}
+		}), true
+
+	case ConditionIndicatorAbove, ConditionIndicatorBelow:
+		if err := e.indicators.Validate(alert.Indicator); err != nil {
+			e.disableAlert(alert, err)
+			return reading{}, false
+		}
+		_, value, ok := e.indicators.Read(ctx, m.Symbol, alert.Indicator)
+		if !ok {
+			return reading{}, false
+		}
+		return reading{
+			value:     value,
+			threshold: alert.Level,
+			rising:    alert.Condition == ConditionIndicatorAbove,
+			band:      math.Abs(alert.Level) * alert.RearmPercent / 100,
+		}, true
+
+	case ConditionCrossAbove, ConditionCrossBelow:
+		// Measured as the spread between the two lines, crossing zero
+		for _, name := range []string{alert.Indicator, alert.CompareTo} {
+			if err := e.indicators.Validate(name); err != nil {
+				e.disableAlert(alert, err)
+				return reading{}, false
+			}
+		}
+		prior, value, ok := e.indicators.Read(ctx, m.Symbol, alert.Indicator)
+		if !ok {
+			return reading{}, false
+		}
+		otherPrior, other, ok := e.indicators.Read(ctx, m.Symbol, alert.CompareTo)
+		if !ok {
+			return reading{}, false
+		}
+		return reading{
+			value:    value - other,
+			rising:   alert.Condition == ConditionCrossAbove,
+			band:     math.Abs(other) * alert.RearmPercent / 100,
+			crossing: true,
+			prior:    prior - otherPrior,
+		}, true
+
+	case ConditionExpression:
+		rule, err := e.rule(alert)
+		if err != nil {
+			e.disableAlert(alert, fmt.Errorf("invalid rule: %w", err))
+			return reading{}, false
+		}
+		holds, ok := rule.Eval(&marketEnv{ctx: ctx, engine: e, market: m})
+		if !ok {
+			return reading{}, false
+		}
+		return flagReading(holds), true
+
+	case ConditionSentimentChange:
+		if m.Events&EventSentiment == 0 {
+			return reading{}, false
+		}
+		changed := m.SentimentChanged() && (alert.Label == "" || strings.EqualFold(alert.Label, m.SentimentLabel))
+		return flagReading(changed), true
+
+	case ConditionQuestionSpike:
+		if alert.PerHour <= 0 || m.StockID.IsZero() {
+			return reading{}, false
+		}
+		n, err := e.questionsSince(ctx, m.StockID, m.At.Add(-questionWindow))
+		if err != nil {
+			log.Printf("Question count failed for %s: %v", m.Symbol, err)
+			return reading{}, false
+		}
+		return reading{
+			value:     float64(n),
+			threshold: alert.PerHour,
+			rising:    true,
+			band:      alert.PerHour * alert.RearmPercent / 100,
+		}, true
+	}
+	return reading{}, false
+}
+
+// flagReading reads 1 while a yes/no condition holds, so it re-arms once the
+// condition stops holding
+func flagReading(holds bool) reading {
+	r := reading{threshold: 1, rising: true}
+	if holds {
+		r.value = 1
+	}
+	return r
+}
+
+// rule returns the parsed rule of an EXPRESSION alert, parsing each distinct
+// expression once
+func (e *Engine) rule(alert Alert) (*Rule, error) {
+	key := alert.Symbol + "\x00" + alert.Expression
+
+	e.rulesMu.Lock()
+	defer e.rulesMu.Unlock()
+
+	if cached, ok := e.rules[key]; ok {
+		return cached.rule, cached.err
+	}
+	rule, err := ParseRule(alert.Expression, alert.Symbol)
+	if err == nil {
+		for _, name := range rule.indicators {
+			if err = e.indicators.Validate(name); err != nil {
+				rule = nil
+				break
+			}
+		}
+	}
+	e.rules[key] = parsedRule{rule: rule, err: err}
+	return rule, err
+}
+
+type parsedRule struct {
+	rule *Rule
+	err  error
+}
+
+// marketEnv evaluates rules against the stock document of a price event
+type marketEnv struct {
+	ctx    context.Context
+	engine *Engine
+	market Market
+}
+
+func (env *marketEnv) field(name string) (ruleValue, bool) {
+	v, ok := env.market.Fields[name]
+	if !ok || v == nil {
+		return ruleValue{}, false
+	}
+	if ruleFields[name] == typeText {
+		text, ok := v.(string)
+		return ruleValue{text: text}, ok
+	}
+	return ruleValue{num: number(env.market.Fields, name)}, true
+}
+
+func (env *marketEnv) indicator(name string) (float64, bool) {
+	_, value, ok := env.engine.indicators.Read(env.ctx, env.market.Symbol, name)
+	return value, ok
+}
+
+func (env *marketEnv) avgVolume() (float64, bool) {
+	return env.engine.history.AverageVolume(env.ctx, env.market.Symbol, env.market.At)
+}
+
+// percentReading compares a percentage change with the alert's Percent. For
+// these conditions RearmPercent is in percentage points.
+func percentReading(alert Alert, change float64) reading {
+	r := reading{
+		value:     change,
+		threshold: math.Abs(alert.Percent),
+		rising:    true,
+		band:      alert.RearmPercent,
+	}
+	switch alert.Condition {
+	case ConditionPercentDown, ConditionMoveDown, ConditionGapDown:
+		r.threshold = -r.threshold
+		r.rising = false
+	}
+	return r
+}
+
+func percentChange(from, to float64) float64 {
+	if from <= 0 {
+		return 0
+	}
+	return (to - from) / from * 100
+}
+
+// alertTitle heads the notification of a triggered alert
+func alertTitle(condition string) string {
+	switch condition {
+	case ConditionSentimentChange:
+		return "Sentiment Alert"
+	case ConditionQuestionSpike:
+		return "Discussion Alert"
+	}
+	return "Price Alert"
+}
+
+// describeTrigger is the notification text for a triggered alert
+func describeTrigger(alert Alert, r reading, m Market) string {
+	switch alert.Condition {
+	case ConditionSentimentChange:
+		return fmt.Sprintf("%s sentiment turned %s (was %s)", m.Symbol, m.SentimentLabel, m.PreviousSentiment)
+	case ConditionQuestionSpike:
+		return fmt.Sprintf("%s has %.0f new questions in the last hour", m.Symbol, r.value)
+	case ConditionPercentUp, ConditionPercentDown:
+		return fmt.Sprintf("%s is %+.2f%% from the previous close at $%.2f", m.Symbol, r.value, m.Price)
+	case ConditionMoveUp, ConditionMoveDown:
+		return fmt.Sprintf("%s moved %+.2f%% in the last %g minutes to $%.2f", m.Symbol, r.value, alert.WindowMinutes, m.Price)
+	case ConditionGapUp, ConditionGapDown:
+		return fmt.Sprintf("%s opened with a %+.2f%% gap, now $%.2f", m.Symbol, r.value, m.Price)
+	case ConditionHigh52W:
+		return fmt.Sprintf("%s hit a new 52-week high at $%.2f", m.Symbol, r.value)
+	case ConditionLow52W:
+		return fmt.Sprintf("%s hit a new 52-week low at $%.2f", m.Symbol, r.value)
+	case ConditionIndicatorAbove, ConditionIndicatorBelow:
+		return fmt.Sprintf("%s %s is at %.2f (Level: %.2f), price $%.2f", m.Symbol, alert.Indicator, r.value, alert.Level, m.Price)
+	case ConditionCrossAbove:
+		return fmt.Sprintf("%s %s crossed above %s at $%.2f", m.Symbol, alert.Indicator, alert.CompareTo, m.Price)
+	case ConditionCrossBelow:
+		return fmt.Sprintf("%s %s crossed below %s at $%.2f", m.Symbol, alert.Indicator, alert.CompareTo, m.Price)
+	case ConditionExpression:
+		return fmt.Sprintf("%s matched \"%s\" at $%.2f", m.Symbol, alert.Expression, m.Price)
+	}
+	return fmt.Sprintf("%s has hit $%.2f (Target: $%.2f)", m.Symbol, r.value, alert.TargetPrice)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Delivery channels a user can choose in alertChannels
+const (
+	ChannelInApp   = "inApp"   // notifications collection, shown in the app
+	ChannelEmail   = "email"   // SMTP, configured like server/utils/email.js
+	ChannelWebhook = "webhook" // signed POST to the user's alertWebhook.url
+	ChannelSocket  = "socket"  // Redis publish, relayed by the API server's sockets
+)
+
+// defaultChannels apply to users who never set alertChannels
+var defaultChannels = []string{ChannelInApp, ChannelSocket}
+
+// AlertMessage describes a triggered alert. Channels other than in-app send
+// it as JSON.
+type AlertMessage struct {
+	DeliveryID   string    `json:"deliveryId" bson:"-"` // the outbox entry, same on every retry
+	Type         string    `json:"type" bson:"type"`    // always "alert.triggered"
+	AlertID      string    `json:"alertId" bson:"alertId"`
+	UserID       string    `json:"userId" bson:"userId"`
+	Symbol       string    `json:"symbol" bson:"symbol"`
+	Condition    string    `json:"condition" bson:"condition"`
+	Title        string    `json:"title" bson:"title"`
+	Message      string    `json:"message" bson:"message"`
+	Value        float64   `json:"value" bson:"value"`
+	Threshold    float64   `json:"threshold" bson:"threshold"`
+	TriggerCount int       `json:"triggerCount" bson:"triggerCount"`
+	TriggeredAt  time.Time `json:"triggeredAt" bson:"triggeredAt"`
+}
+
+// Recipient is a user with their delivery preferences
+type Recipient struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	Username string             `bson:"username"`
+	Email    string             `bson:"email"`
+	Channels []string           `bson:"alertChannels"`
+	Webhook  struct {
+		URL    string `bson:"url"`
+		Secret string `bson:"secret"`
+	} `bson:"alertWebhook"`
+}
+
+// wants reports whether the user chose the channel and can be reached on it
+func (r Recipient) wants(channel string) bool {
+	channels := r.Channels
+	if channels == nil {
+		channels = defaultChannels
+	}
+
+	chosen := false
+	for _, c := range channels {
+		chosen = chosen || c == channel
+	}
+	switch channel {
+	case ChannelEmail:
+		return chosen && r.Email != ""
+	case ChannelWebhook:
+		return chosen && r.Webhook.URL != ""
+	}
+	return chosen
+}
+
+// Channel delivers alert messages one way
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, to Recipient, msg AlertMessage) error
+}
+
+// permanentError marks a failure retrying can't fix, such as a rejected
+// address. Such deliveries go to the dead letters straight away.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return permanentError{err}
+}
+
+func isPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// DeliveryConfig controls the outbox dispatcher
+type DeliveryConfig struct {
+	Workers      int
+	Timeout      time.Duration // per send
+	PollInterval time.Duration // how often the outbox is checked for due retries
+	MaxAttempts  int           // before an entry is dead-lettered
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+}
+
+func loadDeliveryConfig() DeliveryConfig {
+	return DeliveryConfig{
+		Workers:      envInt("ALERT_DELIVERY_WORKERS", 4),
+		Timeout:      envDuration("ALERT_DELIVERY_TIMEOUT", 15*time.Second),
+		PollInterval: envDuration("ALERT_OUTBOX_POLL", 5*time.Second),
+		MaxAttempts:  envInt("ALERT_OUTBOX_MAX_ATTEMPTS", 8),
+		BaseDelay:    envDuration("ALERT_OUTBOX_BASE_DELAY", 10*time.Second),
+		MaxDelay:     envDuration("ALERT_OUTBOX_MAX_DELAY", 30*time.Minute),
+	}
+}
+
+// Notifier sends triggered alerts on the channels each user chose. The
+// trigger transaction writes one outbox entry per channel alongside the
+// alert's state change, and the dispatcher (see outbox.go) delivers them,
+// so a failed or slow channel neither loses the notification nor holds up
+// alert evaluation.
+type Notifier struct {
+	users       *mongo.Collection
+	outbox      *mongo.Collection
+	deadLetters *mongo.Collection
+	channels    map[string]Channel // configured channels by name
+	cfg         DeliveryConfig
+
+	wake chan struct{}
+}
+
+// NewNotifier enables the channels that are configured: in-app and
+// webhooks always, email with SMTP_HOST and socket with REDIS_URL
+func NewNotifier(db *mongo.Database) (*Notifier, error) {
+	n := &Notifier{
+		users:       db.Collection("users"),
+		outbox:      db.Collection(outboxCollection),
+		deadLetters: db.Collection(deadLetterCollection),
+		channels:    make(map[string]Channel),
+		cfg:         loadDeliveryConfig(),
+		wake:        make(chan struct{}, 1),
+	}
+
+	available := []Channel{NewInAppChannel(db), NewWebhookChannelFromEnv()}
+	if email := NewEmailChannelFromEnv(); email != nil {
+		available = append(available, email)
+	}
+	redis, err := NewRedisChannelFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if redis != nil {
+		available = append(available, redis)
+	}
+
+	var names []string
+	for _, c := range available {
+		n.channels[c.Name()] = c
+		names = append(names, c.Name())
+	}
+	fmt.Printf("Alert delivery channels: %s\n", strings.Join(names, ", "))
+	return n, nil
+}
+
+// Recipient loads a user's delivery preferences. If that fails the default
+// channels are used, so the user still hears about the alert.
+func (n *Notifier) Recipient(ctx context.Context, userID primitive.ObjectID) Recipient {
+	r, err := n.loadRecipient(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load delivery preferences of user %s, using defaults: %v", userID.Hex(), err)
+		return Recipient{ID: userID}
+	}
+	return r
+}
+
+func (n *Notifier) loadRecipient(ctx context.Context, userID primitive.ObjectID) (Recipient, error) {
+	opts := options.FindOne().SetProjection(bson.M{
+		"username": 1, "email": 1, "alertChannels": 1, "alertWebhook": 1,
+	})
+	var r Recipient
+	err := n.users.FindOne(ctx, bson.M{"_id": userID}, opts).Decode(&r)
+	return r, err
+}
+
+// Entries returns the outbox entries for every configured channel the user
+// chose, to be inserted with the alert's state change
+func (n *Notifier) Entries(to Recipient, alert Alert, msg AlertMessage) []interface{} {
+	var entries []interface{}
+	for name := range n.channels {
+		if !to.wants(name) {
+			continue
+		}
+		entries = append(entries, OutboxEntry{
+			ID:            primitive.NewObjectID(),
+			Alert:         alert.ID,
+			User:          to.ID,
+			Channel:       name,
+			Message:       msg,
+			Status:        outboxPending,
+			NextAttemptAt: msg.TriggeredAt,
+			CreatedAt:     msg.TriggeredAt,
+		})
+	}
+	return entries
+}
+
+// Wake tells the dispatcher new entries were committed
+func (n *Notifier) Wake() {
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close releases the channels' connections
+func (n *Notifier) Close() {
+	for _, c := range n.channels {
+		if closer, ok := c.(interface{ Close() error }); ok {
+			closer.Close()
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// questionWindow is the period QUESTION_SPIKE alerts count questions over
+const questionWindow = time.Hour
+
+// SentimentTracker remembers the last sentiment label of every stock, so a
+// label change can be told apart from a repeated update. Change events
+// don't carry the previous value of a field.
+type SentimentTracker struct {
+	stocks *mongo.Collection
+
+	mu     sync.Mutex
+	labels map[string]string
+}
+
+func NewSentimentTracker(db *mongo.Database) *SentimentTracker {
+	return &SentimentTracker{
+		stocks: db.Collection("stocks"),
+		labels: make(map[string]string),
+	}
+}
+
+// Load reads the current label of every stock
+func (t *SentimentTracker) Load(ctx context.Context) error {
+	opts := options.Find().SetProjection(bson.M{"symbol": 1, "sentimentLabel": 1})
+	cursor, err := t.stocks.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return err
+	}
+	var stocks []struct {
+		Symbol         string `bson:"symbol"`
+		SentimentLabel string `bson:"sentimentLabel"`
+	}
+	if err := cursor.All(ctx, &stocks); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range stocks {
+		t.labels[s.Symbol] = s.SentimentLabel
+	}
+	return nil
+}
+
+// Swap records the symbol's current label and returns the previous one,
+// empty if it wasn't known
+func (t *SentimentTracker) Swap(symbol, label string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	previous := t.labels[symbol]
+	t.labels[symbol] = label
+	return previous
+}
+
+// checkQuestionVolume queues QUESTION_SPIKE alerts for evaluation after a
+// new question on the stock. done, if set, is called once they are evaluated.
+func (e *Engine) checkQuestionVolume(stockID primitive.ObjectID, done func()) {
+	ctx := context.Background()
+
+	symbol, err := e.symbolFor(ctx, stockID)
+	if err != nil {
+		log.Printf("Stock lookup failed for question on %s: %v", stockID.Hex(), err)
+		if done != nil {
+			done()
+		}
+		return
+	}
+
+	market := Market{
+		Symbol:  symbol,
+		StockID: stockID,
+		Events:  EventQuestion,
+		At:      time.Now(),
+	}
+	if done != nil {
+		market.done = []func(){done}
+	}
+	e.Dispatch(market)
+}
+
+// symbolFor resolves a stock id to its symbol, caching the answer
+func (e *Engine) symbolFor(ctx context.Context, stockID primitive.ObjectID) (string, error) {
+	e.symbolsMu.Lock()
+	symbol, ok := e.symbols[stockID]
+	e.symbolsMu.Unlock()
+	if ok {
+		return symbol, nil
+	}
+
+	var stock struct {
+		Symbol string `bson:"symbol"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"symbol": 1})
+	if err := e.stocks.FindOne(ctx, bson.M{"_id": stockID}, opts).Decode(&stock); err != nil {
+		return "", err
+	}
+
+	e.symbolsMu.Lock()
+	e.symbols[stockID] = stock.Symbol
+	e.symbolsMu.Unlock()
+	return stock.Symbol, nil
+}
+
+// questionsSince counts the questions asked about a stock since the given time
+func (e *Engine) questionsSince(ctx context.Context, stockID primitive.ObjectID, since time.Time) (int64, error) {
+	return e.questions.CountDocuments(ctx, bson.M{
+		"stockId":   stockID,
+		"createdAt": bson.M{"$gte": since},
+	})
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type pricePoint struct {
+	At    time.Time `bson:"timestamp"`
+	Price float64   `bson:"price"`
+}
+
+// PriceHistory keeps a rolling window of prices for the symbols that have
+// windowed or gap alerts. A window is seeded from the price-updater's
+// pricehistory collection the first time it's needed, so a restart doesn't
+// start it empty. It also serves the average daily volume used by rules.
+// Prices from before a split are restated when it is applied, as the
+// pricehistory ticks aren't.
+type PriceHistory struct {
+	ticks     *mongo.Collection
+	bars      *mongo.Collection
+	keep      time.Duration // longest window kept per symbol
+	gapWindow time.Duration // how long after the open gap alerts can fire
+	startedAt time.Time
+
+	mu      sync.Mutex
+	samples map[string][]pricePoint
+	tracked map[string]bool
+	opens   map[string]pricePoint // first price of the current UTC day
+	volumes map[string]averageVolume
+	splits  map[string]Split // last applied split per symbol
+}
+
+// avgVolumeSessions is how many daily bars the average volume is taken over
+const avgVolumeSessions = 20
+
+type averageVolume struct {
+	day   time.Time
+	value float64
+	ok    bool
+}
+
+// NewPriceHistory reads ALERT_MAX_WINDOW (default 24h) and ALERT_GAP_WINDOW
+// (default 30m)
+func NewPriceHistory(db *mongo.Database) *PriceHistory {
+	return &PriceHistory{
+		ticks:     db.Collection("pricehistory"),
+		bars:      db.Collection("pricebars"),
+		keep:      envDuration("ALERT_MAX_WINDOW", 24*time.Hour),
+		gapWindow: envDuration("ALERT_GAP_WINDOW", 30*time.Minute),
+		startedAt: time.Now(),
+		samples:   make(map[string][]pricePoint),
+		tracked:   make(map[string]bool),
+		opens:     make(map[string]pricePoint),
+		volumes:   make(map[string]averageVolume),
+		splits:    make(map[string]Split),
+	}
+}
+
+// Observe records a price event. Events must be observed in order.
+func (h *PriceHistory) Observe(symbol string, at time.Time, price float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Only trust the first price we see today as the open if we were already
+	// running when the day started
+	day := at.UTC().Truncate(24 * time.Hour)
+	if open, ok := h.opens[symbol]; (!ok || open.At.Before(day)) && h.startedAt.Before(day) {
+		h.opens[symbol] = pricePoint{At: at, Price: price}
+	}
+
+	if !h.tracked[symbol] {
+		return
+	}
+	h.samples[symbol] = prune(append(h.samples[symbol], pricePoint{At: at, Price: price}), at.Add(-h.keep))
+}
+
+// Split records the symbol's last applied split. When it's newer than the
+// one known, the window and open observed before it are restated, and the
+// average volume is reloaded from the adjusted daily bars.
+func (h *PriceHistory) Split(symbol string, split Split) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !split.AppliedAt.After(h.splits[symbol].AppliedAt) {
+		return
+	}
+	h.splits[symbol] = split
+
+	// A new slice, so a window handed out before the split isn't changed
+	samples := make([]pricePoint, len(h.samples[symbol]))
+	for i, p := range h.samples[symbol] {
+		samples[i] = pricePoint{At: p.At, Price: split.adjust(p.At, p.Price)}
+	}
+	h.samples[symbol] = samples
+	if open, ok := h.opens[symbol]; ok {
+		open.Price = split.adjust(open.At, open.Price)
+		h.opens[symbol] = open
+	}
+	delete(h.volumes, symbol)
+}
+
+// Range returns the lowest and highest price since the given time,
+// including the latest observed one
+func (h *PriceHistory) Range(ctx context.Context, symbol string, since time.Time) (low, high float64, ok bool) {
+	h.track(ctx, symbol)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, p := range h.samples[symbol] {
+		if p.At.Before(since) {
+			continue
+		}
+		if !ok || p.Price < low {
+			low = p.Price
+		}
+		if !ok || p.Price > high {
+			high = p.Price
+		}
+		ok = true
+	}
+	return low, high, ok
+}
+
+// SessionOpen returns the first price of the symbol on the UTC day of now
+func (h *PriceHistory) SessionOpen(ctx context.Context, symbol string, now time.Time) (pricePoint, bool) {
+	h.track(ctx, symbol)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	open, ok := h.opens[symbol]
+	if !ok || open.At.Before(now.UTC().Truncate(24*time.Hour)) {
+		return pricePoint{}, false
+	}
+	return open, true
+}
+
+// AverageVolume returns the mean volume of the symbol's last 20 complete
+// daily bars, loaded once per UTC day
+func (h *PriceHistory) AverageVolume(ctx context.Context, symbol string, now time.Time) (float64, bool) {
+	day := now.UTC().Truncate(24 * time.Hour)
+
+	h.mu.Lock()
+	cached, ok := h.volumes[symbol]
+	h.mu.Unlock()
+	if ok && cached.day.Equal(day) {
+		return cached.value, cached.ok
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "start", Value: -1}}).
+		SetLimit(avgVolumeSessions).
+		SetProjection(bson.M{"volume": 1})
+	cursor, err := h.bars.Find(ctx, bson.M{
+		"symbol":   symbol,
+		"interval": "1d",
+		"start":    bson.M{"$lt": day},
+		"volume":   bson.M{"$gt": 0},
+	}, opts)
+	if err != nil {
+		log.Printf("Failed to load daily volume for %s: %v", symbol, err)
+		return 0, false
+	}
+	var bars []struct {
+		Volume float64 `bson:"volume"`
+	}
+	if err := cursor.All(ctx, &bars); err != nil {
+		log.Printf("Failed to load daily volume for %s: %v", symbol, err)
+		return 0, false
+	}
+
+	avg := averageVolume{day: day, ok: len(bars) > 0}
+	for _, b := range bars {
+		avg.value += b.Volume / float64(len(bars))
+	}
+
+	h.mu.Lock()
+	h.volumes[symbol] = avg
+	h.mu.Unlock()
+	return avg.value, avg.ok
+}
+
+// track starts keeping a window for the symbol, seeded from pricehistory
+func (h *PriceHistory) track(ctx context.Context, symbol string) {
+	h.mu.Lock()
+	if h.tracked[symbol] {
+		h.mu.Unlock()
+		return
+	}
+	h.tracked[symbol] = true
+	h.mu.Unlock()
+
+	now := time.Now()
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}})
+	cursor, err := h.ticks.Find(ctx, bson.M{
+		"symbol":    symbol,
+		"timestamp": bson.M{"$gte": now.Add(-h.keep)},
+	}, opts)
+	if err != nil {
+		log.Printf("Failed to load price history for %s: %v", symbol, err)
+		return
+	}
+	var seeded []pricePoint
+	if err := cursor.All(ctx, &seeded); err != nil {
+		log.Printf("Failed to load price history for %s: %v", symbol, err)
+		return
+	}
+	h.seed(symbol, seeded, now)
+}
+
+// seed puts the ticks loaded from pricehistory ahead of the prices observed
+// while they were loading. The ticks are as quoted, so they're restated for
+// the last split known now; observed prices were restated when it arrived.
+func (h *PriceHistory) seed(symbol string, seeded []pricePoint, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, p := range seeded {
+		seeded[i].Price = h.splits[symbol].adjust(p.At, p.Price)
+	}
+
+	// Keep what was observed while loading; it's at least as recent
+	current := h.samples[symbol]
+	if len(current) > 0 {
+		n := 0
+		for n < len(seeded) && seeded[n].At.Before(current[0].At) {
+			n++
+		}
+		seeded = seeded[:n]
+	}
+	h.samples[symbol] = append(seeded, current...)
+
+	day := now.UTC().Truncate(24 * time.Hour)
+	for _, p := range seeded {
+		if !p.At.Before(day) {
+			h.opens[symbol] = p
+			break
+		}
+	}
+}
+
+// prune drops points older than cutoff from a time-ordered slice
+func prune(points []pricePoint, cutoff time.Time) []pricePoint {
+	n := 0
+	for n < len(points) && points[n].At.Before(cutoff) {
+		n++
+	}
+	return points[n:]
+}
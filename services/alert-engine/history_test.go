@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestHistory() *PriceHistory {
+	return &PriceHistory{
+		keep:    time.Hour,
+		samples: make(map[string][]pricePoint),
+		tracked: make(map[string]bool),
+		opens:   make(map[string]pricePoint),
+		volumes: make(map[string]averageVolume),
+		splits:  make(map[string]Split),
+	}
+}
+
+func TestSplitRestatesEarlierPrices(t *testing.T) {
+	before := time.Now().Add(-time.Minute)
+	split := Split{Ratio: 4, AppliedAt: before.Add(30 * time.Second)}
+	after := time.Now()
+
+	h := newTestHistory()
+	h.tracked["AAPL"] = true
+	h.samples["AAPL"] = []pricePoint{{At: before, Price: 400}}
+	h.opens["AAPL"] = pricePoint{At: before, Price: 400}
+	h.volumes["AAPL"] = averageVolume{day: after.UTC().Truncate(24 * time.Hour), value: 1000, ok: true}
+	window := h.samples["AAPL"]
+
+	h.Split("AAPL", split)
+	h.Observe("AAPL", after, 101)
+
+	if low, high, _ := h.Range(context.Background(), "AAPL", before); low != 100 || high != 101 {
+		t.Errorf("window %.0f-%.0f, want 100-101", low, high)
+	}
+	if open := h.opens["AAPL"]; open.Price != 100 {
+		t.Errorf("open %.0f, want 100", open.Price)
+	}
+	if _, ok := h.volumes["AAPL"]; ok {
+		t.Error("average volume still cached; the daily bars were restated")
+	}
+	if window[0].Price != 400 {
+		t.Errorf("window taken before the split changed to %.0f", window[0].Price)
+	}
+
+	// The same split seen again on a later event changes nothing
+	h.Split("AAPL", split)
+	if low, _, _ := h.Range(context.Background(), "AAPL", before); low != 100 {
+		t.Errorf("split restated twice: low %.0f, want 100", low)
+	}
+}
+
+func TestSplitWhileSeeding(t *testing.T) {
+	now := time.Now()
+	split := Split{Ratio: 4, AppliedAt: now.Add(-20 * time.Minute)}
+
+	// Ticks loaded from pricehistory, as quoted either side of the split
+	loaded := []pricePoint{
+		{At: now.Add(-40 * time.Minute), Price: 400},
+		{At: now.Add(-30 * time.Minute), Price: 404},
+		{At: now.Add(-10 * time.Minute), Price: 102},
+	}
+
+	// While they load, one pre-split price is observed, then the split
+	// arrives with the restated price
+	h := newTestHistory()
+	h.tracked["AAPL"] = true
+	h.Observe("AAPL", now.Add(-25*time.Minute), 408)
+	h.Split("AAPL", split)
+	h.Observe("AAPL", now, 103)
+	h.seed("AAPL", loaded, now)
+
+	want := []float64{100, 101, 102, 103}
+	got := h.samples["AAPL"]
+	if len(got) != len(want) {
+		t.Fatalf("window has %d prices, want %d: %v", len(got), len(want), got)
+	}
+	for i, p := range got {
+		if p.Price != want[i] {
+			t.Errorf("price %d is %.2f, want %.0f (restated once)", i, p.Price, want[i])
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AlertIndex holds every active alert in memory, so events are matched
+// without reading the alerts collection. Armed ABOVE and BELOW alerts are
+// kept sorted by target price and found with a binary search; all other
+// alerts, and recurring ones waiting to re-arm, are checked on every event
+// that can affect them. It's kept current by a change stream on alerts.
+type AlertIndex struct {
+	coll *mongo.Collection
+
+	mu       sync.RWMutex
+	symbols  map[string]*symbolAlerts
+	byID     map[primitive.ObjectID]Alert
+	ready    chan struct{}
+	loadOnce sync.Once
+}
+
+type symbolAlerts struct {
+	above []Alert // sorted by TargetPrice
+	below []Alert // sorted by TargetPrice
+	other []Alert
+}
+
+func NewAlertIndex(db *mongo.Database) *AlertIndex {
+	return &AlertIndex{
+		coll:    db.Collection("alerts"),
+		symbols: make(map[string]*symbolAlerts),
+		byID:    make(map[primitive.ObjectID]Alert),
+		ready:   make(chan struct{}),
+	}
+}
+
+// Ready is closed once the index has been loaded for the first time
+func (x *AlertIndex) Ready() <-chan struct{} {
+	return x.ready
+}
+
+// Load replaces the index with the active alerts in the database
+func (x *AlertIndex) Load(ctx context.Context) error {
+	cursor, err := x.coll.Find(ctx, bson.M{"isActive": true})
+	if err != nil {
+		return err
+	}
+	var alerts []Alert
+	if err := cursor.All(ctx, &alerts); err != nil {
+		return err
+	}
+
+	symbols := make(map[string]*symbolAlerts)
+	byID := make(map[primitive.ObjectID]Alert, len(alerts))
+	for _, a := range alerts {
+		byID[a.ID] = a
+		list := bucket(symbols, a)
+		*list = append(*list, a)
+	}
+	// Sort once rather than inserting one by one
+	for _, s := range symbols {
+		sortByTarget(s.above)
+		sortByTarget(s.below)
+	}
+
+	x.mu.Lock()
+	x.symbols = symbols
+	x.byID = byID
+	x.mu.Unlock()
+
+	x.loadOnce.Do(func() { close(x.ready) })
+	return nil
+}
+
+// Match returns the alerts an event may trigger or re-arm
+func (x *AlertIndex) Match(m Market) []Alert {
+	wanted := make(map[string]bool)
+	for _, c := range conditionsFor(m.Events) {
+		wanted[c] = true
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	s := x.symbols[m.Symbol]
+	if s == nil {
+		return nil
+	}
+
+	var matched []Alert
+	if m.Events&EventPrice != 0 {
+		// ABOVE fires at or above its target, BELOW at or below, anywhere in
+		// the range of a coalesced event
+		low, high := m.PriceRange()
+		n := sort.Search(len(s.above), func(i int) bool { return s.above[i].TargetPrice > high })
+		matched = append(matched, s.above[:n]...)
+		n = sort.Search(len(s.below), func(i int) bool { return s.below[i].TargetPrice >= low })
+		matched = append(matched, s.below[n:]...)
+	}
+	for _, a := range s.other {
+		if wanted[a.Condition] {
+			matched = append(matched, a)
+		}
+	}
+	return matched
+}
+
+// Put adds or replaces an alert; inactive alerts are removed
+func (x *AlertIndex) Put(alert Alert) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.remove(alert.ID)
+	if !alert.IsActive {
+		return
+	}
+	x.byID[alert.ID] = alert
+	list := bucket(x.symbols, alert)
+	i := sort.Search(len(*list), func(i int) bool { return (*list)[i].TargetPrice > alert.TargetPrice })
+	*list = append(*list, Alert{})
+	copy((*list)[i+1:], (*list)[i:])
+	(*list)[i] = alert
+}
+
+func (x *AlertIndex) Remove(id primitive.ObjectID) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(id)
+}
+
+func (x *AlertIndex) remove(id primitive.ObjectID) {
+	alert, ok := x.byID[id]
+	if !ok {
+		return
+	}
+	delete(x.byID, id)
+
+	s := x.symbols[alert.Symbol]
+	s.above = without(s.above, id)
+	s.below = without(s.below, id)
+	s.other = without(s.other, id)
+	if len(s.above)+len(s.below)+len(s.other) == 0 {
+		delete(x.symbols, alert.Symbol)
+	}
+}
+
+// bucket returns the list an alert is filed in under its symbol
+func bucket(symbols map[string]*symbolAlerts, alert Alert) *[]Alert {
+	s := symbols[alert.Symbol]
+	if s == nil {
+		s = &symbolAlerts{}
+		symbols[alert.Symbol] = s
+	}
+
+	// Recurring alerts waiting to re-arm are checked on every price event,
+	// since re-arming happens on the other side of the target
+	armed := !alert.Recurring || !alert.AwaitingRearm
+	switch {
+	case alert.Condition == ConditionAbove && armed:
+		return &s.above
+	case alert.Condition == ConditionBelow && armed:
+		return &s.below
+	default:
+		return &s.other
+	}
+}
+
+func sortByTarget(alerts []Alert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].TargetPrice < alerts[j].TargetPrice
+	})
+}
+
+func without(alerts []Alert, id primitive.ObjectID) []Alert {
+	for i, a := range alerts {
+		if a.ID == id {
+			return append(alerts[:i], alerts[i+1:]...)
+		}
+	}
+	return alerts
+}
+
+// alertStream keeps the index in sync with the alerts collection. The
+// index lives in memory, so it's reloaded on every connect instead of
+// resuming from a checkpoint.
+func alertStream(index *AlertIndex) *resumableStream {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "operationType", Value: bson.D{{Key: "$in", Value: bson.A{"insert", "update", "replace", "delete"}}}},
+		}}},
+	}
+
+	return &resumableStream{
+		name:         "alerts",
+		coll:         index.coll,
+		pipeline:     pipeline,
+		fullDocument: options.UpdateLookup,
+		handle: func(stream *mongo.ChangeStream, done func()) {
+			handleAlertChange(stream, index)
+			done()
+		},
+		reload: index.Load,
+	}
+}
+
+func handleAlertChange(stream *mongo.ChangeStream, index *AlertIndex) {
+	var event struct {
+		OperationType string `bson:"operationType"`
+		DocumentKey   struct {
+			ID primitive.ObjectID `bson:"_id"`
+		} `bson:"documentKey"`
+		FullDocument *Alert `bson:"fullDocument"`
+	}
+	if err := stream.Decode(&event); err != nil {
+		log.Printf("Decode error: %v", err)
+		return
+	}
+
+	// The full document is missing when the alert was deleted, or deleted
+	// again before an update could be looked up
+	if event.OperationType == "delete" || event.FullDocument == nil {
+		index.Remove(event.DocumentKey.ID)
+		return
+	}
+	index.Put(*event.FullDocument)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Indicator is a technical indicator computed incrementally, one closed bar
+// at a time
+type Indicator interface {
+	// Update feeds the close of the next bar
+	Update(close float64)
+	// Value is the indicator after the closes fed so far; ok is false until
+	// enough bars have been seen
+	Value() (value float64, ok bool)
+	// Peek is what Value would be if the next bar closed at close, without
+	// changing any state. It's used to evaluate the bar still in progress.
+	Peek(close float64) (value float64, ok bool)
+}
+
+// maxIndicatorPeriod bounds the number of bars an indicator may look back.
+// The API enforces the same limit (MAX_INDICATOR_PERIOD in
+// server/models/Alert.js).
+const maxIndicatorPeriod = 500
+
+var indicatorName = regexp.MustCompile(`^(SMA|EMA|RSI)([0-9]+)$`)
+
+// NewIndicator parses names like "SMA50", "EMA20" or "RSI14"
+func NewIndicator(name string) (Indicator, error) {
+	kind, period, err := parseIndicator(name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case "SMA":
+		return NewSMA(period), nil
+	case "EMA":
+		return NewEMA(period), nil
+	default:
+		return NewRSI(period), nil
+	}
+}
+
+// parseIndicator splits an indicator name into its kind and period
+func parseIndicator(name string) (kind string, period int, err error) {
+	m := indicatorName.FindStringSubmatch(name)
+	if m == nil {
+		return "", 0, fmt.Errorf("unknown indicator %q", name)
+	}
+	period, _ = strconv.Atoi(m[2])
+	minPeriod := 1
+	if m[1] == "RSI" {
+		minPeriod = 2
+	}
+	if period < minPeriod || period > maxIndicatorPeriod {
+		return "", 0, fmt.Errorf("indicator %s: period must be between %d and %d", name, minPeriod, maxIndicatorPeriod)
+	}
+	return m[1], period, nil
+}
+
+// SMA is the simple moving average of the last period closes
+type SMA struct {
+	period int
+	window []float64 // ring buffer of the last period closes
+	next   int
+	count  int
+	sum    float64
+}
+
+func NewSMA(period int) *SMA {
+	return &SMA{period: period, window: make([]float64, period)}
+}
+
+func (s *SMA) Update(close float64) {
+	if s.count == s.period {
+		s.sum -= s.window[s.next]
+	} else {
+		s.count++
+	}
+	s.window[s.next] = close
+	s.sum += close
+	s.next = (s.next + 1) % s.period
+}
+
+func (s *SMA) Value() (float64, bool) {
+	if s.count < s.period {
+		return 0, false
+	}
+	return s.sum / float64(s.period), true
+}
+
+func (s *SMA) Peek(close float64) (float64, bool) {
+	switch {
+	case s.count < s.period-1:
+		return 0, false
+	case s.count < s.period:
+		return (s.sum + close) / float64(s.period), true
+	default:
+		return (s.sum - s.window[s.next] + close) / float64(s.period), true
+	}
+}
+
+// EMA is the exponential moving average with smoothing 2/(period+1),
+// seeded with the SMA of the first period closes
+type EMA struct {
+	period int
+	k      float64
+	count  int
+	sum    float64
+	value  float64
+}
+
+func NewEMA(period int) *EMA {
+	return &EMA{period: period, k: 2 / float64(period+1)}
+}
+
+func (e *EMA) Update(close float64) {
+	e.value, _ = e.Peek(close)
+	if e.count < e.period {
+		e.sum += close
+	}
+	e.count++
+}
+
+func (e *EMA) Value() (float64, bool) {
+	return e.value, e.count >= e.period
+}
+
+func (e *EMA) Peek(close float64) (float64, bool) {
+	switch {
+	case e.count < e.period-1:
+		return 0, false
+	case e.count == e.period-1:
+		return (e.sum + close) / float64(e.period), true
+	default:
+		return close*e.k + e.value*(1-e.k), true
+	}
+}
+
+// RSI is Wilder's relative strength index: average gains and losses are
+// seeded with a simple mean over the first period changes and smoothed
+// with factor 1/period after that
+type RSI struct {
+	period  int
+	prev    float64
+	hasPrev bool
+	changes int
+	avgGain float64
+	avgLoss float64
+}
+
+func NewRSI(period int) *RSI {
+	return &RSI{period: period}
+}
+
+func (r *RSI) Update(close float64) {
+	if r.hasPrev {
+		r.avgGain, r.avgLoss = r.averages(close)
+		r.changes++
+	}
+	r.prev = close
+	r.hasPrev = true
+}
+
+func (r *RSI) Value() (float64, bool) {
+	if r.changes < r.period {
+		return 0, false
+	}
+	return rsi(r.avgGain, r.avgLoss), true
+}
+
+func (r *RSI) Peek(close float64) (float64, bool) {
+	if !r.hasPrev || r.changes < r.period-1 {
+		return 0, false
+	}
+	gain, loss := r.averages(close)
+	return rsi(gain, loss), true
+}
+
+// averages returns the average gain and loss after a change to close.
+// While seeding they are running sums, divided down on the last seed bar.
+func (r *RSI) averages(close float64) (gain, loss float64) {
+	change := close - r.prev
+	var up, down float64
+	if change > 0 {
+		up = change
+	} else {
+		down = -change
+	}
+
+	n := float64(r.period)
+	switch {
+	case r.changes < r.period-1:
+		return r.avgGain + up, r.avgLoss + down
+	case r.changes == r.period-1:
+		return (r.avgGain + up) / n, (r.avgLoss + down) / n
+	default:
+		return (r.avgGain*(n-1) + up) / n, (r.avgLoss*(n-1) + down) / n
+	}
+}
+
+func rsi(avgGain, avgLoss float64) float64 {
+	if avgLoss == 0 {
+		if avgGain == 0 {
+			return 50
+		}
+		return 100
+	}
+	return 100 - 100/(1+avgGain/avgLoss)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// Reference series from the StockCharts ChartSchool worked examples. The
+// published results are rounded to two decimals, and the RSI table also
+// rounds the average gain and loss at every step, so its values are only
+// matched to within 0.1. Each series is also checked exactly against a
+// direct, non-incremental computation from the definitions below.
+
+var emaCloses = []float64{
+	22.27, 22.19, 22.08, 22.17, 22.18, 22.13, 22.23, 22.43, 22.24, 22.29,
+	22.15, 22.39, 22.38, 22.61, 23.36, 24.05, 23.75, 23.83, 23.95, 23.63,
+	23.82, 23.87, 23.65, 23.19, 23.10, 23.33, 22.68, 23.10, 22.40, 22.17,
+}
+
+// Wilder's RSI14 example
+var rsiCloses = []float64{
+	44.34, 44.09, 44.15, 43.61, 44.33, 44.83, 45.10, 45.42, 45.84, 46.08,
+	45.89, 46.03, 45.61, 46.28, 46.28, 46.00, 46.03, 46.41, 46.22, 45.64,
+	46.21, 46.25, 45.71, 46.45, 45.78, 45.35, 44.03, 44.18, 44.22, 44.57,
+	43.42, 42.66, 43.13,
+}
+
+func TestIndicatorsMatchReference(t *testing.T) {
+	tests := []struct {
+		name      string
+		closes    []float64
+		reference func(closes []float64) []float64
+		tolerance float64
+		want      []float64 // published, from the first bar with a value
+	}{
+		{"SMA10", emaCloses, referenceSMA(10), 0.006, []float64{
+			22.22, 22.21, 22.23, 22.26, 22.30, 22.42, 22.61, 22.77, 22.91, 23.08, 23.21,
+			23.38, 23.53, 23.65, 23.71, 23.68, 23.61, 23.51, 23.43, 23.28, 23.13,
+		}},
+		{"EMA10", emaCloses, referenceEMA(10), 0.006, []float64{
+			22.22, 22.21, 22.24, 22.27, 22.33, 22.52, 22.80, 22.97, 23.13, 23.28, 23.34,
+			23.43, 23.51, 23.53, 23.47, 23.40, 23.39, 23.26, 23.23, 23.08, 22.92,
+		}},
+		{"RSI14", rsiCloses, referenceRSI(14), 0.1, []float64{
+			70.53, 66.32, 66.55, 69.41, 66.36, 57.97, 62.93, 63.26, 56.06, 62.38,
+			54.71, 50.42, 39.99, 41.46, 41.87, 45.46, 37.30, 33.08, 37.77,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ind, err := NewIndicator(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			first := len(tt.closes) - len(tt.want)
+			exact := tt.reference(tt.closes)
+
+			for i, c := range tt.closes {
+				// Peek must agree with the value after the bar closes
+				peeked, peekOK := ind.Peek(c)
+				ind.Update(c)
+				got, ok := ind.Value()
+
+				if i < first {
+					if ok || peekOK {
+						t.Fatalf("bar %d: value %.2f before enough bars were seen", i, got)
+					}
+					continue
+				}
+				if !ok || !peekOK {
+					t.Fatalf("bar %d: no value", i)
+				}
+				if want := tt.want[i-first]; math.Abs(got-want) > tt.tolerance {
+					t.Errorf("bar %d: got %.4f, published %.2f", i, got, want)
+				}
+				if want := exact[i-first]; math.Abs(got-want) > 1e-9 {
+					t.Errorf("bar %d: got %.6f, computed directly %.6f", i, got, want)
+				}
+				if math.Abs(peeked-got) > 1e-9 {
+					t.Errorf("bar %d: Peek %.6f, Value after Update %.6f", i, peeked, got)
+				}
+			}
+		})
+	}
+}
+
+// referenceSMA averages each window of period closes
+func referenceSMA(period int) func([]float64) []float64 {
+	return func(closes []float64) []float64 {
+		var out []float64
+		for end := period; end <= len(closes); end++ {
+			sum := 0.0
+			for _, c := range closes[end-period : end] {
+				sum += c
+			}
+			out = append(out, sum/float64(period))
+		}
+		return out
+	}
+}
+
+// referenceEMA seeds with the SMA of the first period closes, then applies
+// EMA = close*k + EMA*(1-k) with k = 2/(period+1)
+func referenceEMA(period int) func([]float64) []float64 {
+	return func(closes []float64) []float64 {
+		k := 2 / float64(period+1)
+		ema := referenceSMA(period)(closes[:period])[0]
+		out := []float64{ema}
+		for _, c := range closes[period:] {
+			ema = c*k + ema*(1-k)
+			out = append(out, ema)
+		}
+		return out
+	}
+}
+
+// referenceRSI follows Wilder: the first averages are the means of the
+// first period gains and losses, later ones (avg*(period-1) + x) / period
+func referenceRSI(period int) func([]float64) []float64 {
+	return func(closes []float64) []float64 {
+		gains := make([]float64, len(closes)-1)
+		losses := make([]float64, len(closes)-1)
+		for i := 1; i < len(closes); i++ {
+			if d := closes[i] - closes[i-1]; d > 0 {
+				gains[i-1] = d
+			} else {
+				losses[i-1] = -d
+			}
+		}
+
+		n := float64(period)
+		var avgGain, avgLoss float64
+		for i := 0; i < period; i++ {
+			avgGain += gains[i] / n
+			avgLoss += losses[i] / n
+		}
+		out := []float64{100 - 100/(1+avgGain/avgLoss)}
+		for i := period; i < len(gains); i++ {
+			avgGain = (avgGain*(n-1) + gains[i]) / n
+			avgLoss = (avgLoss*(n-1) + losses[i]) / n
+			out = append(out, 100-100/(1+avgGain/avgLoss))
+		}
+		return out
+	}
+}
+
+func TestNewIndicatorPeriods(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"SMA1", true},
+		{"EMA20", true},
+		{"RSI14", true},
+		{"SMA500", true},
+		{"SMA501", false},
+		{"SMA999", false},
+		{"EMA0", false},
+		{"RSI1", false},
+		{"MACD12", false},
+		{"SMA", false},
+	}
+	for _, tt := range tests {
+		_, err := NewIndicator(tt.name)
+		if (err == nil) != tt.valid {
+			t.Errorf("NewIndicator(%q) error = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestIndicatorBookValidate(t *testing.T) {
+	book := &IndicatorBook{keep: 200}
+	for name, valid := range map[string]bool{
+		"PRICE":  true,
+		"SMA200": true,
+		"SMA201": false, // more bars than are kept
+		"RSI1":   false,
+	} {
+		if err := book.Validate(name); (err == nil) != valid {
+			t.Errorf("Validate(%q) = %v, want valid %v", name, err, valid)
+		}
+	}
+}
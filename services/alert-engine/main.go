@@ -5,6 +5,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -17,16 +21,35 @@ import (
 // Domain Models
 // Alert represents a user's price alert settings
 type Alert struct {
-	ID          primitive.ObjectID `bson:"_id"`
-	User        primitive.ObjectID `bson:"user"`
-	Symbol      string             `bson:"symbol"`
-	TargetPrice float64            `bson:"targetPrice"`
-	Condition   string             `bson:"condition"` // "ABOVE" or "BELOW"
-	IsActive    bool               `bson:"isActive"`
+	ID            primitive.ObjectID `bson:"_id"`
+	User          primitive.ObjectID `bson:"user"`
+	Symbol        string             `bson:"symbol"`
+	TargetPrice   float64            `bson:"targetPrice"`
+	Condition     string             `bson:"condition"`     // see conditions.go
+	Percent       float64            `bson:"percent"`       // threshold of percentage conditions
+	WindowMinutes float64            `bson:"windowMinutes"` // lookback of MOVE_UP / MOVE_DOWN
+	Indicator     string             `bson:"indicator"`     // e.g. "SMA50" or "RSI14"
+	CompareTo     string             `bson:"compareTo"`     // other side of a crossover, e.g. "SMA200" or "PRICE"
+	Level         float64            `bson:"level"`         // threshold of INDICATOR_ABOVE / INDICATOR_BELOW
+	Expression    string             `bson:"expression"`    // rule of EXPRESSION alerts, see rules.go
+	Label         string             `bson:"label"`         // SENTIMENT_CHANGE only to this label; empty for any change
+	PerHour       float64            `bson:"perHour"`       // threshold of QUESTION_SPIKE
+	IsActive      bool               `bson:"isActive"`
+
+	// Recurring alerts stay active after firing. They fire again once the
+	// price has moved back past the target by RearmPercent and at least
+	// CooldownMinutes have passed since the last trigger.
+	Recurring       bool       `bson:"recurring"`
+	CooldownMinutes float64    `bson:"cooldownMinutes"`
+	RearmPercent    float64    `bson:"rearmPercent"`
+	AwaitingRearm   bool       `bson:"awaitingRearm"`
+	TriggerCount    int        `bson:"triggerCount"`
+	LastTriggeredAt *time.Time `bson:"lastTriggeredAt,omitempty"`
 }
 
 // Notification represents a message sent to the user
 type Notification struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
 	Recipient primitive.ObjectID `bson:"recipient"`
 	Type      string             `bson:"type"`
 	Content   string             `bson:"content"`
@@ -39,19 +62,90 @@ type Notification struct {
 func main() {
 	loadEnvironment()
 
+	// Stop cleanly on Ctrl+C / SIGTERM so stream positions are checkpointed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The delivery stubs need no database
+	if len(os.Args) > 1 && os.Args[1] == "stubs" {
+		if err := runStubs(ctx, os.Args[2:]); err != nil {
+			log.Fatal("Stubs failed:", err)
+		}
+		return
+	}
+
 	// 1. Initialize Database Connection
 	client := connectToDatabase()
 	defer disconnectDatabase(client)
 
 	db := client.Database("stockforumx")
 	stocksColl := db.Collection("stocks")
-	alertsColl := db.Collection("alerts")
-	notifsColl := db.Collection("notifications")
 
-	fmt.Println("Alert Engine started. Watching for price changes...")
+	if len(os.Args) > 1 && os.Args[1] == "deadletters" {
+		if err := runDeadLetters(ctx, db, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	notifier, err := NewNotifier(db)
+	if err != nil {
+		log.Fatal("Notifier setup failed:", err)
+	}
+	if err := notifier.EnsureIndexes(ctx); err != nil {
+		log.Printf("Warning: notification outbox index setup failed: %v", err)
+	}
+	engine := NewEngine(db, notifier)
+
+	if err := engine.sentiment.Load(ctx); err != nil {
+		log.Printf("Failed to load sentiment labels, first changes may be missed: %v", err)
+	}
+
+	fmt.Println("Alert Engine started. Watching for price, sentiment and discussion changes...")
+
+	// 2. Start Watching Real-Time Streams, resuming where the last run stopped
+	checkpoints := NewCheckpoints(db)
+	cfg := loadStreamConfig()
+
+	var wg sync.WaitGroup
+	var streams []*resumableStream
+	run := func(s *resumableStream) {
+		streams = append(streams, s)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Run(ctx, checkpoints, cfg)
+		}()
+	}
+
+	// Events are matched against the in-memory alert index, so load it first
+	run(alertStream(engine.index))
+	select {
+	case <-engine.index.Ready():
+	case <-ctx.Done():
+	}
 
-	// 2. Start Watching Real-Time Stream
-	watchPriceUpdates(stocksColl, alertsColl, notifsColl)
+	go engine.pool.ReportStats(ctx)
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		notifier.Run(ctx)
+	}()
+
+	run(stockStream(stocksColl, engine))
+	run(questionStream(db.Collection("questions"), engine))
+	wg.Wait()
+
+	// Finish the events already queued before disconnecting. Undelivered
+	// notifications stay in the outbox for the next start.
+	engine.pool.Close()
+	for _, s := range streams {
+		s.Flush(checkpoints)
+	}
+	<-dispatched
+	notifier.Close()
+
+	fmt.Println("Alert Engine stopped.")
 }
 
 // Database Helpers
@@ -83,127 +177,122 @@ func disconnectDatabase(client *mongo.Client) {
 	}
 }
 
+func envInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid %s %q, using %d", key, v, fallback)
+		return fallback
+	}
+	return n
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("Invalid %s %q, using %v", key, v, fallback)
+		return fallback
+	}
+	return d
+}
+
 // Core Logic: Watcher & Processor
 
-func watchPriceUpdates(stocksColl, alertsColl, notifsColl *mongo.Collection) {
-	// Define conditions to watch: Only listen for 'update' events where 'currentPrice' changes
+// stockStream listens for 'update' events where the price or sentiment changes
+func stockStream(stocksColl *mongo.Collection, engine *Engine) *resumableStream {
 	pipeline := mongo.Pipeline{
 		{{Key: "$match", Value: bson.D{
 			{Key: "operationType", Value: "update"},
-			{Key: "updateDescription.updatedFields.currentPrice", Value: bson.D{{Key: "$exists", Value: true}}},
+			{Key: "$or", Value: bson.A{
+				bson.D{{Key: "updateDescription.updatedFields.currentPrice", Value: bson.D{{Key: "$exists", Value: true}}}},
+				bson.D{{Key: "updateDescription.updatedFields.sentimentLabel", Value: bson.D{{Key: "$exists", Value: true}}}},
+				bson.D{{Key: "updateDescription.updatedFields.sentimentScore", Value: bson.D{{Key: "$exists", Value: true}}}},
+			}},
 		}}},
 	}
-	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
 
-	stream, err := stocksColl.Watch(context.Background(), pipeline, opts)
-	if err != nil {
-		log.Fatal("Watch failed:", err)
-	}
-	defer stream.Close(context.Background())
-
-	// Process event stream
-	for stream.Next(context.Background()) {
-		var event struct {
-			FullDocument bson.M `bson:"fullDocument"`
-		}
-		if err := stream.Decode(&event); err != nil {
-			log.Printf("Decode error: %v", err)
-			continue
-		}
-
-		symbol, ok := event.FullDocument["symbol"].(string)
-		if !ok {
-			continue
-		}
-		
-		priceVal, ok := event.FullDocument["currentPrice"].(float64)
-		if !ok {
-			// Handle case where price might be int or other type in raw BSON
-			continue
-		}
-
-		fmt.Printf("Price Update: %s @ $%.2f\n", symbol, priceVal)
-
-		// Check if this price change triggers any alerts associated with the stock
-		// Run in goroutine to not block the stream watcher
-		go checkAndProcessAlerts(alertsColl, notifsColl, symbol, priceVal)
+	return &resumableStream{
+		name:         "stocks",
+		coll:         stocksColl,
+		pipeline:     pipeline,
+		fullDocument: options.UpdateLookup,
+		handle: func(stream *mongo.ChangeStream, done func()) {
+			handleStockUpdate(stream, engine, done)
+		},
+		rescan: engine.rescanStocks,
 	}
 }
 
-func checkAndProcessAlerts(alertsColl, notifsColl *mongo.Collection, symbol string, currentPrice float64) {
-	// Find all ACTIVE alerts for this specific stock symbol
-	filter := bson.M{
-		"symbol":   symbol,
-		"isActive": true,
+func handleStockUpdate(stream *mongo.ChangeStream, engine *Engine, done func()) {
+	var event struct {
+		FullDocument      bson.M `bson:"fullDocument"`
+		UpdateDescription struct {
+			UpdatedFields bson.M `bson:"updatedFields"`
+		} `bson:"updateDescription"`
+	}
+	if err := stream.Decode(&event); err != nil {
+		log.Printf("Decode error: %v", err)
+		done()
+		return
 	}
 
-	cursor, err := alertsColl.Find(context.Background(), filter)
-	if err != nil {
-		log.Printf("Alert lookup failed for %s: %v", symbol, err)
+	market, ok := marketFromDocument(event.FullDocument, time.Now())
+	if !ok {
+		done()
 		return
 	}
-	defer cursor.Close(context.Background())
+	market.done = []func(){done}
 
-	// Iterate through matching alerts
-	for cursor.Next(context.Background()) {
-		var alert Alert
-		if err := cursor.Decode(&alert); err != nil {
-			log.Printf("Alert decode error: %v", err)
-			continue
+	updated := event.UpdateDescription.UpdatedFields
+	if _, ok := updated["currentPrice"]; ok {
+		market.Events |= EventPrice
+		fmt.Printf("Price Update: %s @ $%.2f\n", market.Symbol, market.Price)
+	}
+	for _, field := range []string{"sentimentLabel", "sentimentScore"} {
+		if _, ok := updated[field]; ok {
+			market.Events |= EventSentiment
 		}
+	}
 
-		// Determine if the alert condition is met
-		shouldTrigger := false
-		switch alert.Condition {
-		case "ABOVE":
-			shouldTrigger = currentPrice >= alert.TargetPrice
-		case "BELOW":
-			shouldTrigger = currentPrice <= alert.TargetPrice
-		}
+	// Record the event in order, before it is queued for the workers
+	engine.Dispatch(engine.Observe(market))
+}
 
-		if shouldTrigger {
-			executeAlert(alertsColl, notifsColl, alert, currentPrice)
-		}
+// questionStream listens for new questions, for QUESTION_SPIKE alerts
+func questionStream(questionsColl *mongo.Collection, engine *Engine) *resumableStream {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "operationType", Value: "insert"}}}},
 	}
-}
 
-// Alert Execution
-
-func executeAlert(alertsColl, notifsColl *mongo.Collection, alert Alert, currentPrice float64) {
-	fmt.Printf("Alert Triggered! %s: Target $%.2f, Current $%.2f (User: %s)\n",
-		alert.Symbol, alert.TargetPrice, currentPrice, alert.User.Hex())
-
-	// Step 1: Mark alert as inactive immediately (prevent duplicate triggers)
-	_, err := alertsColl.UpdateOne(
-		context.Background(),
-		bson.M{"_id": alert.ID},
-		bson.M{
-			"$set": bson.M{
-				"isActive":    false,
-				"triggeredAt": time.Now(),
-			},
+	return &resumableStream{
+		name:     "questions",
+		coll:     questionsColl,
+		pipeline: pipeline,
+		handle: func(stream *mongo.ChangeStream, done func()) {
+			handleNewQuestion(stream, engine, done)
 		},
-	)
-	if err != nil {
-		log.Printf("Failed to deactivate alert %s: %v", alert.ID.Hex(), err)
-		return
+		rescan: engine.rescanQuestions,
 	}
+}
 
-	// Step 2: Send Notification to User
-	message := fmt.Sprintf("Price Alert: %s has hit $%.2f (Target: $%.2f)",
-		alert.Symbol, currentPrice, alert.TargetPrice)
-
-	now := time.Now()
-	notification := Notification{
-		Recipient: alert.User,
-		Type:      "PRICE_ALERT",
-		Content:   message,
-		IsRead:    false,
-		CreatedAt: now,
-		UpdatedAt: now,
+func handleNewQuestion(stream *mongo.ChangeStream, engine *Engine, done func()) {
+	var event struct {
+		FullDocument struct {
+			StockID primitive.ObjectID `bson:"stockId"`
+		} `bson:"fullDocument"`
 	}
-
-	if _, err := notifsColl.InsertOne(context.Background(), notification); err != nil {
-		log.Printf("Failed to create notification for user %s: %v", alert.User.Hex(), err)
+	if err := stream.Decode(&event); err != nil {
+		log.Printf("Decode error: %v", err)
+		done()
+		return
 	}
+
+	engine.checkQuestionVolume(event.FullDocument.StockID, done)
 }
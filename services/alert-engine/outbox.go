@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	outboxCollection     = "alertoutbox"
+	deadLetterCollection = "alertdeadletters"
+
+	outboxPending = "PENDING"
+	outboxSending = "SENDING"
+	outboxFailed  = "FAILED" // dead letters only
+)
+
+// OutboxEntry is the delivery of one triggered alert on one channel. It is
+// written in the transaction that marks the alert triggered and removed
+// once delivered; entries that keep failing are moved to the dead letters.
+type OutboxEntry struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Alert         primitive.ObjectID `bson:"alert"`
+	User          primitive.ObjectID `bson:"user"`
+	Channel       string             `bson:"channel"`
+	Message       AlertMessage       `bson:"message"`
+	Status        string             `bson:"status"`
+	Attempts      int                `bson:"attempts"`
+	NextAttemptAt time.Time          `bson:"nextAttemptAt"` // while SENDING, when the claim expires
+	LastError     string             `bson:"lastError,omitempty"`
+	CreatedAt     time.Time          `bson:"createdAt"`
+	FailedAt      *time.Time         `bson:"failedAt,omitempty"`
+}
+
+func (e OutboxEntry) String() string {
+	failed := "-"
+	if e.FailedAt != nil {
+		failed = e.FailedAt.Local().Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprintf("%s  %s  %-7s user=%s %s %s attempts=%d error=%q",
+		e.ID.Hex(), failed, e.Channel, e.User.Hex(), e.Message.Symbol, e.Message.Condition, e.Attempts, e.LastError)
+}
+
+// EnsureIndexes creates the index the dispatcher claims entries by
+func (n *Notifier) EnsureIndexes(ctx context.Context) error {
+	_, err := n.outbox.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "nextAttemptAt", Value: 1}},
+	})
+	return err
+}
+
+// Run delivers due outbox entries on cfg.Workers workers until ctx is
+// cancelled. Entries are claimed for a lease longer than the send timeout,
+// so several engines can share the outbox and an entry claimed by an engine
+// that died is picked up again once its claim expires.
+func (n *Notifier) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < n.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.dispatch(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (n *Notifier) dispatch(ctx context.Context) {
+	poll := time.NewTicker(n.cfg.PollInterval)
+	defer poll.Stop()
+
+	for ctx.Err() == nil {
+		entry, err := n.claim(ctx)
+		switch {
+		case err == nil:
+			n.deliver(entry)
+			continue
+		case errors.Is(err, mongo.ErrNoDocuments):
+		case ctx.Err() == nil:
+			log.Printf("Failed to read notification outbox: %v", err)
+		}
+
+		select {
+		case <-n.wake:
+		case <-poll.C:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// claim takes the oldest due entry
+func (n *Notifier) claim(ctx context.Context) (OutboxEntry, error) {
+	now := time.Now()
+	lease := 2*n.cfg.Timeout + 30*time.Second
+
+	var entry OutboxEntry
+	err := n.outbox.FindOneAndUpdate(ctx,
+		bson.M{"nextAttemptAt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"status": outboxSending, "nextAttemptAt": now.Add(lease)}},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&entry)
+	return entry, err
+}
+
+func (n *Notifier) deliver(entry OutboxEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.Timeout)
+	err := n.send(ctx, entry)
+	cancel()
+
+	// Record the outcome even if we are shutting down
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err == nil {
+		if _, err := n.outbox.DeleteOne(ctx, bson.M{"_id": entry.ID}); err != nil {
+			log.Printf("Failed to clear delivered outbox entry %s: %v", entry.ID.Hex(), err)
+		}
+		return
+	}
+
+	entry.Attempts++
+	entry.LastError = err.Error()
+	if isPermanent(err) || entry.Attempts >= n.cfg.MaxAttempts {
+		n.deadLetter(ctx, entry)
+		return
+	}
+
+	delay := backoff(n.cfg.BaseDelay, n.cfg.MaxDelay, entry.Attempts)
+	log.Printf("Delivery of alert %s via %s failed (attempt %d/%d), retrying in %v: %v",
+		entry.Message.AlertID, entry.Channel, entry.Attempts, n.cfg.MaxAttempts, delay.Round(time.Second), err)
+
+	_, err = n.outbox.UpdateOne(ctx, bson.M{"_id": entry.ID}, bson.M{"$set": bson.M{
+		"status":        outboxPending,
+		"attempts":      entry.Attempts,
+		"lastError":     entry.LastError,
+		"nextAttemptAt": time.Now().Add(delay),
+	}})
+	if err != nil {
+		log.Printf("Failed to reschedule outbox entry %s: %v", entry.ID.Hex(), err)
+	}
+}
+
+func (n *Notifier) send(ctx context.Context, entry OutboxEntry) error {
+	channel, ok := n.channels[entry.Channel]
+	if !ok {
+		return permanent(fmt.Errorf("channel %s is not configured", entry.Channel))
+	}
+
+	to, err := n.loadRecipient(ctx, entry.User)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return permanent(errors.New("user no longer exists"))
+	}
+	if err != nil {
+		return err
+	}
+
+	msg := entry.Message
+	msg.DeliveryID = entry.ID.Hex()
+	return channel.Send(ctx, to, msg)
+}
+
+// deadLetter moves an entry that won't be delivered out of the outbox. The
+// dead letter keeps the entry's id, so a crash between the two steps
+// can't create a second copy.
+func (n *Notifier) deadLetter(ctx context.Context, entry OutboxEntry) {
+	log.Printf("Giving up on alert %s via %s to user %s after %d attempts: %s",
+		entry.Message.AlertID, entry.Channel, entry.User.Hex(), entry.Attempts, entry.LastError)
+
+	now := time.Now()
+	entry.Status = outboxFailed
+	entry.FailedAt = &now
+	if _, err := n.deadLetters.InsertOne(ctx, entry); err != nil && !mongo.IsDuplicateKeyError(err) {
+		log.Printf("Failed to dead-letter outbox entry %s: %v", entry.ID.Hex(), err)
+		return
+	}
+	if _, err := n.outbox.DeleteOne(ctx, bson.M{"_id": entry.ID}); err != nil {
+		log.Printf("Failed to remove dead-lettered outbox entry %s: %v", entry.ID.Hex(), err)
+	}
+}
+
+// runDeadLetters inspects and replays failed deliveries:
+//
+//	deadletters [list]          most recent first
+//	deadletters replay <id>     move one back to the outbox
+//	deadletters replay all
+//
+// Replayed entries start over with a full set of attempts.
+func runDeadLetters(ctx context.Context, db *mongo.Database, args []string) error {
+	outbox := db.Collection(outboxCollection)
+	deadLetters := db.Collection(deadLetterCollection)
+
+	if len(args) == 0 || args[0] == "list" {
+		pending, err := outbox.CountDocuments(ctx, bson.M{})
+		if err != nil {
+			return err
+		}
+		cursor, err := deadLetters.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"failedAt": -1}))
+		if err != nil {
+			return err
+		}
+		var entries []OutboxEntry
+		if err := cursor.All(ctx, &entries); err != nil {
+			return err
+		}
+
+		fmt.Printf("%d failed deliveries (%d still in the outbox)\n", len(entries), pending)
+		for _, e := range entries {
+			fmt.Println(e)
+		}
+		return nil
+	}
+
+	if len(args) != 2 || args[0] != "replay" {
+		return fmt.Errorf("usage: deadletters [list | replay <id> | replay all]")
+	}
+
+	filter := bson.M{}
+	if args[1] != "all" {
+		id, err := primitive.ObjectIDFromHex(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid id %q", args[1])
+		}
+		filter["_id"] = id
+	}
+
+	cursor, err := deadLetters.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var entries []OutboxEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return err
+	}
+	if len(entries) == 0 && args[1] != "all" {
+		return fmt.Errorf("dead letter %s: %w", args[1], mongo.ErrNoDocuments)
+	}
+
+	now := time.Now()
+	for _, e := range entries {
+		e.Status = outboxPending
+		e.Attempts = 0
+		e.NextAttemptAt = now
+		e.FailedAt = nil
+		if _, err := outbox.InsertOne(ctx, e); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		if _, err := deadLetters.DeleteOne(ctx, bson.M{"_id": e.ID}); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Replayed %d deliveries\n", len(entries))
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+// PoolConfig sizes the worker pool that evaluates alerts
+type PoolConfig struct {
+	Workers       int           // one queue per worker, symbols are hashed onto them
+	QueueSize     int           // symbols a worker's queue holds before Submit blocks
+	StatsInterval time.Duration // how often a summary is logged
+}
+
+func loadPoolConfig() PoolConfig {
+	return PoolConfig{
+		Workers:       envInt("ALERT_WORKERS", 8),
+		QueueSize:     envInt("ALERT_QUEUE_SIZE", 256),
+		StatsInterval: envDuration("ALERT_STATS_INTERVAL", time.Minute),
+	}
+}
+
+// PoolStats accumulates between summary log lines
+type PoolStats struct {
+	Events    int
+	Coalesced int
+	Blocked   int // submits that waited for room in a full queue
+	Processed int
+	Depth     int // events queued when the summary was taken
+	PeakDepth int
+	LagTotal  time.Duration
+	LagMax    time.Duration
+}
+
+func (s PoolStats) String() string {
+	var avg time.Duration
+	if s.Processed > 0 {
+		avg = s.LagTotal / time.Duration(s.Processed)
+	}
+	return fmt.Sprintf("events=%d coalesced=%d blocked=%d processed=%d depth=%d peak=%d lag_avg=%v lag_max=%v",
+		s.Events, s.Coalesced, s.Blocked, s.Processed, s.Depth, s.PeakDepth,
+		avg.Round(time.Millisecond), s.LagMax.Round(time.Millisecond))
+}
+
+// WorkerPool evaluates events on a fixed number of workers. Each symbol is
+// always handled by the same worker, so its events are processed in order.
+// A queue holds at most one event per symbol: a newer event for a symbol
+// that is still waiting replaces the stale one, and once a queue is full
+// Submit blocks, pushing back on the change stream.
+type WorkerPool struct {
+	cfg     PoolConfig
+	process func(Market)
+	shards  []*shard
+	wg      sync.WaitGroup
+
+	mu    sync.Mutex
+	depth int
+	stats PoolStats
+}
+
+type shard struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	queue    []string // symbols in arrival order
+	pending  map[string]queuedEvent
+	closed   bool
+}
+
+type queuedEvent struct {
+	market Market
+	since  time.Time // when the oldest event folded into it was queued
+}
+
+func NewWorkerPool(cfg PoolConfig, process func(Market)) *WorkerPool {
+	p := &WorkerPool{cfg: cfg, process: process}
+	for i := 0; i < cfg.Workers; i++ {
+		sh := &shard{pending: make(map[string]queuedEvent)}
+		sh.notEmpty = sync.NewCond(&sh.mu)
+		sh.notFull = sync.NewCond(&sh.mu)
+		p.shards = append(p.shards, sh)
+
+		p.wg.Add(1)
+		go p.work(sh)
+	}
+	return p
+}
+
+// Submit queues an event for its symbol's worker
+func (p *WorkerPool) Submit(m Market) {
+	h := fnv.New32a()
+	h.Write([]byte(m.Symbol))
+	sh := p.shards[h.Sum32()%uint32(len(p.shards))]
+
+	sh.mu.Lock()
+	if queued, ok := sh.pending[m.Symbol]; ok {
+		queued.market = coalesce(queued.market, m)
+		sh.pending[m.Symbol] = queued
+		sh.mu.Unlock()
+		p.count(func(s *PoolStats) { s.Events++; s.Coalesced++ })
+		return
+	}
+
+	blocked := false
+	for len(sh.queue) >= p.cfg.QueueSize && !sh.closed {
+		blocked = true
+		sh.notFull.Wait()
+	}
+	if sh.closed {
+		sh.mu.Unlock()
+		return
+	}
+	sh.queue = append(sh.queue, m.Symbol)
+	sh.pending[m.Symbol] = queuedEvent{market: m, since: time.Now()}
+	sh.notEmpty.Signal()
+	sh.mu.Unlock()
+
+	p.count(func(s *PoolStats) {
+		s.Events++
+		if blocked {
+			s.Blocked++
+		}
+		p.depth++
+		if p.depth > s.PeakDepth {
+			s.PeakDepth = p.depth
+		}
+	})
+}
+
+func (p *WorkerPool) work(sh *shard) {
+	defer p.wg.Done()
+
+	for {
+		sh.mu.Lock()
+		for len(sh.queue) == 0 && !sh.closed {
+			sh.notEmpty.Wait()
+		}
+		if len(sh.queue) == 0 {
+			sh.mu.Unlock()
+			return
+		}
+		symbol := sh.queue[0]
+		sh.queue = sh.queue[1:]
+		queued := sh.pending[symbol]
+		delete(sh.pending, symbol)
+		sh.notFull.Signal()
+		sh.mu.Unlock()
+
+		lag := time.Since(queued.since)
+		p.count(func(s *PoolStats) {
+			p.depth--
+			s.Processed++
+			s.LagTotal += lag
+			if lag > s.LagMax {
+				s.LagMax = lag
+			}
+		})
+
+		p.process(queued.market)
+		for _, done := range queued.market.done {
+			done()
+		}
+	}
+}
+
+func (p *WorkerPool) count(update func(s *PoolStats)) {
+	p.mu.Lock()
+	update(&p.stats)
+	p.mu.Unlock()
+}
+
+// ReportStats logs a summary every StatsInterval until ctx is cancelled
+func (p *WorkerPool) ReportStats(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.StatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			stats := p.stats
+			stats.Depth = p.depth
+			p.stats = PoolStats{PeakDepth: p.depth}
+			p.mu.Unlock()
+			fmt.Printf("Alert queue: %s\n", stats)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close processes the events still queued and stops the workers
+func (p *WorkerPool) Close() {
+	for _, sh := range p.shards {
+		sh.mu.Lock()
+		sh.closed = true
+		sh.notEmpty.Broadcast()
+		sh.notFull.Broadcast()
+		sh.mu.Unlock()
+	}
+	p.wg.Wait()
+}
+
+// coalesce folds a newer event for a symbol into one still waiting. A stock
+// event carries the whole stock, so the newer one wins, but it keeps the
+// kinds of both events so no condition is skipped, and the sentiment label
+// from before the older one, so a label change isn't lost. Prices in between
+// are kept as the range since the last evaluation, so a threshold crossed
+// and crossed back before the worker got to the symbol still fires. The
+// merged event completes both for the stream checkpoints.
+func coalesce(older, newer Market) Market {
+	const stockEvents = EventPrice | EventSentiment
+
+	if newer.Events&stockEvents == 0 {
+		// A question event carries no stock state
+		older.done = append(older.done, newer.done...)
+		older.Events |= newer.Events
+		if newer.At.After(older.At) {
+			older.At = newer.At
+		}
+		return older
+	}
+
+	if older.Events&stockEvents != 0 {
+		newer.PreviousSentiment = older.PreviousSentiment
+	}
+	// Prices from before a split would read as a crash (or a surge)
+	if older.Events&EventPrice != 0 && older.Split.AppliedAt.Equal(newer.Split.AppliedAt) {
+		olderLow, olderHigh := older.PriceRange()
+		newer.Low, newer.High = newer.PriceRange()
+		newer.Low = math.Min(newer.Low, olderLow)
+		newer.High = math.Max(newer.High, olderHigh)
+	}
+	newer.Events |= older.Events
+	newer.done = append(older.done, newer.done...)
+	return newer
+}
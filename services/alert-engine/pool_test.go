@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCoalescedPricesKeepTheirRange(t *testing.T) {
+	at := time.Now()
+	market := Market{Symbol: "AAPL", Events: EventPrice, Price: 99, At: at}
+	for _, price := range []float64{101, 99} {
+		market = coalesce(market, Market{Symbol: "AAPL", Events: EventPrice, Price: price, At: at})
+	}
+	if low, high := market.PriceRange(); low != 99 || high != 101 {
+		t.Fatalf("range %.0f-%.0f, want 99-101", low, high)
+	}
+
+	tests := []struct {
+		alert   Alert
+		matched bool
+		met     bool
+		rearmed bool
+	}{
+		// The price is back at 99, but it went past 100 in between
+		{Alert{Condition: ConditionAbove, TargetPrice: 100}, true, true, true},
+		{Alert{Condition: ConditionBelow, TargetPrice: 100}, true, true, true},
+		{Alert{Condition: ConditionAbove, TargetPrice: 102}, false, false, true},
+		{Alert{Condition: ConditionBelow, TargetPrice: 98}, false, false, true},
+		// 99 isn't 2% below 100, so the alert stays awaiting re-arm
+		{Alert{Condition: ConditionAbove, TargetPrice: 100, RearmPercent: 2, Recurring: true, AwaitingRearm: true}, true, true, false},
+	}
+
+	index := &AlertIndex{symbols: make(map[string]*symbolAlerts), byID: make(map[primitive.ObjectID]Alert)}
+	for i := range tests {
+		tests[i].alert.ID = primitive.NewObjectID()
+		tests[i].alert.Symbol = "AAPL"
+		tests[i].alert.IsActive = true
+		index.Put(tests[i].alert)
+	}
+	matched := make(map[primitive.ObjectID]Alert)
+	for _, a := range index.Match(market) {
+		matched[a.ID] = a
+	}
+
+	e := &Engine{}
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s %.0f (re-arm %g%%)", tt.alert.Condition, tt.alert.TargetPrice, tt.alert.RearmPercent)
+		alert, ok := matched[tt.alert.ID]
+		if ok != tt.matched {
+			t.Errorf("%s: matched=%v, want %v", name, ok, tt.matched)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		r, ok := e.readCondition(context.Background(), alert, market)
+		if !ok {
+			t.Fatalf("%s: no reading", name)
+		}
+		if r.met() != tt.met || r.rearmed(alert) != tt.rearmed {
+			t.Errorf("%s: met=%v rearmed=%v, want %v %v", name, r.met(), r.rearmed(alert), tt.met, tt.rearmed)
+		}
+	}
+}
+
+func TestCoalesceKeepsSplitsApart(t *testing.T) {
+	before := time.Now().Add(-time.Minute)
+	split := Split{Ratio: 4, AppliedAt: before.Add(30 * time.Second)}
+
+	// A pre-split price coalesced with the restated one isn't a 75% drop
+	market := coalesce(
+		Market{Symbol: "AAPL", Events: EventPrice, Price: 400, At: before},
+		Market{Symbol: "AAPL", Events: EventPrice, Price: 100, At: time.Now(), Split: split},
+	)
+	if low, high := market.PriceRange(); low != 100 || high != 100 {
+		t.Fatalf("range %.0f-%.0f across the split, want 100-100", low, high)
+	}
+}
@@ -0,0 +1,663 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rule expressions combine conditions on the alert's own stock, e.g. on an
+// AAPL alert
+//
+//	AAPL > 200 AND sentimentLabel == Bullish
+//	price < 150 OR volume > 2x avgVolume
+//	SMA50 > SMA200 AND NOT sector == "Energy"
+//
+// Identifiers are stock fields (see ruleFields), the alert's symbol or
+// "price" for the current price, indicators such as RSI14, and avgVolume
+// (or averageVolume). Any other bare word is text, so it can only be
+// compared with text fields.
+//
+// server/utils/alertRule.js mirrors this grammar to reject invalid rules
+// when alerts are created; keep the two in step. Both are tested against
+// testdata/rules.json.
+
+const (
+	maxRuleLength = 500
+	maxRuleNodes  = 100
+)
+
+type ruleType int
+
+const (
+	typeNumber ruleType = iota
+	typeText
+	typeBool
+)
+
+func (t ruleType) String() string {
+	switch t {
+	case typeNumber:
+		return "number"
+	case typeText:
+		return "text"
+	default:
+		return "condition"
+	}
+}
+
+// ruleFields are the stock document fields a rule may reference
+var ruleFields = map[string]ruleType{
+	"currentPrice":     typeNumber,
+	"previousClose":    typeNumber,
+	"change":           typeNumber,
+	"changePercent":    typeNumber,
+	"volume":           typeNumber,
+	"marketCap":        typeNumber,
+	"high24h":          typeNumber,
+	"low24h":           typeNumber,
+	"fiftyTwoWeekHigh": typeNumber,
+	"fiftyTwoWeekLow":  typeNumber,
+	"peRatio":          typeNumber,
+	"dividendYield":    typeNumber,
+	"sentimentScore":   typeNumber,
+	"priceUSD":         typeNumber,
+	"sentimentLabel":   typeText,
+	"sector":           typeText,
+	"industry":         typeText,
+	"exchange":         typeText,
+	"currency":         typeText,
+}
+
+// avgVolumeField is the average daily volume over recent sessions, also
+// accepted as averageVolume
+const (
+	avgVolumeField = "avgVolume"
+	avgVolumeAlias = "averageVolume"
+)
+
+// ruleValue is the result of evaluating a node; only the field matching the
+// node's type is set
+type ruleValue struct {
+	num  float64
+	text string
+	ok   bool
+}
+
+// ruleEnv resolves identifiers while a rule is evaluated. ok is false when
+// the value isn't known yet, which leaves the whole rule undecided.
+type ruleEnv interface {
+	field(name string) (ruleValue, bool)
+	indicator(name string) (float64, bool)
+	avgVolume() (float64, bool)
+}
+
+type ruleNode interface {
+	typ() ruleType
+	eval(env ruleEnv) (ruleValue, bool)
+	String() string
+}
+
+// Rule is a parsed and type-checked rule expression
+type Rule struct {
+	root       ruleNode
+	indicators []string // indicator names the rule reads
+}
+
+// Eval reports whether the rule holds. ok is false if a value it needs is
+// missing.
+func (r *Rule) Eval(env ruleEnv) (holds, ok bool) {
+	v, ok := r.root.eval(env)
+	return v.ok, ok
+}
+
+func (r *Rule) String() string {
+	return r.root.String()
+}
+
+// ParseRule parses and type-checks a rule for an alert on symbol
+func ParseRule(expr, symbol string) (*Rule, error) {
+	// Characters rather than bytes, as the server counts them
+	if utf8.RuneCountInString(expr) > maxRuleLength {
+		return nil, fmt.Errorf("rule is longer than %d characters", maxRuleLength)
+	}
+
+	tokens, err := lexRule(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ruleParser{tokens: tokens, symbol: strings.ToUpper(symbol)}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos+1)
+	}
+	if root.typ() != typeBool {
+		return nil, fmt.Errorf("rule must be a condition, not a %s", root.typ())
+	}
+	return &Rule{root: root, indicators: p.indicators}, nil
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+func lexRule(src string) ([]token, error) {
+	var tokens []token
+	runes := []rune(src)
+
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '(' || c == ')':
+			kind := tokLParen
+			if c == ')' {
+				kind = tokRParen
+			}
+			tokens = append(tokens, token{kind: kind, text: string(c), pos: i})
+			i++
+
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != c {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", i+1)
+			}
+			tokens = append(tokens, token{kind: tokString, text: string(runes[i+1 : end]), pos: i})
+			i = end + 1
+
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			n, err := strconv.ParseFloat(string(runes[start:i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", string(runes[start:i]), start+1)
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: string(runes[start:i]), num: n, pos: start})
+
+			// "2x avgVolume" is shorthand for 2 * avgVolume
+			if i < len(runes) && (runes[i] == 'x' || runes[i] == 'X') &&
+				(i+1 == len(runes) || !isIdentRune(runes[i+1])) {
+				tokens = append(tokens, token{kind: tokOp, text: "*", pos: i})
+				i++
+			}
+
+		case isIdentRune(c):
+			start := i
+			for i < len(runes) && (isIdentRune(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			word := string(runes[start:i])
+			switch strings.ToUpper(word) {
+			case "AND", "OR", "NOT":
+				tokens = append(tokens, token{kind: tokOp, text: strings.ToUpper(word), pos: start})
+			default:
+				tokens = append(tokens, token{kind: tokIdent, text: word, pos: start})
+			}
+
+		default:
+			op := ""
+			for _, candidate := range []string{">=", "<=", "==", "!=", "&&", "||", ">", "<", "=", "!", "+", "-", "*", "/"} {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i+1)
+			}
+			width := len(op)
+			switch op {
+			case "&&":
+				op = "AND"
+			case "||":
+				op = "OR"
+			case "!":
+				op = "NOT"
+			case "=":
+				op = "=="
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += width
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, text: "end of rule", pos: len(runes)}), nil
+}
+
+func isIdentRune(c rune) bool {
+	return unicode.IsLetter(c) || c == '_'
+}
+
+// Parser
+
+type ruleParser struct {
+	tokens     []token
+	pos        int
+	symbol     string
+	nodes      int
+	indicators []string
+}
+
+func (p *ruleParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *ruleParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *ruleParser) accept(ops ...string) (token, bool) {
+	tok := p.peek()
+	if tok.kind != tokOp {
+		return tok, false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			return p.next(), true
+		}
+	}
+	return tok, false
+}
+
+func (p *ruleParser) node(n ruleNode) (ruleNode, error) {
+	p.nodes++
+	if p.nodes > maxRuleNodes {
+		return nil, fmt.Errorf("rule is too complex (more than %d terms)", maxRuleNodes)
+	}
+	return n, nil
+}
+
+func (p *ruleParser) parseOr() (ruleNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.accept("OR")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = p.logical(tok, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *ruleParser) parseAnd() (ruleNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.accept("AND")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = p.logical(tok, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *ruleParser) logical(tok token, left, right ruleNode) (ruleNode, error) {
+	if left.typ() != typeBool || right.typ() != typeBool {
+		return nil, fmt.Errorf("%s at position %d needs a condition on both sides", tok.text, tok.pos+1)
+	}
+	return p.node(&logicalNode{op: tok.text, left: left, right: right})
+}
+
+func (p *ruleParser) parseNot() (ruleNode, error) {
+	if tok, ok := p.accept("NOT"); ok {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if x.typ() != typeBool {
+			return nil, fmt.Errorf("NOT at position %d needs a condition", tok.pos+1)
+		}
+		return p.node(&notNode{x: x})
+	}
+	return p.parseComparison()
+}
+
+func (p *ruleParser) parseComparison() (ruleNode, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	tok, ok := p.accept(">", ">=", "<", "<=", "==", "!=")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+
+	if left.typ() == typeBool || right.typ() == typeBool {
+		return nil, fmt.Errorf("%s at position %d compares conditions; use AND / OR", tok.text, tok.pos+1)
+	}
+	if left.typ() != right.typ() {
+		// A misspelt field reads as text; say so rather than report a type clash
+		for _, side := range []ruleNode{left, right} {
+			if w, ok := side.(*textNode); ok && w.bare {
+				return nil, fmt.Errorf("unknown field %q (rules can use %s's own fields)", w.value, p.symbol)
+			}
+		}
+		return nil, fmt.Errorf("%s at position %d compares %s with %s", tok.text, tok.pos+1, left.typ(), right.typ())
+	}
+	if left.typ() == typeText && tok.text != "==" && tok.text != "!=" {
+		return nil, fmt.Errorf("text can only be compared with == or != (position %d)", tok.pos+1)
+	}
+	return p.node(&compareNode{op: tok.text, left: left, right: right})
+}
+
+func (p *ruleParser) parseSum() (ruleNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = p.arithmetic(tok, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *ruleParser) parseProduct() (ruleNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.accept("*", "/")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = p.arithmetic(tok, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *ruleParser) arithmetic(tok token, left, right ruleNode) (ruleNode, error) {
+	if left.typ() != typeNumber || right.typ() != typeNumber {
+		return nil, fmt.Errorf("%s at position %d needs numbers on both sides", tok.text, tok.pos+1)
+	}
+	return p.node(&arithNode{op: tok.text, left: left, right: right})
+}
+
+func (p *ruleParser) parseUnary() (ruleNode, error) {
+	if tok, ok := p.accept("-"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if x.typ() != typeNumber {
+			return nil, fmt.Errorf("- at position %d needs a number", tok.pos+1)
+		}
+		return p.node(&arithNode{op: "-", left: &numberNode{}, right: x})
+	}
+	return p.parsePrimary()
+}
+
+func (p *ruleParser) parsePrimary() (ruleNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		return p.node(&numberNode{value: tok.num})
+
+	case tokString:
+		return p.node(&textNode{value: tok.text})
+
+	case tokIdent:
+		ident, err := p.identifier(tok.text)
+		if err != nil {
+			return nil, err
+		}
+		return p.node(ident)
+
+	case tokLParen:
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) at position %d, got %q", closing.pos+1, closing.text)
+		}
+		return x, nil
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos+1)
+}
+
+// identifier resolves a name to a field, indicator or bare word
+func (p *ruleParser) identifier(name string) (ruleNode, error) {
+	switch {
+	case strings.EqualFold(name, p.symbol), strings.EqualFold(name, "price"):
+		return &fieldNode{name: "currentPrice", kind: typeNumber}, nil
+	case strings.EqualFold(name, avgVolumeField), strings.EqualFold(name, avgVolumeAlias):
+		return &avgVolumeNode{}, nil
+	case indicatorName.MatchString(strings.ToUpper(name)):
+		name = strings.ToUpper(name)
+		if _, _, err := parseIndicator(name); err != nil {
+			return nil, err
+		}
+		p.indicators = append(p.indicators, name)
+		return &indicatorNode{name: name}, nil
+	}
+	for field, kind := range ruleFields {
+		if strings.EqualFold(name, field) {
+			return &fieldNode{name: field, kind: kind}, nil
+		}
+	}
+	return &textNode{value: name, bare: true}, nil
+}
+
+// AST
+
+type numberNode struct{ value float64 }
+
+func (n *numberNode) typ() ruleType                  { return typeNumber }
+func (n *numberNode) eval(ruleEnv) (ruleValue, bool) { return ruleValue{num: n.value}, true }
+func (n *numberNode) String() string                 { return strconv.FormatFloat(n.value, 'f', -1, 64) }
+
+type textNode struct {
+	value string
+	bare  bool // written without quotes
+}
+
+func (n *textNode) typ() ruleType                  { return typeText }
+func (n *textNode) eval(ruleEnv) (ruleValue, bool) { return ruleValue{text: n.value}, true }
+func (n *textNode) String() string                 { return strconv.Quote(n.value) }
+
+type fieldNode struct {
+	name string
+	kind ruleType
+}
+
+func (n *fieldNode) typ() ruleType                      { return n.kind }
+func (n *fieldNode) eval(env ruleEnv) (ruleValue, bool) { return env.field(n.name) }
+func (n *fieldNode) String() string                     { return n.name }
+
+type indicatorNode struct{ name string }
+
+func (n *indicatorNode) typ() ruleType { return typeNumber }
+func (n *indicatorNode) eval(env ruleEnv) (ruleValue, bool) {
+	v, ok := env.indicator(n.name)
+	return ruleValue{num: v}, ok
+}
+func (n *indicatorNode) String() string { return n.name }
+
+type avgVolumeNode struct{}
+
+func (n *avgVolumeNode) typ() ruleType { return typeNumber }
+func (n *avgVolumeNode) eval(env ruleEnv) (ruleValue, bool) {
+	v, ok := env.avgVolume()
+	return ruleValue{num: v}, ok
+}
+func (n *avgVolumeNode) String() string { return avgVolumeField }
+
+type arithNode struct {
+	op          string
+	left, right ruleNode
+}
+
+func (n *arithNode) typ() ruleType { return typeNumber }
+func (n *arithNode) eval(env ruleEnv) (ruleValue, bool) {
+	l, ok := n.left.eval(env)
+	if !ok {
+		return ruleValue{}, false
+	}
+	r, ok := n.right.eval(env)
+	if !ok {
+		return ruleValue{}, false
+	}
+	switch n.op {
+	case "+":
+		return ruleValue{num: l.num + r.num}, true
+	case "-":
+		return ruleValue{num: l.num - r.num}, true
+	case "*":
+		return ruleValue{num: l.num * r.num}, true
+	default:
+		if r.num == 0 {
+			return ruleValue{}, false
+		}
+		return ruleValue{num: l.num / r.num}, true
+	}
+}
+func (n *arithNode) String() string {
+	return "(" + n.left.String() + " " + n.op + " " + n.right.String() + ")"
+}
+
+type compareNode struct {
+	op          string
+	left, right ruleNode
+}
+
+func (n *compareNode) typ() ruleType { return typeBool }
+func (n *compareNode) eval(env ruleEnv) (ruleValue, bool) {
+	l, ok := n.left.eval(env)
+	if !ok {
+		return ruleValue{}, false
+	}
+	r, ok := n.right.eval(env)
+	if !ok {
+		return ruleValue{}, false
+	}
+
+	if n.left.typ() == typeText {
+		equal := strings.EqualFold(l.text, r.text)
+		return ruleValue{ok: equal == (n.op == "==")}, true
+	}
+
+	var holds bool
+	switch n.op {
+	case ">":
+		holds = l.num > r.num
+	case ">=":
+		holds = l.num >= r.num
+	case "<":
+		holds = l.num < r.num
+	case "<=":
+		holds = l.num <= r.num
+	case "==":
+		holds = l.num == r.num
+	case "!=":
+		holds = l.num != r.num
+	}
+	return ruleValue{ok: holds}, true
+}
+func (n *compareNode) String() string {
+	return "(" + n.left.String() + " " + n.op + " " + n.right.String() + ")"
+}
+
+type logicalNode struct {
+	op          string
+	left, right ruleNode
+}
+
+func (n *logicalNode) typ() ruleType { return typeBool }
+
+// eval short-circuits, so one side being decided is enough even if the other
+// is missing data
+func (n *logicalNode) eval(env ruleEnv) (ruleValue, bool) {
+	l, lok := n.left.eval(env)
+	if lok && l.ok == (n.op == "OR") {
+		return l, true
+	}
+	r, rok := n.right.eval(env)
+	if rok && r.ok == (n.op == "OR") {
+		return r, true
+	}
+	if !lok || !rok {
+		return ruleValue{}, false
+	}
+	return r, true
+}
+func (n *logicalNode) String() string {
+	return "(" + n.left.String() + " " + n.op + " " + n.right.String() + ")"
+}
+
+type notNode struct{ x ruleNode }
+
+func (n *notNode) typ() ruleType { return typeBool }
+func (n *notNode) eval(env ruleEnv) (ruleValue, bool) {
+	v, ok := n.x.eval(env)
+	return ruleValue{ok: !v.ok}, ok
+}
+func (n *notNode) String() string { return "NOT " + n.x.String() }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// testdata/rules.json is shared with server/utils/alertRule.test.js, so the
+// engine and the server accept and reject the same rules with the same
+// messages. A case without an error must parse; tree, if set, is the parsed
+// rule with every operator bracketed, which pins down precedence.
+type ruleVectors struct {
+	Symbol string `json:"symbol"`
+	Cases  []struct {
+		Rule   string `json:"rule"`
+		Symbol string `json:"symbol"`
+		Tree   string `json:"tree"`
+		Error  string `json:"error"`
+	} `json:"cases"`
+}
+
+func TestParseRuleVectors(t *testing.T) {
+	data, err := os.ReadFile("testdata/rules.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors ruleVectors
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range vectors.Cases {
+		symbol := tt.Symbol
+		if symbol == "" {
+			symbol = vectors.Symbol
+		}
+		name := tt.Rule
+		if len(name) > 60 {
+			name = name[:60]
+		}
+		t.Run(name, func(t *testing.T) {
+			rule, err := ParseRule(tt.Rule, symbol)
+			switch {
+			case tt.Error != "":
+				if err == nil || err.Error() != tt.Error {
+					t.Errorf("error %v, want %q", err, tt.Error)
+				}
+			case err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.Tree != "" && rule.String() != tt.Tree:
+				t.Errorf("parsed as %s, want %s", rule, tt.Tree)
+			}
+		})
+	}
+}
+
+// mapEnv is a ruleEnv over fixed values; anything absent is missing data
+type mapEnv struct {
+	fields     map[string]ruleValue
+	indicators map[string]float64
+	avg        float64
+}
+
+func (e mapEnv) field(name string) (ruleValue, bool) {
+	v, ok := e.fields[name]
+	return v, ok
+}
+
+func (e mapEnv) indicator(name string) (float64, bool) {
+	v, ok := e.indicators[name]
+	return v, ok
+}
+
+func (e mapEnv) avgVolume() (float64, bool) {
+	return e.avg, e.avg > 0
+}
+
+func TestRuleEval(t *testing.T) {
+	env := mapEnv{
+		fields: map[string]ruleValue{
+			"currentPrice":   {num: 150},
+			"volume":         {num: 3_000_000},
+			"changePercent":  {num: 3},
+			"sentimentLabel": {text: "Bullish"},
+			"sector":         {text: "Technology"},
+		},
+		indicators: map[string]float64{"SMA50": 140, "SMA200": 120},
+		avg:        1_000_000,
+	}
+
+	tests := []struct {
+		rule      string
+		holds, ok bool
+	}{
+		// AND binds tighter than OR: false if read as (… OR …) AND …
+		{"price > 100 OR volume < 5 AND changePercent < 2", true, true},
+		{"(price > 100 OR volume < 5) AND changePercent < 2", false, true},
+		{"NOT price > 200 AND sentimentLabel == bullish", true, true},
+		{"price == 100 + 25 * 2", true, true},
+		{"volume > 2x avgVolume", true, true},
+		{"volume > 3.5x averageVolume", false, true},
+		{"SMA50 > SMA200 AND sector != 'Energy'", true, true},
+		{"sentimentLabel == 'Somewhat Bullish'", false, true},
+		// Missing data leaves the rule undecided unless the other side settles it
+		{"RSI14 < 30", false, false},
+		{"price > 100 OR RSI14 < 30", true, true},
+		{"price > 200 AND RSI14 < 30", false, true},
+		{"price > 100 AND RSI14 < 30", false, false},
+		{"NOT RSI14 < 30", false, false},
+		{"peRatio > 10", false, false},
+		{"price / (changePercent - 3) > 1", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			rule, err := ParseRule(tt.rule, "AAPL")
+			if err != nil {
+				t.Fatal(err)
+			}
+			holds, ok := rule.Eval(env)
+			if ok != tt.ok || (ok && holds != tt.holds) {
+				t.Errorf("Eval = %v, %v; want %v, %v", holds, ok, tt.holds, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// referencePrice names the live price as the other side of a crossover
+const referencePrice = "PRICE"
+
+// barSizes are the pricebars intervals maintained by the price-updater
+var barSizes = map[string]time.Duration{
+	"1m": time.Minute,
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+}
+
+// IndicatorBook keeps the indicators referenced by alerts, per symbol, on
+// one bar interval. A symbol's bars are loaded from pricebars the first time
+// one of its indicators is read; after that each price event updates the bar
+// in progress and a new bar closes the previous one. When a split is
+// applied the bars are loaded again, as the price-updater restates them.
+type IndicatorBook struct {
+	bars     *mongo.Collection
+	interval string
+	size     time.Duration
+	keep     int // closed bars kept per symbol so new indicators can be replayed
+
+	mu      sync.Mutex
+	series  map[string]*barSeries
+	loading map[string]bool
+	splits  map[string]time.Time // when the last known split was applied
+}
+
+type barSeries struct {
+	start      time.Time // bucket of the bar in progress
+	last       float64   // latest price in that bar
+	closes     []float64
+	indicators map[string]Indicator
+}
+
+// NewIndicatorBook reads ALERT_INDICATOR_INTERVAL (1m, 1h or 1d, default 1d)
+// and ALERT_INDICATOR_BARS (default maxIndicatorPeriod)
+func NewIndicatorBook(db *mongo.Database) *IndicatorBook {
+	interval := os.Getenv("ALERT_INDICATOR_INTERVAL")
+	size, ok := barSizes[interval]
+	if !ok {
+		if interval != "" {
+			log.Printf("Invalid ALERT_INDICATOR_INTERVAL %q, using 1d", interval)
+		}
+		interval, size = "1d", barSizes["1d"]
+	}
+
+	return &IndicatorBook{
+		bars:     db.Collection("pricebars"),
+		interval: interval,
+		size:     size,
+		keep:     envInt("ALERT_INDICATOR_BARS", maxIndicatorPeriod),
+		series:   make(map[string]*barSeries),
+		loading:  make(map[string]bool),
+		splits:   make(map[string]time.Time),
+	}
+}
+
+// Observe records a price event for symbols whose bars are loaded
+func (b *IndicatorBook) Observe(symbol string, at time.Time, price float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.series[symbol]
+	if !ok {
+		return
+	}
+
+	bucket := at.UTC().Truncate(b.size)
+	if bucket.After(s.start) {
+		if s.last > 0 {
+			s.close(s.last, b.keep)
+		}
+		s.start = bucket
+	}
+	s.last = price
+}
+
+// Split drops the symbol's bars when the split is newer than the one known,
+// so they're loaded again split-adjusted
+func (b *IndicatorBook) Split(symbol string, split Split) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !split.AppliedAt.After(b.splits[symbol]) {
+		return
+	}
+	b.splits[symbol] = split.AppliedAt
+	delete(b.series, symbol)
+}
+
+// Validate reports why the named indicator (or PRICE) can never be read:
+// it doesn't parse, or it looks back further than the bars kept
+func (b *IndicatorBook) Validate(name string) error {
+	if name == referencePrice {
+		return nil
+	}
+	_, period, err := parseIndicator(name)
+	if err != nil {
+		return err
+	}
+	if period > b.keep {
+		return fmt.Errorf("indicator %s needs %d bars, only %d are kept (ALERT_INDICATOR_BARS)", name, period, b.keep)
+	}
+	return nil
+}
+
+// Read returns the named indicator (or PRICE) as of the last closed bar and
+// as of the bar in progress. ok is false while bars are loading, if there
+// isn't enough history yet, or if the name isn't valid (see Validate).
+func (b *IndicatorBook) Read(ctx context.Context, symbol, name string) (prior, current float64, ok bool) {
+	if !b.load(ctx, symbol) {
+		return 0, 0, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.series[symbol]
+	if s.last <= 0 {
+		return 0, 0, false
+	}
+
+	if name == referencePrice {
+		if len(s.closes) == 0 {
+			return 0, 0, false
+		}
+		return s.closes[len(s.closes)-1], s.last, true
+	}
+
+	ind, found := s.indicators[name]
+	if !found {
+		if b.Validate(name) != nil {
+			return 0, 0, false
+		}
+		ind, _ = NewIndicator(name)
+		for _, c := range s.closes {
+			ind.Update(c)
+		}
+		s.indicators[name] = ind
+	}
+
+	if prior, ok = ind.Value(); !ok {
+		return 0, 0, false
+	}
+	current, ok = ind.Peek(s.last)
+	return prior, current, ok
+}
+
+func (s *barSeries) close(price float64, keep int) {
+	s.closes = append(s.closes, price)
+	if len(s.closes) > keep {
+		s.closes = s.closes[len(s.closes)-keep:]
+	}
+	for _, ind := range s.indicators {
+		ind.Update(price)
+	}
+}
+
+// load fetches the symbol's recent bars once. It returns false while
+// another goroutine is loading them.
+func (b *IndicatorBook) load(ctx context.Context, symbol string) bool {
+	b.mu.Lock()
+	if _, ok := b.series[symbol]; ok {
+		b.mu.Unlock()
+		return true
+	}
+	if b.loading[symbol] {
+		b.mu.Unlock()
+		return false
+	}
+	b.loading[symbol] = true
+	split := b.splits[symbol]
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.loading, symbol)
+		b.mu.Unlock()
+	}()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "start", Value: -1}}).
+		SetLimit(int64(b.keep + 1))
+	cursor, err := b.bars.Find(ctx, bson.M{"symbol": symbol, "interval": b.interval}, opts)
+	if err != nil {
+		log.Printf("Failed to load %s bars for %s: %v", b.interval, symbol, err)
+		return false
+	}
+	var bars []struct {
+		Start time.Time `bson:"start"`
+		Close float64   `bson:"close"`
+	}
+	if err := cursor.All(ctx, &bars); err != nil {
+		log.Printf("Failed to load %s bars for %s: %v", b.interval, symbol, err)
+		return false
+	}
+
+	s := &barSeries{indicators: make(map[string]Indicator)}
+	current := time.Now().UTC().Truncate(b.size)
+	for i := len(bars) - 1; i >= 0; i-- {
+		bar := bars[i]
+		if !bar.Start.Before(current) {
+			// The bar still in progress
+			s.start, s.last = bar.Start, bar.Close
+			continue
+		}
+		s.closes = append(s.closes, bar.Close)
+	}
+	if len(s.closes) > b.keep {
+		s.closes = s.closes[len(s.closes)-b.keep:]
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.splits[symbol].Equal(split) {
+		// A split was applied while loading; the bars may predate it
+		return false
+	}
+	b.series[symbol] = s
+	return true
+}
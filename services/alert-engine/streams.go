@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Server error codes for a resume token that is no longer in the oplog
+const (
+	codeChangeStreamFatal       = 280
+	codeChangeStreamHistoryLost = 286
+)
+
+// StreamConfig controls checkpointing and reconnection of change streams
+type StreamConfig struct {
+	CheckpointEvery time.Duration
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+}
+
+func loadStreamConfig() StreamConfig {
+	return StreamConfig{
+		CheckpointEvery: envDuration("ALERT_CHECKPOINT_INTERVAL", 5*time.Second),
+		BaseDelay:       envDuration("ALERT_STREAM_BASE_DELAY", time.Second),
+		MaxDelay:        envDuration("ALERT_STREAM_MAX_BACKOFF", 30*time.Second),
+	}
+}
+
+// backoff returns the delay before retry number attempt (starting at 1):
+// exponential from base up to maxDelay, with full jitter
+func backoff(base, maxDelay time.Duration, attempt int) time.Duration {
+	ceiling := base << (attempt - 1)
+	if ceiling <= 0 || ceiling > maxDelay {
+		ceiling = maxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Checkpoints stores the resume token of each change stream
+type Checkpoints struct {
+	coll *mongo.Collection
+}
+
+func NewCheckpoints(db *mongo.Database) *Checkpoints {
+	return &Checkpoints{coll: db.Collection("alertcheckpoints")}
+}
+
+// Load returns the saved resume token of a stream, or nil if there is none
+func (c *Checkpoints) Load(ctx context.Context, name string) (bson.Raw, error) {
+	var doc struct {
+		Token bson.Raw `bson:"token"`
+	}
+	err := c.coll.FindOne(ctx, bson.M{"_id": name}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	return doc.Token, err
+}
+
+func (c *Checkpoints) Save(ctx context.Context, name string, token bson.Raw) error {
+	_, err := c.coll.UpdateOne(ctx,
+		bson.M{"_id": name},
+		bson.M{"$set": bson.M{"token": token, "updatedAt": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (c *Checkpoints) Clear(ctx context.Context, name string) error {
+	_, err := c.coll.DeleteOne(ctx, bson.M{"_id": name})
+	return err
+}
+
+// watermark tracks the events a stream has handed on, in stream order, and
+// the token of the last event up to which all of them have been processed.
+// Events are evaluated asynchronously on the worker pool, so only that low
+// watermark is checkpointed: a restart must not skip events still queued.
+type watermark struct {
+	mu      sync.Mutex
+	pending []*streamEvent
+	low     bson.Raw
+	changed bool // low moved since it was last taken
+}
+
+type streamEvent struct {
+	token bson.Raw
+	done  bool
+}
+
+// add records an event and returns the func that marks it processed
+func (w *watermark) add(token bson.Raw) func() {
+	ev := &streamEvent{token: append(bson.Raw(nil), token...)}
+	w.mu.Lock()
+	w.pending = append(w.pending, ev)
+	w.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			ev.done = true
+			i := 0
+			for ; i < len(w.pending) && w.pending[i].done; i++ {
+				w.low = w.pending[i].token
+				w.changed = true
+			}
+			w.pending = w.pending[i:]
+		})
+	}
+}
+
+// take returns the low watermark if it moved since the last call
+func (w *watermark) take() (bson.Raw, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.changed {
+		return nil, false
+	}
+	w.changed = false
+	return w.low, true
+}
+
+// reset forgets every event, after a rescan has covered them
+func (w *watermark) reset() {
+	w.mu.Lock()
+	w.pending = nil
+	w.low = nil
+	w.changed = false
+	w.mu.Unlock()
+}
+
+// resumableStream is a change stream that survives restarts and failovers
+type resumableStream struct {
+	name         string // checkpoint id
+	coll         *mongo.Collection
+	pipeline     mongo.Pipeline
+	fullDocument options.FullDocument
+
+	// handle decodes and dispatches the stream's current event. It must
+	// call done once the event is processed, or dropped.
+	handle func(stream *mongo.ChangeStream, done func())
+	// rescan catches up on everything when the stream can't be resumed
+	rescan func(ctx context.Context) error
+	// reload, if set, rebuilds the state the stream maintains after every
+	// connect. Such streams aren't checkpointed and don't need rescan.
+	reload func(ctx context.Context) error
+
+	marks watermark
+}
+
+// Run watches until ctx is cancelled. It resumes after the last
+// checkpointed event and reconnects with exponential backoff when the
+// stream fails. If the checkpoint has fallen out of the oplog the events in
+// between are lost, so it rescans instead and starts afresh.
+//
+// The checkpoint is the stream's low watermark, saved every
+// CheckpointEvery. Events still queued when Run returns are covered by
+// Flush once the worker pool has drained.
+func (s *resumableStream) Run(ctx context.Context, checkpoints *Checkpoints, cfg StreamConfig) {
+	var token bson.Raw
+	var err error
+	if s.reload == nil {
+		token, err = checkpoints.Load(ctx, s.name)
+		if err != nil {
+			log.Printf("Failed to load %s checkpoint, starting from now: %v", s.name, err)
+		}
+		if token != nil {
+			fmt.Printf("Resuming %s stream from checkpoint\n", s.name)
+		}
+
+		go func() {
+			ticker := time.NewTicker(cfg.CheckpointEvery)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					s.save(ctx, checkpoints)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	failures := 0
+	for ctx.Err() == nil {
+		var received bool
+		token, received, err = s.watch(ctx, token)
+		if ctx.Err() != nil {
+			return
+		}
+		if s.reload != nil {
+			token = nil
+		}
+		if received {
+			failures = 0
+		}
+
+		if historyLost(err) {
+			log.Printf("%s stream checkpoint expired from the oplog, rescanning active alerts", s.name)
+			token = nil
+			s.marks.reset()
+			if err := checkpoints.Clear(ctx, s.name); err != nil {
+				log.Printf("Failed to clear %s checkpoint: %v", s.name, err)
+			}
+			if err := s.rescan(ctx); err != nil {
+				log.Printf("%s rescan failed: %v", s.name, err)
+			}
+			continue
+		}
+
+		failures++
+		delay := backoff(cfg.BaseDelay, cfg.MaxDelay, failures)
+		log.Printf("%s stream interrupted: %v (reconnecting in %v)", s.name, err, delay.Round(time.Millisecond))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// watch runs one connection of the stream and returns the token of the last
+// event handled. Events handed on but not yet processed are still
+// processed, so a reconnect resumes after them.
+func (s *resumableStream) watch(ctx context.Context, token bson.Raw) (bson.Raw, bool, error) {
+	opts := options.ChangeStream()
+	if s.fullDocument != "" {
+		opts.SetFullDocument(s.fullDocument)
+	}
+	if token != nil {
+		opts.SetResumeAfter(token)
+	}
+
+	stream, err := s.coll.Watch(ctx, s.pipeline, opts)
+	if err != nil {
+		return token, false, err
+	}
+	defer stream.Close(context.Background())
+
+	// Load after the stream is open, so no change made meanwhile is missed
+	if s.reload != nil {
+		if err := s.reload(ctx); err != nil {
+			return token, false, err
+		}
+	}
+
+	received := false
+	for stream.Next(ctx) {
+		token = stream.ResumeToken()
+		s.handle(stream, s.marks.add(token))
+		received = true
+	}
+
+	err = stream.Err()
+	if err == nil {
+		err = errors.New("stream closed")
+	}
+	return token, received, err
+}
+
+// save checkpoints the low watermark if it moved
+func (s *resumableStream) save(ctx context.Context, checkpoints *Checkpoints) {
+	if s.reload != nil {
+		return
+	}
+	token, ok := s.marks.take()
+	if !ok {
+		return
+	}
+	if err := checkpoints.Save(ctx, s.name, token); err != nil {
+		log.Printf("Failed to save %s checkpoint: %v", s.name, err)
+	}
+}
+
+// Flush saves the final checkpoint on shutdown, after the worker pool has
+// processed the events still queued
+func (s *resumableStream) Flush(checkpoints *Checkpoints) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.save(ctx, checkpoints)
+}
+
+// historyLost reports whether a resume token can no longer be used
+func historyLost(err error) bool {
+	var se mongo.ServerError
+	return errors.As(err, &se) &&
+		(se.HasErrorCode(codeChangeStreamHistoryLost) || se.HasErrorCode(codeChangeStreamFatal))
+}
+
+// rescanStocks evaluates every stock with active alerts as if its price and
+// sentiment had just changed, to catch up on updates the stream missed
+func (e *Engine) rescanStocks(ctx context.Context) error {
+	symbols, err := e.alerts.Distinct(ctx, "symbol", bson.M{"isActive": true})
+	if err != nil {
+		return err
+	}
+	cursor, err := e.stocks.Find(ctx, bson.M{"symbol": bson.M{"$in": symbols}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	now := time.Now()
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		market, ok := marketFromDocument(doc, now)
+		if !ok {
+			continue
+		}
+		market.Events = EventPrice | EventSentiment
+		e.Dispatch(e.Observe(market))
+	}
+	return cursor.Err()
+}
+
+// rescanQuestions re-counts questions for every stock with an active
+// QUESTION_SPIKE alert
+func (e *Engine) rescanQuestions(ctx context.Context) error {
+	symbols, err := e.alerts.Distinct(ctx, "symbol", bson.M{"isActive": true, "condition": ConditionQuestionSpike})
+	if err != nil {
+		return err
+	}
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cursor, err := e.stocks.Find(ctx, bson.M{"symbol": bson.M{"$in": symbols}}, opts)
+	if err != nil {
+		return err
+	}
+	var stocks []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &stocks); err != nil {
+		return err
+	}
+
+	for _, s := range stocks {
+		e.checkQuestionVolume(s.ID, nil)
+	}
+	return nil
+}
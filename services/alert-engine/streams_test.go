@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestWatermarkOnlyPassesProcessedEvents(t *testing.T) {
+	token := func(n int32) bson.Raw {
+		raw, _ := bson.Marshal(bson.M{"_data": n})
+		return raw
+	}
+	var w watermark
+	first := w.add(token(1))
+	second := w.add(token(2))
+	third := w.add(token(3))
+
+	if _, ok := w.take(); ok {
+		t.Fatal("watermark moved before any event was processed")
+	}
+
+	// Later events finishing first don't move it
+	third()
+	second()
+	if _, ok := w.take(); ok {
+		t.Fatal("watermark moved past an event still queued")
+	}
+
+	first()
+	first() // done is idempotent
+	got, ok := w.take()
+	if !ok || !bytes.Equal(got, token(3)) {
+		t.Fatalf("watermark = %v, want token 3", got)
+	}
+	if _, ok := w.take(); ok {
+		t.Fatal("unchanged watermark taken twice")
+	}
+}
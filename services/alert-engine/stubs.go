@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// runStubs serves a local SMTP server and webhook receiver that print what
+// they are sent, for trying out delivery without real endpoints:
+//
+//	stubs [-smtp :2525] [-http :8025] [-secret s] [-status 200]
+//
+// Point SMTP_HOST=localhost SMTP_PORT=2525 and a user's alertWebhook.url at
+// http://localhost:8025/ to use them; the engine (and API) need
+// ALERT_WEBHOOK_ALLOW_PRIVATE=true to deliver to a local address. Webhook
+// signatures are checked against -secret (default ALERT_WEBHOOK_SECRET);
+// -status makes the receiver answer with another code, e.g. 503 to exercise
+// failures.
+func runStubs(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("stubs", flag.ContinueOnError)
+	smtpAddr := fs.String("smtp", ":2525", "SMTP listen address")
+	httpAddr := fs.String("http", ":8025", "webhook listen address")
+	secret := fs.String("secret", os.Getenv("ALERT_WEBHOOK_SECRET"), "webhook signing secret to verify")
+	status := fs.Int("status", http.StatusOK, "status code the webhook receiver answers with")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", *smtpAddr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn)
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		verdict := "unsigned"
+		if sig := r.Header.Get("X-StockForumX-Signature"); sig != "" {
+			verdict = "signature not checked (no -secret)"
+			if *secret != "" {
+				want := "sha256=" + signWebhook(*secret, r.Header.Get("X-StockForumX-Timestamp"), body)
+				verdict = "signature INVALID"
+				if hmac.Equal([]byte(sig), []byte(want)) {
+					verdict = "signature ok"
+				}
+			}
+		}
+		fmt.Printf("stubs: webhook %s %s (%s)\n%s\n\n", r.Method, r.URL.Path, verdict, body)
+		w.WriteHeader(*status)
+	})
+	server := &http.Server{Addr: *httpAddr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("stubs: SMTP on %s, webhooks on %s\n", *smtpAddr, *httpAddr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// serveSMTP speaks just enough SMTP for net/smtp: no TLS, and any AUTH
+// PLAIN credentials are accepted
+func serveSMTP(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	reply := func(line string) { tp.PrintfLine("%s", line) }
+
+	reply("220 localhost stub ESMTP")
+	var from string
+	var to []string
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		var arg string // the address of MAIL FROM: and RCPT TO:
+		if i := strings.Index(line, ":"); i >= 0 {
+			arg = line[i+1:]
+		}
+
+		switch verb {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "HELO", "NOOP":
+			reply("250 OK")
+		case "AUTH":
+			reply("235 Authenticated")
+		case "MAIL":
+			from, to = arg, nil
+			reply("250 OK")
+		case "RCPT":
+			to = append(to, arg)
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			fmt.Printf("stubs: mail from %s to %s\n%s\n", from, strings.Join(to, ", "), data)
+			reply("250 OK")
+		case "RSET":
+			from, to = "", nil
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
@@ -26,9 +26,41 @@ type Stock struct {
 	ID           primitive.ObjectID `bson:"_id"`
 	Symbol       string             `bson:"symbol"`
 	CurrentPrice float64            `bson:"currentPrice"`
+	PriceUSD     float64            `bson:"priceUSD"`
+	Currency     string             `bson:"currency"`
 	Sector       string             `bson:"sector"`
 }
 
+// usdPrice values non-USD listings (e.g. RELIANCE.NS) in USD, the currency
+// of user balances. priceUSD is maintained by price-updater; until it knows
+// the exchange rate a non-USD listing has no USD price, and its native price
+// must not be used instead.
+func (s Stock) usdPrice() (float64, bool) {
+	if s.PriceUSD > 0 {
+		return s.PriceUSD, true
+	}
+	if s.Currency == "" || s.Currency == "USD" {
+		return s.CurrentPrice, true
+	}
+	return 0, false
+}
+
+// usdPriceExpr is usdPrice as an aggregation expression on $stock, null
+// when there is no USD price
+var usdPriceExpr = bson.D{{Key: "$switch", Value: bson.D{
+	{Key: "branches", Value: bson.A{
+		bson.D{
+			{Key: "case", Value: bson.D{{Key: "$gt", Value: bson.A{"$stock.priceUSD", 0}}}},
+			{Key: "then", Value: "$stock.priceUSD"},
+		},
+		bson.D{
+			{Key: "case", Value: bson.D{{Key: "$in", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$stock.currency", "USD"}}}, bson.A{"", "USD"}}}}},
+			{Key: "then", Value: "$stock.currentPrice"},
+		},
+	}},
+	{Key: "default", Value: nil},
+}}}
+
 type Holding struct {
 	ID           primitive.ObjectID `bson:"_id"`
 	UserId       primitive.ObjectID `bson:"userId"`
@@ -141,15 +173,27 @@ func takeSnapshots(db *mongo.Database) {
 		hCursor, _ := holdingsColl.Find(context.Background(), bson.M{"userId": user.ID})
 		
 		holdingsValue := 0.0
+		var unconverted []string
 		for hCursor.Next(context.Background()) {
 			var h Holding
 			hCursor.Decode(&h)
 			if s, ok := stockMap[h.StockId]; ok {
-				holdingsValue += h.Quantity * s.CurrentPrice
+				price, ok := s.usdPrice()
+				if !ok {
+					unconverted = append(unconverted, s.Symbol)
+					continue
+				}
+				holdingsValue += h.Quantity * price
 			}
 		}
 		hCursor.Close(context.Background())
 
+		// A snapshot missing holdings would show up as a drop in the history
+		if len(unconverted) > 0 {
+			log.Printf("Skipping snapshot for user %s: no USD price for %v yet", user.ID.Hex(), unconverted)
+			continue
+		}
+
 		// Save snapshot
 		now := time.Now()
 		snapshot := PortfolioSnapshot{
@@ -183,10 +227,13 @@ func calculateDiversification(db *mongo.Database, userId primitive.ObjectID) ([]
 			{Key: "as", Value: "stock"},
 		}}},
 		{{Key: "$unwind", Value: "$stock"}},
+		// Holdings without a USD price yet are left out
+		{{Key: "$set", Value: bson.D{{Key: "price", Value: usdPriceExpr}}}},
+		{{Key: "$match", Value: bson.D{{Key: "price", Value: bson.D{{Key: "$ne", Value: nil}}}}}},
 		{{Key: "$group", Value: bson.D{
 			{Key: "_id", Value: "$stock.sector"},
 			{Key: "value", Value: bson.D{{Key: "$sum", Value: bson.D{
-				{Key: "$multiply", Value: bson.A{"$quantity", "$stock.currentPrice"}},
+				{Key: "$multiply", Value: bson.A{"$quantity", "$price"}},
 			}}}},
 		}}},
 	}
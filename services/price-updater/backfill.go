@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BackfillOptions are the parsed arguments of the backfill command
+type BackfillOptions struct {
+	Symbols  []string // empty means every symbol in the stocks collection
+	Interval string
+	From     time.Time
+	To       time.Time
+	File     string
+}
+
+// parseBackfillArgs parses:
+//
+//	backfill -symbols AAPL,MSFT -from 2024-01-01 [-to 2024-06-30] [-interval 1d] [-file bars.csv]
+//	backfill -symbols all -range 5d -interval 1h
+func parseBackfillArgs(args []string) (BackfillOptions, error) {
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	symbols := fs.String("symbols", "", "comma separated symbols, or \"all\"")
+	interval := fs.String("interval", "1d", "bar interval: 1m, 1h or 1d")
+	from := fs.String("from", "", "start date (YYYY-MM-DD)")
+	to := fs.String("to", "", "end date (YYYY-MM-DD), defaults to now")
+	lookback := fs.String("range", "", "relative range instead of -from, e.g. 5d, 1mo, 1y")
+	file := fs.String("file", "", "replay bars from a local .csv or .json file instead of the provider")
+
+	if err := fs.Parse(args); err != nil {
+		return BackfillOptions{}, err
+	}
+
+	opts := BackfillOptions{Interval: *interval, File: *file, To: time.Now().UTC()}
+
+	if _, ok := intervalSize(opts.Interval); !ok {
+		return opts, fmt.Errorf("unsupported interval %q", opts.Interval)
+	}
+
+	switch {
+	case *symbols == "":
+		return opts, fmt.Errorf("-symbols is required")
+	case strings.EqualFold(*symbols, "all"):
+		// resolved from the database later
+	default:
+		for _, s := range strings.Split(*symbols, ",") {
+			if s = strings.ToUpper(strings.TrimSpace(s)); s != "" {
+				opts.Symbols = append(opts.Symbols, s)
+			}
+		}
+	}
+
+	if *to != "" {
+		t, err := time.Parse("2006-01-02", *to)
+		if err != nil {
+			return opts, fmt.Errorf("-to: %w", err)
+		}
+		opts.To = t.Add(24*time.Hour - time.Second) // inclusive
+	}
+
+	switch {
+	case *from != "":
+		t, err := time.Parse("2006-01-02", *from)
+		if err != nil {
+			return opts, fmt.Errorf("-from: %w", err)
+		}
+		opts.From = t
+	case *lookback != "":
+		d, err := parseRange(*lookback)
+		if err != nil {
+			return opts, err
+		}
+		opts.From = opts.To.Add(-d)
+	default:
+		return opts, fmt.Errorf("either -from or -range is required")
+	}
+
+	if !opts.From.Before(opts.To) {
+		return opts, fmt.Errorf("-from must be before -to")
+	}
+	return opts, nil
+}
+
+// parseRange understands Yahoo-style ranges: 5d, 3mo, 1y
+func parseRange(s string) (time.Duration, error) {
+	units := []struct {
+		suffix string
+		size   time.Duration
+	}{
+		{"mo", 30 * 24 * time.Hour},
+		{"d", 24 * time.Hour},
+		{"y", 365 * 24 * time.Hour},
+	}
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, u.suffix))
+			if err != nil || n <= 0 {
+				break
+			}
+			return time.Duration(n) * u.size, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid -range %q (expected e.g. 5d, 3mo, 1y)", s)
+}
+
+// runBackfill fetches historical bars for each symbol and upserts them into
+// the price history store.
+func runBackfill(ctx context.Context, db *mongo.Database, provider PriceProvider, args []string) error {
+	opts, err := parseBackfillArgs(args)
+	if err != nil {
+		return err
+	}
+
+	var source HistoryProvider
+	if opts.File != "" {
+		source, err = NewBarFile(opts.File)
+		if err != nil {
+			return err
+		}
+	} else {
+		hp, ok := provider.(HistoryProvider)
+		if !ok {
+			return fmt.Errorf("provider %s does not serve history, use -file", provider.Name())
+		}
+		source = hp
+	}
+
+	if len(opts.Symbols) == 0 {
+		opts.Symbols, err = allSymbols(ctx, db.Collection("stocks"))
+		if err != nil {
+			return err
+		}
+	}
+
+	history := NewHistoryStore(db)
+	if err := history.EnsureSchema(ctx); err != nil {
+		return err
+	}
+
+	// A file is read without the provider's rate limit
+	var limiter *TokenBucket
+	if opts.File == "" {
+		limiter = NewTokenBucket(envFloat("PRICE_RATE_LIMIT", 0.5), envInt("PRICE_RATE_BURST", 1))
+	}
+	retry := loadRetryPolicy()
+	breaker := NewCircuitBreaker(provider.Name())
+
+	fmt.Printf("Backfilling %d symbols (%s bars, %s to %s)...\n",
+		len(opts.Symbols), opts.Interval, opts.From.Format("2006-01-02"), opts.To.Format("2006-01-02"))
+
+	total := 0
+	for _, symbol := range opts.Symbols {
+		var bars []Bar
+		err := retry.Do(ctx, limiter, breaker, func() error {
+			var err error
+			bars, err = source.FetchBars(ctx, symbol, opts.Interval, opts.From, opts.To)
+			return err
+		})
+		if errors.Is(err, ErrCircuitOpen) {
+			return fmt.Errorf("provider %s is unavailable, stopping before %s", provider.Name(), symbol)
+		}
+		if err != nil {
+			log.Printf("Failed to fetch history for %s: %v", symbol, err)
+			continue
+		}
+
+		written, err := history.UpsertBars(ctx, bars)
+		if err != nil {
+			log.Printf("Failed to write history for %s: %v", symbol, err)
+			continue
+		}
+
+		total += written
+		fmt.Printf("✓ %s: %d bars\n", symbol, len(bars))
+	}
+
+	fmt.Printf("Backfill complete: %d bars written\n", total)
+	return nil
+}
+
+func allSymbols(ctx context.Context, stocks *mongo.Collection) ([]string, error) {
+	values, err := stocks.Distinct(ctx, "symbol", activeStocks)
+	if err != nil {
+		return nil, err
+	}
+
+	symbols := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			symbols = append(symbols, s)
+		}
+	}
+	return symbols, nil
+}
+
+// barJSON is one row of a bar replay file. CSV files use the same names as
+// header columns; time is RFC3339 or YYYY-MM-DD.
+type barJSON struct {
+	Symbol string  `json:"symbol"`
+	Time   string  `json:"time"`
+	Open   float64 `json:"open"`
+	High   float64 `json:"high"`
+	Low    float64 `json:"low"`
+	Close  float64 `json:"close"`
+	Volume float64 `json:"volume"`
+}
+
+// BarFile serves historical bars from a local file
+type BarFile struct {
+	rows map[string][]barJSON
+}
+
+func NewBarFile(path string) (*BarFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows []barJSON
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := json.NewDecoder(f).Decode(&rows); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	case ".csv":
+		rows, err = parseBarCSV(f)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported bar file %s (want .csv or .json)", path)
+	}
+
+	b := &BarFile{rows: make(map[string][]barJSON)}
+	for _, r := range rows {
+		symbol := strings.ToUpper(r.Symbol)
+		b.rows[symbol] = append(b.rows[symbol], r)
+	}
+	return b, nil
+}
+
+func (b *BarFile) FetchBars(ctx context.Context, symbol, interval string, from, to time.Time) ([]Bar, error) {
+	size, ok := intervalSize(interval)
+	if !ok {
+		return nil, fmt.Errorf("unsupported interval %q", interval)
+	}
+
+	var bars []Bar
+	for _, r := range b.rows[strings.ToUpper(symbol)] {
+		t, err := parseBarTime(r.Time)
+		if err != nil {
+			return nil, err
+		}
+		if t.Before(from) || t.After(to) {
+			continue
+		}
+
+		// Close-only files still produce valid candles
+		if r.Open == 0 {
+			r.Open = r.Close
+		}
+		if r.High == 0 {
+			r.High = math.Max(r.Open, r.Close)
+		}
+		if r.Low == 0 {
+			r.Low = math.Min(r.Open, r.Close)
+		}
+
+		bars = append(bars, Bar{
+			Symbol:   strings.ToUpper(symbol),
+			Interval: interval,
+			Start:    t.UTC().Truncate(size),
+			Open:     r.Open,
+			High:     r.High,
+			Low:      r.Low,
+			Close:    r.Close,
+			Volume:   r.Volume,
+		})
+	}
+	return bars, nil
+}
+
+func parseBarTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func parseBarCSV(r io.Reader) ([]barJSON, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"symbol", "time", "close"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %s column", required)
+		}
+	}
+
+	var bars []barJSON
+	for i, row := range rows[1:] {
+		field := func(name string) string {
+			if idx, ok := columns[name]; ok && idx < len(row) {
+				return strings.TrimSpace(row[idx])
+			}
+			return ""
+		}
+
+		bar := barJSON{Symbol: field("symbol"), Time: field("time")}
+		numbers := map[string]*float64{
+			"open":   &bar.Open,
+			"high":   &bar.High,
+			"low":    &bar.Low,
+			"close":  &bar.Close,
+			"volume": &bar.Volume,
+		}
+		for name, dst := range numbers {
+			v := field(name)
+			if v == "" {
+				continue
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %w", i+2, name, err)
+			}
+			*dst = f
+		}
+		bars = append(bars, bar)
+	}
+	return bars, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"5d", 5 * day, false},
+		{"3mo", 90 * day, false},
+		{"1y", 365 * day, false},
+		{"0d", 0, true},
+		{"-2d", 0, true},
+		{"d", 0, true},
+		{"5w", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRange(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseRange(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseBackfillArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    BackfillOptions
+		wantErr string
+	}{
+		{
+			name: "symbols and dates",
+			args: []string{"-symbols", "aapl, msft ,,tsla", "-from", "2024-01-01", "-to", "2024-06-30", "-interval", "1h"},
+			want: BackfillOptions{
+				Symbols:  []string{"AAPL", "MSFT", "TSLA"},
+				Interval: "1h",
+				From:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				To:       time.Date(2024, 6, 30, 23, 59, 59, 0, time.UTC),
+			},
+		},
+		{
+			name: "range back from -to",
+			args: []string{"-symbols", "all", "-range", "5d", "-to", "2024-06-30", "-file", "bars.csv"},
+			want: BackfillOptions{
+				Interval: "1d",
+				From:     time.Date(2024, 6, 25, 23, 59, 59, 0, time.UTC),
+				To:       time.Date(2024, 6, 30, 23, 59, 59, 0, time.UTC),
+				File:     "bars.csv",
+			},
+		},
+		{name: "missing symbols", args: []string{"-from", "2024-01-01"}, wantErr: "-symbols is required"},
+		{name: "missing start", args: []string{"-symbols", "AAPL"}, wantErr: "either -from or -range"},
+		{name: "bad interval", args: []string{"-symbols", "AAPL", "-from", "2024-01-01", "-interval", "5m"}, wantErr: "unsupported interval"},
+		{name: "bad date", args: []string{"-symbols", "AAPL", "-from", "2024-13-01"}, wantErr: "-from"},
+		{name: "bad range", args: []string{"-symbols", "AAPL", "-range", "soon"}, wantErr: "invalid -range"},
+		{name: "from after to", args: []string{"-symbols", "AAPL", "-from", "2024-07-01", "-to", "2024-06-30"}, wantErr: "-from must be before -to"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBackfillArgs(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBarCSV(t *testing.T) {
+	rows, err := parseBarCSV(strings.NewReader("symbol,time,close,volume\nAAPL,2024-01-02,185.5,1000\nAAPL,2024-01-03T14:30:00Z,184,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []barJSON{
+		{Symbol: "AAPL", Time: "2024-01-02", Close: 185.5, Volume: 1000},
+		{Symbol: "AAPL", Time: "2024-01-03T14:30:00Z", Close: 184},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %+v, want %+v", rows, want)
+	}
+
+	if _, err := parseBarCSV(strings.NewReader("symbol,close\nAAPL,1\n")); err == nil || !strings.Contains(err.Error(), "missing time column") {
+		t.Errorf("missing column: err %v", err)
+	}
+}
@@ -0,0 +1,735 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const actionsCollection = "corporateactions"
+
+// Corporate action types
+const (
+	ActionSplit    = "SPLIT"
+	ActionDividend = "DIVIDEND"
+)
+
+// CorporateAction is a split or cash dividend for one symbol.
+// For splits Ratio is new shares per old share (4-for-1 = 4, 1-for-10 = 0.1).
+// For dividends Amount is the cash paid per share.
+type CorporateAction struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	Symbol    string             `bson:"symbol" json:"symbol"`
+	Type      string             `bson:"type" json:"type"`
+	ExDate    time.Time          `bson:"exDate" json:"exDate"`
+	Ratio     float64            `bson:"ratio,omitempty" json:"ratio,omitempty"`
+	Amount    float64            `bson:"amount,omitempty" json:"amount,omitempty"`
+	Currency  string             `bson:"currency,omitempty" json:"currency,omitempty"` // of Amount; the stock's quote currency if not given
+	Source    string             `bson:"source" json:"-"`
+	Status    string             `bson:"status" json:"-"` // PENDING, APPLIED or SKIPPED
+	Result    *ActionResult      `bson:"result,omitempty" json:"-"`
+	CreatedAt time.Time          `bson:"createdAt" json:"-"`
+	AppliedAt *time.Time         `bson:"appliedAt,omitempty" json:"-"`
+}
+
+// Action statuses. SKIPPED actions took effect before the symbol's actions
+// were tracked, so the stored prices already reflect them.
+const (
+	actionPending = "PENDING"
+	actionApplied = "APPLIED"
+	actionSkipped = "SKIPPED"
+)
+
+// appliedField on a stock holds, per action type, the ex-date up to which
+// actions have been applied. Only later actions are applied.
+const appliedField = "actionsAppliedThrough"
+
+// priceAlertConditions are the alert conditions whose targetPrice is a price
+var priceAlertConditions = []string{"ABOVE", "BELOW"}
+
+// Indicator alerts compare an indicator with a level, which is a price for
+// moving averages (RSI is unitless)
+var (
+	levelAlertConditions = []string{"INDICATOR_ABOVE", "INDICATOR_BELOW"}
+	priceIndicators      = bson.M{"$regex": "^(SMA|EMA)[0-9]+$", "$options": "i"}
+)
+
+// numberLiteral finds a number in a rule, but not in a name such as RSI14.
+// Which of a rule's numbers are prices can't be told without parsing it, so
+// rules with any are paused by a split rather than restated.
+var numberLiteral = regexp.MustCompile(`(^|[^\pL\pN_.])\.?\pN`)
+
+// ActionResult records what applying an action changed
+type ActionResult struct {
+	Holdings    int64 `bson:"holdings"`
+	Alerts      int64 `bson:"alerts"`
+	Predictions int64 `bson:"predictions"`
+	Users       int   `bson:"users"`
+}
+
+// Notification mirrors the server's Notification model
+type Notification struct {
+	Recipient primitive.ObjectID `bson:"recipient"`
+	Type      string             `bson:"type"`
+	Content   string             `bson:"content"`
+	Link      string             `bson:"link"`
+	IsRead    bool               `bson:"isRead"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt"`
+}
+
+func (a CorporateAction) validate() error {
+	a.Symbol = strings.TrimSpace(a.Symbol)
+	switch {
+	case a.Symbol == "":
+		return fmt.Errorf("missing symbol")
+	case a.ExDate.IsZero():
+		return fmt.Errorf("%s: missing exDate", a.Symbol)
+	case a.Type == ActionSplit && a.Ratio <= 0:
+		return fmt.Errorf("%s: split ratio must be positive", a.Symbol)
+	case a.Type == ActionDividend && a.Amount <= 0:
+		return fmt.Errorf("%s: dividend amount must be positive", a.Symbol)
+	case a.Type != ActionSplit && a.Type != ActionDividend:
+		return fmt.Errorf("%s: unknown action type %q", a.Symbol, a.Type)
+	}
+	return nil
+}
+
+func (a CorporateAction) describe() string {
+	if a.Type == ActionSplit {
+		if a.Ratio >= 1 {
+			return fmt.Sprintf("%s completed a %g-for-1 stock split", a.Symbol, a.Ratio)
+		}
+		return fmt.Sprintf("%s completed a 1-for-%g reverse split", a.Symbol, 1/a.Ratio)
+	}
+	if a.Currency == "" || a.Currency == "USD" {
+		return fmt.Sprintf("%s paid a dividend of $%.4g per share", a.Symbol, a.Amount)
+	}
+	return fmt.Sprintf("%s paid a dividend of %.4g %s per share", a.Symbol, a.Amount, a.Currency)
+}
+
+// errNoFXRate leaves a dividend pending until its currency can be converted
+var errNoFXRate = errors.New("no USD exchange rate yet")
+
+// dividendRate returns the USD value of one unit of a dividend's currency.
+// Only the stock's own quote currency has a known rate (its fxRate).
+func dividendRate(currency, stockCurrency string, fxRate float64) (float64, bool) {
+	switch {
+	case currency == "" || currency == "USD":
+		return 1, true
+	case currency == stockCurrency && fxRate > 0:
+		return fxRate, true
+	}
+	return 0, false
+}
+
+// CorporateActionProvider is implemented by providers that report splits
+// and dividends
+type CorporateActionProvider interface {
+	FetchActions(ctx context.Context, symbol string, from, to time.Time) ([]CorporateAction, error)
+}
+
+// CorporateActions records actions and applies them once their ex-date
+// has passed
+type CorporateActions struct {
+	client *mongo.Client
+	db     *mongo.Database
+	coll   *mongo.Collection
+}
+
+func NewCorporateActions(client *mongo.Client, db *mongo.Database) *CorporateActions {
+	return &CorporateActions{client: client, db: db, coll: db.Collection(actionsCollection)}
+}
+
+func (c *CorporateActions) EnsureSchema(ctx context.Context) error {
+	_, err := c.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "symbol", Value: 1}, {Key: "type", Value: 1}, {Key: "exDate", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "exDate", Value: 1}}},
+	})
+	return err
+}
+
+// Record stores actions as PENDING. Actions already known (same symbol,
+// type and ex-date) are left untouched, so ingestion can be repeated.
+func (c *CorporateActions) Record(ctx context.Context, actions []CorporateAction, source string) (int, error) {
+	now := time.Now()
+	added := 0
+	tracked := make(map[string]bool)
+	currencies := make(map[string]string)
+	for _, a := range actions {
+		if err := a.validate(); err != nil {
+			log.Printf("Skipping corporate action: %v", err)
+			continue
+		}
+
+		a.Symbol = strings.ToUpper(strings.TrimSpace(a.Symbol))
+		a.ExDate = a.ExDate.UTC().Truncate(24 * time.Hour)
+		if !tracked[a.Symbol] {
+			if err := c.track(ctx, a.Symbol, now); err != nil {
+				return added, err
+			}
+			tracked[a.Symbol] = true
+
+			var stock struct {
+				Currency string `bson:"currency"`
+			}
+			err := c.db.Collection("stocks").FindOne(ctx, bson.M{"symbol": a.Symbol}).Decode(&stock)
+			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				return added, err
+			}
+			currencies[a.Symbol] = stock.Currency
+		}
+		if a.Type == ActionDividend && a.Currency == "" {
+			a.Currency = currencies[a.Symbol]
+		}
+		result, err := c.coll.UpdateOne(ctx,
+			bson.M{"symbol": a.Symbol, "type": a.Type, "exDate": a.ExDate},
+			bson.M{"$setOnInsert": bson.M{
+				"ratio":     a.Ratio,
+				"amount":    a.Amount,
+				"currency":  a.Currency,
+				"source":    source,
+				"status":    actionPending,
+				"createdAt": now,
+			}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return added, err
+		}
+		if result.UpsertedCount > 0 {
+			added++
+			fmt.Printf("+ Recorded %s\n", a.describe())
+		}
+	}
+	return added, nil
+}
+
+// track starts tracking a symbol's actions if it isn't already. The stored
+// prices come from quotes taken up to the stock's last update, which already
+// reflect any earlier split, so that is where applying starts.
+func (c *CorporateActions) track(ctx context.Context, symbol string, now time.Time) error {
+	since := bson.M{"$ifNull": bson.A{"$updatedAt", now}}
+	_, err := c.db.Collection("stocks").UpdateOne(ctx,
+		bson.M{"symbol": symbol, appliedField: bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{appliedField: bson.M{
+			ActionSplit:    since,
+			ActionDividend: since,
+		}}}}},
+	)
+	return err
+}
+
+// ApplyDue applies every pending action whose ex-date has passed, oldest
+// first. Each action is applied in its own transaction.
+func (c *CorporateActions) ApplyDue(ctx context.Context) error {
+	cursor, err := c.coll.Find(ctx,
+		bson.M{"status": actionPending, "exDate": bson.M{"$lte": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "exDate", Value: 1}}),
+	)
+	if err != nil {
+		return err
+	}
+
+	var due []CorporateAction
+	if err := cursor.All(ctx, &due); err != nil {
+		return err
+	}
+
+	for _, a := range due {
+		status, err := c.apply(ctx, a)
+		switch {
+		case err != nil:
+			log.Printf("Failed to apply %s: %v", a.describe(), err)
+		case status == actionApplied:
+			fmt.Printf("✓ Applied %s\n", a.describe())
+		case status == actionSkipped:
+			fmt.Printf("- Skipped %s (already reflected in the stored prices)\n", a.describe())
+		}
+	}
+	return nil
+}
+
+// errAlreadyApplied aborts a transaction that lost the race to apply an action
+var errAlreadyApplied = errors.New("corporate action already applied")
+
+// apply adjusts holdings, active alerts and pending predictions for one
+// action and notifies the affected users, all in a single transaction, and
+// returns the status the action ends up in. Marking the action APPLIED and
+// moving the stock's applied-through date are part of the same transaction,
+// guarded on status PENDING and on the ex-date being later, so an action
+// can never be applied twice and older ones are skipped.
+func (c *CorporateActions) apply(ctx context.Context, a CorporateAction) (string, error) {
+	session, err := c.client.StartSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.EndSession(context.Background())
+
+	status, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		now := time.Now()
+		stocks := c.db.Collection("stocks")
+
+		var stock struct {
+			ID        primitive.ObjectID   `bson:"_id"`
+			UpdatedAt time.Time            `bson:"updatedAt"`
+			Applied   map[string]time.Time `bson:"actionsAppliedThrough"`
+			Currency  string               `bson:"currency"`
+			FXRate    float64              `bson:"fxRate"`
+		}
+		if err := stocks.FindOne(sc, bson.M{"symbol": a.Symbol}).Decode(&stock); err != nil {
+			return nil, fmt.Errorf("stock %s: %w", a.Symbol, err)
+		}
+		through, ok := stock.Applied[a.Type]
+		if !ok {
+			through = stock.UpdatedAt // recorded before tracking existed
+		}
+
+		status := actionApplied
+		set := bson.M{"status": actionApplied, "appliedAt": now}
+		if !a.ExDate.After(through) {
+			status = actionSkipped
+			set = bson.M{"status": actionSkipped}
+		}
+
+		claimed, err := c.coll.UpdateOne(sc,
+			bson.M{"_id": a.ID, "status": actionPending},
+			bson.M{"$set": set},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if claimed.ModifiedCount == 0 {
+			return nil, errAlreadyApplied
+		}
+		if status == actionSkipped {
+			return status, nil
+		}
+
+		if _, err := stocks.UpdateOne(sc,
+			bson.M{"_id": stock.ID},
+			bson.M{"$max": bson.M{appliedField + "." + a.Type: a.ExDate}},
+		); err != nil {
+			return nil, err
+		}
+
+		var result ActionResult
+		var users map[primitive.ObjectID]bool
+		switch a.Type {
+		case ActionSplit:
+			users, err = c.applySplit(sc, a, stock.ID, &result)
+		case ActionDividend:
+			if a.Currency == "" {
+				a.Currency = stock.Currency
+			}
+			rate, ok := dividendRate(a.Currency, stock.Currency, stock.FXRate)
+			if !ok {
+				return nil, fmt.Errorf("%s dividend in %s: %w", a.Symbol, a.Currency, errNoFXRate)
+			}
+			users, err = c.applyDividend(sc, a, rate, stock.ID, &result)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := c.notify(sc, a, users, now); err != nil {
+			return nil, err
+		}
+		result.Users = len(users)
+
+		_, err = c.coll.UpdateOne(sc, bson.M{"_id": a.ID}, bson.M{"$set": bson.M{"result": result}})
+		return status, err
+	})
+
+	if errors.Is(err, errAlreadyApplied) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return status.(string), nil
+}
+
+func (c *CorporateActions) applySplit(sc mongo.SessionContext, a CorporateAction, stockID primitive.ObjectID, result *ActionResult) (map[primitive.ObjectID]bool, error) {
+	users := make(map[primitive.ObjectID]bool)
+	inverse := 1 / a.Ratio
+
+	// Collect affected users before the updates
+	if err := collectUsers(sc, c.db.Collection("holdings"), bson.M{"stockId": stockID, "quantity": bson.M{"$gt": 0}}, "userId", users); err != nil {
+		return nil, err
+	}
+	priceAlerts := bson.M{"symbol": a.Symbol, "isActive": true, "condition": bson.M{"$in": priceAlertConditions}}
+	if err := collectUsers(sc, c.db.Collection("alerts"), priceAlerts, "user", users); err != nil {
+		return nil, err
+	}
+	levelAlerts := bson.M{"symbol": a.Symbol, "isActive": true, "condition": bson.M{"$in": levelAlertConditions}, "indicator": priceIndicators}
+	if err := collectUsers(sc, c.db.Collection("alerts"), levelAlerts, "user", users); err != nil {
+		return nil, err
+	}
+	if err := collectUsers(sc, c.db.Collection("predictions"), bson.M{"stockId": stockID, "isEvaluated": false}, "userId", users); err != nil {
+		return nil, err
+	}
+
+	// Holdings: more shares at a proportionally lower cost basis
+	res, err := c.db.Collection("holdings").UpdateMany(sc,
+		bson.M{"stockId": stockID},
+		bson.M{"$mul": bson.M{"quantity": a.Ratio, "averagePrice": inverse}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	result.Holdings = res.ModifiedCount
+
+	// Only price thresholds; percentage, indicator and other alerts don't
+	// compare against a price level
+	res, err = c.db.Collection("alerts").UpdateMany(sc,
+		priceAlerts,
+		bson.M{"$mul": bson.M{"targetPrice": inverse}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	result.Alerts = res.ModifiedCount
+
+	res, err = c.db.Collection("alerts").UpdateMany(sc,
+		levelAlerts,
+		bson.M{"$mul": bson.M{"level": inverse}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	result.Alerts += res.ModifiedCount
+
+	paused, err := c.pauseRules(sc, a)
+	if err != nil {
+		return nil, err
+	}
+	result.Alerts += paused
+
+	// Direction predictions only carry initialPrice; $mul on a missing
+	// targetPrice would create it as 0, so price predictions are done apart
+	predictions := c.db.Collection("predictions")
+	res, err = predictions.UpdateMany(sc,
+		bson.M{"stockId": stockID, "isEvaluated": false, "predictionType": "price"},
+		bson.M{"$mul": bson.M{"initialPrice": inverse, "targetPrice": inverse}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	result.Predictions = res.ModifiedCount
+
+	res, err = predictions.UpdateMany(sc,
+		bson.M{"stockId": stockID, "isEvaluated": false, "predictionType": bson.M{"$ne": "price"}},
+		bson.M{"$mul": bson.M{"initialPrice": inverse}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	result.Predictions += res.ModifiedCount
+
+	// Restate the stored prices so the next split-adjusted quote passes the
+	// sanity checks instead of being quarantined as a large move. lastSplit
+	// tells the alert-engine to restate the prices it holds too, as the
+	// pricehistory ticks (a time-series collection) are left as quoted.
+	_, err = c.db.Collection("stocks").UpdateOne(sc,
+		bson.M{"_id": stockID},
+		bson.M{
+			"$mul": bson.M{
+				"currentPrice":     inverse,
+				"previousClose":    inverse,
+				"change":           inverse,
+				"high24h":          inverse,
+				"low24h":           inverse,
+				"fiftyTwoWeekHigh": inverse,
+				"fiftyTwoWeekLow":  inverse,
+			},
+			"$set": bson.M{"lastSplit": bson.M{
+				"ratio":     a.Ratio,
+				"exDate":    a.ExDate,
+				"appliedAt": time.Now(),
+			}},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	// $mul would create a missing priceUSD as 0
+	_, err = c.db.Collection("stocks").UpdateOne(sc,
+		bson.M{"_id": stockID, "priceUSD": bson.M{"$exists": true}},
+		bson.M{"$mul": bson.M{"priceUSD": inverse}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep candles before the ex-date comparable with new prices
+	_, err = c.db.Collection(barsCollection).UpdateMany(sc,
+		bson.M{"symbol": a.Symbol, "start": bson.M{"$lt": a.ExDate}},
+		bson.M{"$mul": bson.M{
+			"open":   inverse,
+			"high":   inverse,
+			"low":    inverse,
+			"close":  inverse,
+			"volume": a.Ratio,
+		}},
+	)
+	return users, err
+}
+
+// pauseRules deactivates the symbol's active rule alerts that compare with
+// numbers, which may be pre-split prices, and tells each owner why. The
+// owner turns the alert back on once the rule has been checked.
+func (c *CorporateActions) pauseRules(sc mongo.SessionContext, a CorporateAction) (int64, error) {
+	alerts := c.db.Collection("alerts")
+	cursor, err := alerts.Find(sc, bson.M{"symbol": a.Symbol, "isActive": true, "condition": "EXPRESSION"})
+	if err != nil {
+		return 0, err
+	}
+	var rules []struct {
+		ID         primitive.ObjectID `bson:"_id"`
+		User       primitive.ObjectID `bson:"user"`
+		Expression string             `bson:"expression"`
+	}
+	if err := cursor.All(sc, &rules); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	reason := a.describe() + "; the numbers in this rule may be pre-split prices"
+	var paused int64
+	for _, rule := range rules {
+		if !numberLiteral.MatchString(rule.Expression) {
+			continue
+		}
+		if _, err := alerts.UpdateOne(sc,
+			bson.M{"_id": rule.ID},
+			bson.M{"$set": bson.M{"isActive": false, "error": reason}},
+		); err != nil {
+			return paused, err
+		}
+		if _, err := c.db.Collection("notifications").InsertOne(sc, Notification{
+			Recipient: rule.User,
+			Type:      "SYSTEM",
+			Content:   fmt.Sprintf("%s. Your alert \"%s\" was paused, as its numbers may be pre-split prices; check it and turn it back on.", a.describe(), rule.Expression),
+			Link:      "/stock/" + a.Symbol,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}); err != nil {
+			return paused, err
+		}
+		paused++
+	}
+	return paused, nil
+}
+
+// applyDividend credits each holder's cash balance, which is in USD, and
+// records a dividend transaction. rate converts the dividend's currency.
+func (c *CorporateActions) applyDividend(sc mongo.SessionContext, a CorporateAction, rate float64, stockID primitive.ObjectID, result *ActionResult) (map[primitive.ObjectID]bool, error) {
+	cursor, err := c.db.Collection("holdings").Find(sc, bson.M{"stockId": stockID, "quantity": bson.M{"$gt": 0}})
+	if err != nil {
+		return nil, err
+	}
+
+	var holdings []struct {
+		UserID   primitive.ObjectID `bson:"userId"`
+		Quantity float64            `bson:"quantity"`
+	}
+	if err := cursor.All(sc, &holdings); err != nil {
+		return nil, err
+	}
+
+	users := make(map[primitive.ObjectID]bool)
+	now := time.Now()
+	for _, h := range holdings {
+		payout := dividendPayout(h.Quantity, a.Amount, rate)
+
+		if _, err := c.db.Collection("users").UpdateOne(sc,
+			bson.M{"_id": h.UserID},
+			bson.M{"$inc": bson.M{"balance": payout}},
+		); err != nil {
+			return nil, err
+		}
+
+		if _, err := c.db.Collection("transactions").InsertOne(sc, bson.M{
+			"userId":      h.UserID,
+			"stockId":     stockID,
+			"type":        "dividend",
+			"quantity":    h.Quantity,
+			"price":       a.Amount * rate,
+			"totalAmount": payout,
+			"status":      "completed",
+			"createdAt":   now,
+			"updatedAt":   now,
+		}); err != nil {
+			return nil, err
+		}
+
+		users[h.UserID] = true
+		result.Holdings++
+	}
+	return users, nil
+}
+
+// dividendPayout is the USD credited for a holding
+func dividendPayout(quantity, amount, rate float64) float64 {
+	return quantity * amount * rate
+}
+
+func (c *CorporateActions) notify(sc mongo.SessionContext, a CorporateAction, users map[primitive.ObjectID]bool, now time.Time) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	content := a.describe() + "."
+	if a.Type == ActionSplit {
+		content += " Your holdings, alerts and open predictions have been adjusted."
+	} else {
+		content += " The payout has been credited to your balance."
+	}
+
+	docs := make([]interface{}, 0, len(users))
+	for user := range users {
+		docs = append(docs, Notification{
+			Recipient: user,
+			Type:      "SYSTEM",
+			Content:   content,
+			Link:      "/stock/" + a.Symbol,
+			IsRead:    false,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	}
+
+	_, err := c.db.Collection("notifications").InsertMany(sc, docs)
+	return err
+}
+
+func collectUsers(ctx context.Context, coll *mongo.Collection, filter bson.M, field string, users map[primitive.ObjectID]bool) error {
+	values, err := coll.Distinct(ctx, field, filter)
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		if id, ok := v.(primitive.ObjectID); ok {
+			users[id] = true
+		}
+	}
+	return nil
+}
+
+// runActions implements the corporate action commands:
+//
+//	actions import -file actions.json
+//	actions sync -symbols AAPL,MSFT|all [-range 1y]
+//	actions apply
+//	actions list
+func runActions(ctx context.Context, client *mongo.Client, db *mongo.Database, provider PriceProvider, args []string) error {
+	actions := NewCorporateActions(client, db)
+	if err := actions.EnsureSchema(ctx); err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("usage: actions [import | sync | apply | list]")
+	}
+
+	fs := flag.NewFlagSet("actions "+args[0], flag.ContinueOnError)
+	file := fs.String("file", "", "JSON file of corporate actions")
+	symbols := fs.String("symbols", "all", "comma separated symbols, or \"all\"")
+	lookback := fs.String("range", "1y", "how far back to look for provider events")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "import":
+		if *file == "" {
+			return fmt.Errorf("-file is required")
+		}
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		var list []CorporateAction
+		if err := json.Unmarshal(data, &list); err != nil {
+			return fmt.Errorf("parse %s: %w", *file, err)
+		}
+		added, err := actions.Record(ctx, list, "file")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d new corporate actions\n", added)
+		return actions.ApplyDue(ctx)
+
+	case "sync":
+		source, ok := provider.(CorporateActionProvider)
+		if !ok {
+			return fmt.Errorf("provider %s does not report corporate actions, use import", provider.Name())
+		}
+
+		d, err := parseRange(*lookback)
+		if err != nil {
+			return err
+		}
+
+		list := strings.Split(*symbols, ",")
+		if strings.EqualFold(*symbols, "all") {
+			if list, err = allSymbols(ctx, db.Collection("stocks")); err != nil {
+				return err
+			}
+		}
+
+		limiter := NewTokenBucket(envFloat("PRICE_RATE_LIMIT", 0.5), envInt("PRICE_RATE_BURST", 1))
+		now := time.Now()
+		for _, symbol := range list {
+			symbol = strings.ToUpper(strings.TrimSpace(symbol))
+			if err := limiter.Wait(ctx); err != nil {
+				return err
+			}
+			found, err := source.FetchActions(ctx, symbol, now.Add(-d), now)
+			if err != nil {
+				log.Printf("Failed to fetch corporate actions for %s: %v", symbol, err)
+				continue
+			}
+			if _, err := actions.Record(ctx, found, provider.Name()); err != nil {
+				return err
+			}
+		}
+		return actions.ApplyDue(ctx)
+
+	case "apply":
+		return actions.ApplyDue(ctx)
+
+	case "list":
+		cursor, err := actions.coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "exDate", Value: -1}}).SetLimit(50))
+		if err != nil {
+			return err
+		}
+		var list []CorporateAction
+		if err := cursor.All(ctx, &list); err != nil {
+			return err
+		}
+		for _, a := range list {
+			fmt.Printf("%s  %-8s %s  %s\n", a.ExDate.Format("2006-01-02"), a.Status, a.ID.Hex(), a.describe())
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown actions command %q", args[0])
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCorporateActionValidate(t *testing.T) {
+	exDate := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		action CorporateAction
+		ok     bool
+	}{
+		{CorporateAction{Symbol: "NVDA", Type: ActionSplit, ExDate: exDate, Ratio: 10}, true},
+		{CorporateAction{Symbol: "GE", Type: ActionSplit, ExDate: exDate, Ratio: 0.125}, true},
+		{CorporateAction{Symbol: "AAPL", Type: ActionDividend, ExDate: exDate, Amount: 0.25}, true},
+		{CorporateAction{Symbol: " ", Type: ActionSplit, ExDate: exDate, Ratio: 2}, false},
+		{CorporateAction{Symbol: "AAPL", Type: ActionSplit, Ratio: 2}, false},
+		{CorporateAction{Symbol: "AAPL", Type: ActionSplit, ExDate: exDate}, false},
+		{CorporateAction{Symbol: "AAPL", Type: ActionDividend, ExDate: exDate, Amount: -1}, false},
+		{CorporateAction{Symbol: "AAPL", Type: "MERGER", ExDate: exDate, Ratio: 1}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.action.validate(); (err == nil) != tt.ok {
+			t.Errorf("validate(%+v) = %v, want ok=%v", tt.action, err, tt.ok)
+		}
+	}
+}
+
+func TestCorporateActionDescribe(t *testing.T) {
+	tests := []struct {
+		action CorporateAction
+		want   string
+	}{
+		{CorporateAction{Symbol: "NVDA", Type: ActionSplit, Ratio: 10}, "NVDA completed a 10-for-1 stock split"},
+		{CorporateAction{Symbol: "GE", Type: ActionSplit, Ratio: 0.125}, "GE completed a 1-for-8 reverse split"},
+		{CorporateAction{Symbol: "AAPL", Type: ActionDividend, Amount: 0.25}, "AAPL paid a dividend of $0.25 per share"},
+		{CorporateAction{Symbol: "AAPL", Type: ActionDividend, Amount: 0.25, Currency: "USD"}, "AAPL paid a dividend of $0.25 per share"},
+		{CorporateAction{Symbol: "BP.L", Type: ActionDividend, Amount: 7.27, Currency: "GBp"}, "BP.L paid a dividend of 7.27 GBp per share"},
+		{CorporateAction{Symbol: "7203.T", Type: ActionDividend, Amount: 35, Currency: "JPY"}, "7203.T paid a dividend of 35 JPY per share"},
+	}
+	for _, tt := range tests {
+		if got := tt.action.describe(); got != tt.want {
+			t.Errorf("describe() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestDividendPayoutInUSD(t *testing.T) {
+	tests := []struct {
+		name                    string
+		currency, stockCurrency string
+		fxRate                  float64
+		quantity, amount        float64
+		want                    float64
+		ok                      bool
+	}{
+		{"USD", "USD", "USD", 0, 100, 0.25, 25, true},
+		{"no currency is USD", "", "", 0, 100, 0.25, 25, true},
+		// 7.27 pence at $0.0127 per penny
+		{"pence", "GBp", "GBp", 0.0127, 1000, 7.27, 92.329, true},
+		{"yen", "JPY", "JPY", 0.0067, 100, 35, 23.45, true},
+		{"no rate yet", "JPY", "JPY", 0, 100, 35, 0, false},
+		{"another currency than the quote", "EUR", "GBp", 0.0127, 100, 1, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, ok := dividendRate(tt.currency, tt.stockCurrency, tt.fxRate)
+			if ok != tt.ok {
+				t.Fatalf("rate ok=%v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if got := dividendPayout(tt.quantity, tt.amount, rate); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("payout %g, want %g", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNumberLiteral(t *testing.T) {
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		{"AAPL > 200", true},
+		{"price<150.5", true},
+		{"volume > 2x avgVolume", true},
+		{"changePercent > .5", true},
+		{"RSI14 < 30", true},
+		{"SMA50 > SMA200", false},
+		{"sentimentLabel == Bullish AND price > SMA20", false},
+		{"NOT (RSI14 > EMA9)", false},
+	}
+	for _, tt := range tests {
+		if got := numberLiteral.MatchString(tt.rule); got != tt.want {
+			t.Errorf("numberLiteral(%q) = %v, want %v", tt.rule, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrorKind classifies provider failures so callers can decide whether a
+// retry makes sense
+type ErrorKind int
+
+const (
+	ErrTransient    ErrorKind = iota // network failure or 5xx, worth retrying
+	ErrRateLimited                   // provider is throttling us, back off
+	ErrNotFound                      // unknown symbol or no data, don't retry
+	ErrParse                         // unexpected response body, don't retry
+	ErrUnauthorized                  // provider refused our credentials, don't retry
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrTransient:
+		return "transient"
+	case ErrRateLimited:
+		return "rate-limited"
+	case ErrNotFound:
+		return "not-found"
+	case ErrParse:
+		return "parse"
+	case ErrUnauthorized:
+		return "unauthorized"
+	default:
+		return "unknown"
+	}
+}
+
+// ProviderError is returned by providers for every failed request
+type ProviderError struct {
+	Kind       ErrorKind
+	StatusCode int
+	RetryAfter time.Duration // from the Retry-After header, if any
+	Err        error
+}
+
+func (e *ProviderError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s: status code %d", e.Kind, e.StatusCode)
+	}
+	return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+}
+
+func (e *ProviderError) Unwrap() error { return e.Err }
+
+// Retryable reports whether the request may succeed if tried again
+func (e *ProviderError) Retryable() bool {
+	return e.Kind == ErrTransient || e.Kind == ErrRateLimited
+}
+
+// providerFailure reports whether errors of the kind say the provider, not
+// the request, is at fault, which counts towards opening the breaker
+func (k ErrorKind) providerFailure() bool {
+	return k == ErrTransient || k == ErrRateLimited || k == ErrUnauthorized
+}
+
+func errNoData() error {
+	return &ProviderError{Kind: ErrNotFound, Err: errors.New("no data found")}
+}
+
+func errParse(err error) error {
+	return &ProviderError{Kind: ErrParse, Err: err}
+}
+
+// errNetwork wraps a transport error. Cancellation is passed through as is
+// so shutdown isn't mistaken for a provider outage.
+func errNetwork(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	return &ProviderError{Kind: ErrTransient, Err: err}
+}
+
+// errStatus classifies a non-200 HTTP response
+func errStatus(resp *http.Response) error {
+	e := &ProviderError{StatusCode: resp.StatusCode}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		e.Kind = ErrRateLimited
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		}
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		// Every request will fail the same way until the key or access is
+		// fixed, so let the breaker stop hammering the provider
+		e.Kind = ErrUnauthorized
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusRequestTimeout:
+		e.Kind = ErrTransient
+	default:
+		e.Kind = ErrNotFound
+	}
+	return e
+}
+
+// errorKind extracts the classification of err, treating unknown errors as
+// transient
+func errorKind(err error) ErrorKind {
+	var pe *ProviderError
+	if errors.As(err, &pe) {
+		return pe.Kind
+	}
+	return ErrTransient
+}
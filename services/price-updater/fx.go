@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minorUnits lists the sub-unit currency codes some exchanges quote in
+// (Yahoo reports LSE prices in pence as "GBp")
+var minorUnits = map[string]struct {
+	major   string
+	divisor float64
+}{
+	"GBp": {"GBP", 100},
+	"GBX": {"GBP", 100},
+	"ZAc": {"ZAR", 100},
+	"ILA": {"ILS", 100},
+}
+
+// exchangeCurrency is the quote currency assumed when the provider doesn't
+// report one, keyed by calendar exchange code
+var exchangeCurrency = map[string]string{
+	"US":     "USD",
+	"NSE":    "INR",
+	"LSE":    "GBp",
+	"CRYPTO": "USD",
+}
+
+// majorCurrency splits a quote currency into its ISO major currency and the
+// number of quote units per major unit
+func majorCurrency(code string) (string, float64) {
+	if m, ok := minorUnits[code]; ok {
+		return m.major, m.divisor
+	}
+	return strings.ToUpper(code), 1
+}
+
+// quoteCurrency decides the currency of a quote: as reported, else as stored
+// on the stock, else the default of the symbol's exchange
+func quoteCurrency(s Stock, q Quote) string {
+	switch {
+	case q.Currency != "":
+		return q.Currency
+	case s.Currency != "":
+		return s.Currency
+	default:
+		return exchangeCurrency[exchangeCode(s.Exchange, s.Symbol)]
+	}
+}
+
+// FXProvider is implemented by providers with a dedicated FX endpoint.
+// Rates are USD per unit of each ISO currency.
+type FXProvider interface {
+	FetchFXRates(ctx context.Context, currencies []string) (map[string]float64, error)
+}
+
+// FXRates caches USD conversion rates. Rates come from the provider's
+// FXProvider implementation or, failing that, by quoting Yahoo-style pair
+// symbols such as INRUSD=X. A stale rate is preferred over no rate.
+// Requests share the quote requests' rate limiter, retry policy and
+// circuit breaker.
+type FXRates struct {
+	provider PriceProvider
+	limiter  *TokenBucket
+	retry    RetryPolicy
+	breaker  *CircuitBreaker
+	ttl      time.Duration
+
+	mu        sync.Mutex
+	rates     map[string]float64
+	fetchedAt map[string]time.Time
+	fixed     map[string]float64 // PRICE_FX_RATES overrides, never refreshed
+}
+
+// NewFXRates reads PRICE_FX_TTL (default 15m) and PRICE_FX_RATES, a list of
+// fixed rates like "INR=0.012,GBP=1.27" for offline providers
+func NewFXRates(provider PriceProvider, limiter *TokenBucket, retry RetryPolicy, breaker *CircuitBreaker) *FXRates {
+	fx := &FXRates{
+		provider:  provider,
+		limiter:   limiter,
+		retry:     retry,
+		breaker:   breaker,
+		ttl:       envDuration("PRICE_FX_TTL", 15*time.Minute),
+		rates:     map[string]float64{"USD": 1},
+		fetchedAt: make(map[string]time.Time),
+		fixed:     make(map[string]float64),
+	}
+
+	for _, pair := range strings.Split(os.Getenv("PRICE_FX_RATES"), ",") {
+		code, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 {
+			log.Printf("Invalid PRICE_FX_RATES entry %q", pair)
+			continue
+		}
+		fx.fixed[strings.ToUpper(code)] = rate
+	}
+	return fx
+}
+
+// Rate returns USD per quote unit of currency (so GBp is GBP/USD ÷ 100),
+// or 0 if no rate is known
+func (fx *FXRates) Rate(currency string) float64 {
+	major, divisor := majorCurrency(currency)
+
+	fx.mu.Lock()
+	defer fx.mu.Unlock()
+
+	if rate, ok := fx.fixed[major]; ok {
+		return rate / divisor
+	}
+	return fx.rates[major] / divisor
+}
+
+// Refresh fetches rates for the given quote currencies that are missing or
+// older than the TTL
+func (fx *FXRates) Refresh(ctx context.Context, currencies []string) {
+	now := time.Now()
+
+	fx.mu.Lock()
+	seen := make(map[string]bool)
+	var due []string
+	for _, c := range currencies {
+		major, _ := majorCurrency(c)
+		if major == "" || major == "USD" || seen[major] {
+			continue
+		}
+		seen[major] = true
+		if _, ok := fx.fixed[major]; ok {
+			continue
+		}
+		if now.Sub(fx.fetchedAt[major]) >= fx.ttl {
+			due = append(due, major)
+		}
+	}
+	fx.mu.Unlock()
+
+	if len(due) == 0 {
+		return
+	}
+
+	rates, err := fx.fetch(ctx, due)
+	if err != nil {
+		log.Printf("Failed to refresh FX rates for %v: %v", due, err)
+	}
+
+	fx.mu.Lock()
+	defer fx.mu.Unlock()
+	for _, c := range due {
+		if rate, ok := rates[c]; ok && rate > 0 {
+			fx.rates[c] = rate
+			fx.fetchedAt[c] = now
+		} else if fx.rates[c] == 0 {
+			log.Printf("No FX rate for %s, USD prices will be missing", c)
+		}
+	}
+}
+
+func (fx *FXRates) fetch(ctx context.Context, currencies []string) (map[string]float64, error) {
+	if p, ok := fx.provider.(FXProvider); ok {
+		var rates map[string]float64
+		err := fx.request(ctx, func() error {
+			var err error
+			rates, err = p.FetchFXRates(ctx, currencies)
+			return err
+		})
+		return rates, err
+	}
+
+	pairs := make([]string, len(currencies))
+	for i, c := range currencies {
+		pairs[i] = fxPairSymbol(c)
+	}
+
+	var quotes map[string]Quote
+	var err error
+	if bp, ok := fx.provider.(BatchPriceProvider); ok {
+		err = fx.request(ctx, func() error {
+			var err error
+			quotes, err = bp.FetchQuotes(ctx, pairs)
+			return err
+		})
+	} else {
+		quotes = make(map[string]Quote, len(pairs))
+		for _, pair := range pairs {
+			var q Quote
+			qerr := fx.request(ctx, func() error {
+				var err error
+				q, err = fx.provider.FetchQuote(ctx, pair)
+				return err
+			})
+			if errors.Is(qerr, ErrCircuitOpen) || ctx.Err() != nil {
+				err = qerr
+				break
+			}
+			if qerr != nil {
+				err = qerr
+				continue
+			}
+			quotes[pair] = q
+		}
+	}
+
+	rates := make(map[string]float64, len(currencies))
+	for _, c := range currencies {
+		if q, ok := quotes[fxPairSymbol(c)]; ok && q.Price > 0 {
+			rates[c] = q.Price
+		}
+	}
+	if len(rates) == 0 && err == nil {
+		err = fmt.Errorf("provider %s returned no FX quotes", fx.provider.Name())
+	}
+	return rates, err
+}
+
+// request makes one provider request under the rate limiter, retry policy
+// and circuit breaker
+func (fx *FXRates) request(ctx context.Context, fn func() error) error {
+	return fx.retry.Do(ctx, fx.limiter, fx.breaker, fn)
+}
+
+// fxPairSymbol is the Yahoo symbol quoting USD per unit of currency
+func fxPairSymbol(currency string) string {
+	return currency + "USD=X"
+}
+
+// applyFX fills in the currency and USD rate of each quote
+func (u *Updater) applyFX(ctx context.Context, stocks []Stock, quotes map[string]Quote) {
+	var currencies []string
+	for _, s := range stocks {
+		if q, ok := quotes[s.Symbol]; ok {
+			currencies = append(currencies, quoteCurrency(s, q))
+		}
+	}
+	u.fx.Refresh(ctx, currencies)
+
+	for _, s := range stocks {
+		q, ok := quotes[s.Symbol]
+		if !ok {
+			continue
+		}
+		q.Currency = quoteCurrency(s, q)
+		if q.Exchange == "" {
+			q.Exchange = s.Exchange
+		}
+		q.FXRate = u.fx.Rate(q.Currency)
+		quotes[s.Symbol] = q
+	}
+}
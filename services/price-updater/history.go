@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ticksCollection = "pricehistory"
+	barsCollection  = "pricebars"
+)
+
+// barIntervals are the OHLCV granularities maintained by the rollup
+var barIntervals = []struct {
+	Name string
+	Size time.Duration
+}{
+	{"1m", time.Minute},
+	{"1h", time.Hour},
+	{"1d", 24 * time.Hour},
+}
+
+func intervalSize(name string) (time.Duration, bool) {
+	for _, iv := range barIntervals {
+		if iv.Name == name {
+			return iv.Size, true
+		}
+	}
+	return 0, false
+}
+
+// Tick is one observed quote stored in the time-series collection
+type Tick struct {
+	Timestamp time.Time `bson:"timestamp"`
+	Symbol    string    `bson:"symbol"`
+	Price     float64   `bson:"price"`
+	Volume    float64   `bson:"volume,omitempty"`
+}
+
+// Bar is an OHLCV candle for one symbol and interval.
+// Start is the UTC-aligned beginning of the bucket.
+type Bar struct {
+	Symbol    string     `bson:"symbol"`
+	Interval  string     `bson:"interval"`
+	Start     time.Time  `bson:"start"`
+	Open      float64    `bson:"open"`
+	High      float64    `bson:"high"`
+	Low       float64    `bson:"low"`
+	Close     float64    `bson:"close"`
+	Volume    float64    `bson:"volume"`
+	UpdatedAt time.Time  `bson:"updatedAt"`
+	ExpiresAt *time.Time `bson:"expiresAt,omitempty"`
+}
+
+// HistoryStore appends raw ticks and rolls them up into OHLCV bars
+type HistoryStore struct {
+	db        *mongo.Database
+	ticks     *mongo.Collection
+	bars      *mongo.Collection
+	retention map[string]time.Duration // "ticks", "1m", "1h", "1d"; 0 keeps forever
+
+	mu   sync.Mutex
+	last map[string]Quote // most recent quote recorded per symbol
+}
+
+// NewHistoryStore reads retention settings from HISTORY_RETENTION_TICKS,
+// HISTORY_RETENTION_1M, HISTORY_RETENTION_1H and HISTORY_RETENTION_1D.
+func NewHistoryStore(db *mongo.Database) *HistoryStore {
+	return &HistoryStore{
+		db:    db,
+		ticks: db.Collection(ticksCollection),
+		bars:  db.Collection(barsCollection),
+		retention: map[string]time.Duration{
+			"ticks": envDuration("HISTORY_RETENTION_TICKS", 7*24*time.Hour),
+			"1m":    envDuration("HISTORY_RETENTION_1M", 7*24*time.Hour),
+			"1h":    envDuration("HISTORY_RETENTION_1H", 90*24*time.Hour),
+			"1d":    envDuration("HISTORY_RETENTION_1D", 0),
+		},
+		last: make(map[string]Quote),
+	}
+}
+
+// EnsureSchema creates the time-series tick collection and the bar indexes.
+// It is safe to call on every start.
+func (h *HistoryStore) EnsureSchema(ctx context.Context) error {
+	tsOpts := options.CreateCollection().SetTimeSeriesOptions(
+		options.TimeSeries().SetTimeField("timestamp").SetMetaField("symbol").SetGranularity("seconds"),
+	)
+	if ttl := h.retention["ticks"]; ttl > 0 {
+		tsOpts.SetExpireAfterSeconds(int64(ttl.Seconds()))
+	}
+
+	if err := h.db.CreateCollection(ctx, ticksCollection, tsOpts); err != nil {
+		var cmdErr mongo.CommandError
+		if !errors.As(err, &cmdErr) || cmdErr.Code != 48 { // NamespaceExists
+			return fmt.Errorf("create %s: %w", ticksCollection, err)
+		}
+	}
+
+	_, err := h.bars.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "symbol", Value: 1}, {Key: "interval", Value: 1}, {Key: "start", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+// Record stores every new quote as a tick and folds it into the 1m/1h/1d
+// bars. Quotes whose timestamp is not newer than the last one recorded for
+// the symbol (e.g. a closed market) are skipped.
+func (h *HistoryStore) Record(ctx context.Context, quotes []Quote) error {
+	var ticks []interface{}
+	var models []mongo.WriteModel
+	now := time.Now()
+
+	for _, q := range quotes {
+		delta, fresh := h.advance(q)
+		if !fresh {
+			continue
+		}
+
+		ticks = append(ticks, Tick{Timestamp: q.Timestamp, Symbol: q.Symbol, Price: q.Price, Volume: q.Volume})
+		for _, iv := range barIntervals {
+			models = append(models, h.rollupModel(q, iv.Name, iv.Size, delta, now))
+		}
+	}
+
+	if len(ticks) == 0 {
+		return nil
+	}
+
+	if _, err := h.ticks.InsertMany(ctx, ticks, options.InsertMany().SetOrdered(false)); err != nil {
+		log.Printf("Failed to append price ticks: %v", err)
+	}
+
+	_, err := h.bars.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// UpsertBars writes complete bars, replacing any existing bar for the same
+// symbol, interval and start. Re-running a backfill is therefore idempotent.
+func (h *HistoryStore) UpsertBars(ctx context.Context, bars []Bar) (int, error) {
+	if len(bars) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(bars))
+	for _, b := range bars {
+		b.UpdatedAt = now
+		b.ExpiresAt = nil
+		if ttl := h.retention[b.Interval]; ttl > 0 {
+			expires := b.Start.Add(ttl)
+			b.ExpiresAt = &expires
+		}
+
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"symbol": b.Symbol, "interval": b.Interval, "start": b.Start}).
+			SetReplacement(b).
+			SetUpsert(true))
+	}
+
+	result, err := h.bars.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if result == nil {
+		return 0, err
+	}
+	return int(result.UpsertedCount + result.ModifiedCount), err
+}
+
+// rollupModel upserts the bar containing q. Quotes arrive in time order, so
+// the latest one always becomes the close.
+func (h *HistoryStore) rollupModel(q Quote, interval string, size time.Duration, volumeDelta float64, now time.Time) mongo.WriteModel {
+	start := q.Timestamp.UTC().Truncate(size)
+
+	insert := bson.M{"open": q.Price}
+	if ttl := h.retention[interval]; ttl > 0 {
+		insert["expiresAt"] = start.Add(ttl)
+	}
+
+	update := bson.M{
+		"$setOnInsert": insert,
+		"$max":         bson.M{"high": q.Price},
+		"$min":         bson.M{"low": q.Price},
+		"$set":         bson.M{"close": q.Price, "updatedAt": now},
+	}
+
+	// Providers report cumulative session volume: the daily bar takes it as
+	// is, intraday bars accumulate the change since the previous quote.
+	if interval == "1d" {
+		update["$max"].(bson.M)["volume"] = q.Volume
+	} else {
+		update["$inc"] = bson.M{"volume": volumeDelta}
+	}
+
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"symbol": q.Symbol, "interval": interval, "start": start}).
+		SetUpdate(update).
+		SetUpsert(true)
+}
+
+// advance records q as the latest quote for its symbol and returns the
+// volume traded since the previous one. fresh is false for repeated quotes.
+func (h *HistoryStore) advance(q Quote) (volumeDelta float64, fresh bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	last, seen := h.last[q.Symbol]
+	if seen && !q.Timestamp.After(last.Timestamp) {
+		return 0, false
+	}
+	h.last[q.Symbol] = q
+
+	// Unknown baseline or a new session (volume reset): nothing to attribute
+	if !seen || q.Volume < last.Volume {
+		return 0, true
+	}
+	return q.Volume - last.Volume, true
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("Invalid %s %q, using %v", key, v, fallback)
+		return fallback
+	}
+	return d
+}
@@ -2,24 +2,29 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
-	"sync"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Stock struct {
-	ID           interface{} `bson:"_id"`
-	Symbol       string      `bson:"symbol"`
-	CurrentPrice float64     `bson:"currentPrice"`
+	ID             interface{} `bson:"_id"`
+	Symbol         string      `bson:"symbol"`
+	CurrentPrice   float64     `bson:"currentPrice"`
+	PreviousClose  float64     `bson:"previousClose"`
+	High24h        float64     `bson:"high24h"`
+	Low24h         float64     `bson:"low24h"`
+	Currency       string      `bson:"currency"`
+	Exchange       string      `bson:"exchange"`
+	UpdatedAt      time.Time   `bson:"updatedAt"`
+	PriceUpdatedAt time.Time   `bson:"priceUpdatedAt"` // when currentPrice was last accepted
 }
 
 var (
@@ -37,11 +42,28 @@ func main() {
 		mongoURI = "mongodb://localhost:27017/stockforumx"
 	}
 
+	// Stop cleanly on Ctrl+C / docker stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	command := "run"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	// The mock feed needs no database
+	if command == "mockstream" {
+		if err := runMockStream(ctx, os.Args[2:]); err != nil {
+			log.Fatal("Mock stream failed:", err)
+		}
+		return
+	}
+
 	// Connect to MongoDB
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	client, err := mongo.Connect(connectCtx, options.Client().ApplyURI(mongoURI))
 	if err != nil {
 		log.Fatal("Connection failed:", err)
 	}
@@ -49,116 +71,92 @@ func main() {
 
 	fmt.Println("Connected to MongoDB at", mongoURI)
 
-	// Get Collection
-	collection := client.Database("stockforumx").Collection("stocks")
-
-	// 1. Fetch all stocks
-	cursor, err := collection.Find(context.Background(), bson.M{})
+	provider, err := newProviderFromEnv()
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("Provider setup failed:", err)
 	}
-	defer cursor.Close(context.Background())
+	fmt.Println("Using price provider:", provider.Name())
 
-	var stocks []Stock
-	if err = cursor.All(context.Background(), &stocks); err != nil {
-		log.Fatal(err)
-	}
+	// Get Database
+	db := client.Database("stockforumx")
 
-	fmt.Printf("Found %d stocks to update...\n", len(stocks))
-
-	// 2. Worker Pool for Concurrent Updates
-	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, 1) // Limit to 1 concurrent request (Sequential)
-
-	startTime := time.Now()
-
-	for _, stock := range stocks {
-		wg.Add(1)
-		semaphore <- struct{}{} // Acquire token
-
-		go func(s Stock) {
-			defer wg.Done()
-			defer func() { <-semaphore }() // Release token
-
-			// Fixed 2s delay to be polite to free API
-			time.Sleep(2 * time.Second)
+	if command == "backfill" {
+		if err := runBackfill(ctx, db, provider, os.Args[2:]); err != nil {
+			log.Fatal("Backfill failed:", err)
+		}
+		return
+	}
 
-			// Fetch real price
-			price, err := fetchPrice(s.Symbol)
-			if err != nil {
-				log.Printf("Failed to fetch %s: %v", s.Symbol, err)
-				return
-			}
-			
-			// Update DB
-			updateStockPrice(collection, s, price)
-		}(stock)
+	if command == "priority" {
+		if err := runPriority(ctx, db); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	wg.Wait()
-	duration := time.Since(startTime)
+	if command == "universe" {
+		if err := runUniverse(ctx, db, provider, os.Args[2:]); err != nil {
+			log.Fatal("Universe sync failed:", err)
+		}
+		return
+	}
 
-	fmt.Printf("Updated %d stocks in %v\n", len(stocks), duration)
-}
+	if command == "actions" {
+		if err := runActions(ctx, client, db, provider, os.Args[2:]); err != nil {
+			log.Fatal("Corporate actions failed:", err)
+		}
+		return
+	}
 
-// Yahoo Finance Response Structs
-type YahooResponse struct {
-	Chart struct {
-		Result []struct {
-			Meta struct {
-				RegularMarketPrice float64 `json:"regularMarketPrice"`
-			} `json:"meta"`
-		} `json:"result"`
-	} `json:"chart"`
-}
+	updater := NewUpdater(db, provider)
 
-func fetchPrice(symbol string) (float64, error) {
-	url := fmt.Sprintf("https://query1.finance.yahoo.com/v8/finance/chart/%s?interval=1d&range=1d", symbol)
-	
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return 0, err
+	if os.Getenv("HISTORY_ENABLED") != "false" {
+		history := NewHistoryStore(db)
+		if err := history.EnsureSchema(ctx); err != nil {
+			log.Printf("Warning: price history schema setup failed: %v", err)
+		}
+		updater.history = history
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0, err
+	if os.Getenv("CORPORATE_ACTIONS_ENABLED") != "false" {
+		actions := NewCorporateActions(client, db)
+		if err := actions.EnsureSchema(ctx); err != nil {
+			log.Printf("Warning: corporate actions schema setup failed: %v", err)
+		}
+		updater.actions = actions
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return 0, fmt.Errorf("status code %d", resp.StatusCode)
+	publisher, err := NewRedisPublisherFromEnv()
+	if err != nil {
+		log.Fatal("Redis setup failed:", err)
 	}
-
-	var data YahooResponse
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return 0, err
+	if publisher != nil {
+		defer publisher.Close()
+		if err := publisher.Ping(ctx); err != nil {
+			log.Printf("Warning: Redis is not reachable yet, ticks will be dropped until it is: %v", err)
+		}
+		updater.publisher = publisher
 	}
 
-	if len(data.Chart.Result) == 0 {
-		return 0, fmt.Errorf("no data found")
+	if command == "quarantine" {
+		if err := runQuarantine(ctx, updater, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	return data.Chart.Result[0].Meta.RegularMarketPrice, nil
-}
-
-func updateStockPrice(collection *mongo.Collection, s Stock, newPrice float64) {
-	_, err := collection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": s.ID},
-		bson.M{
-			"$set": bson.M{
-				"currentPrice": newPrice,
-				"updatedAt":    time.Now(),
-			},
-		},
-	)
-
-	if err != nil {
-		log.Printf("Failed to DB update %s: %v\n", s.Symbol, err)
-	} else {
-		fmt.Printf("✓ Updated %s: $%.2f -> $%.2f\n", s.Symbol, s.CurrentPrice, newPrice)
+	stream, streaming := provider.(StreamingProvider)
+
+	switch {
+	case command == "run" && streaming:
+		NewStreamer(updater, stream, loadStreamConfig()).Run(ctx)
+	case command == "run":
+		runScheduler(ctx, updater, loadSchedulerConfig())
+	case command == "once" && streaming:
+		log.Fatalf("The %s provider only supports the run command", provider.Name())
+	case command == "once":
+		updater.runCycle(ctx)
+	default:
+		log.Fatalf("Unknown command %q (expected run, once, backfill, quarantine, actions, universe, priority or mockstream)", command)
 	}
 }
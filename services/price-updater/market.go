@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // alpine images ship without zoneinfo
+)
+
+// Exchange describes the regular trading session of a market
+type Exchange struct {
+	Code       string
+	Location   *time.Location
+	Open       time.Duration // offset from local midnight
+	Close      time.Duration
+	AlwaysOpen bool
+}
+
+// MarketCalendar decides whether a symbol's exchange is in session
+type MarketCalendar struct {
+	exchanges map[string]Exchange
+	holidays  map[string]map[string]bool // exchange code -> "2006-01-02" -> closed
+}
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+func defaultExchanges() map[string]Exchange {
+	return map[string]Exchange{
+		"US":     {Code: "US", Location: mustLoadLocation("America/New_York"), Open: 9*time.Hour + 30*time.Minute, Close: 16 * time.Hour},
+		"NSE":    {Code: "NSE", Location: mustLoadLocation("Asia/Kolkata"), Open: 9*time.Hour + 15*time.Minute, Close: 15*time.Hour + 30*time.Minute},
+		"LSE":    {Code: "LSE", Location: mustLoadLocation("Europe/London"), Open: 8 * time.Hour, Close: 16*time.Hour + 30*time.Minute},
+		"CRYPTO": {Code: "CRYPTO", Location: time.UTC, AlwaysOpen: true},
+	}
+}
+
+// NewMarketCalendar builds the calendar, optionally loading exchange holidays
+// from a JSON file of the form {"US": ["2026-12-25", ...]}.
+func NewMarketCalendar(holidaysFile string) (*MarketCalendar, error) {
+	cal := &MarketCalendar{
+		exchanges: defaultExchanges(),
+		holidays:  make(map[string]map[string]bool),
+	}
+
+	if holidaysFile == "" {
+		return cal, nil
+	}
+
+	data, err := os.ReadFile(holidaysFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string][]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", holidaysFile, err)
+	}
+
+	for code, dates := range raw {
+		code = strings.ToUpper(code)
+		cal.holidays[code] = make(map[string]bool, len(dates))
+		for _, d := range dates {
+			cal.holidays[code][d] = true
+		}
+	}
+	return cal, nil
+}
+
+// exchangeForSymbol infers the listing exchange from Yahoo-style symbol suffixes
+func exchangeForSymbol(symbol string) string {
+	symbol = strings.ToUpper(symbol)
+	switch {
+	case strings.HasSuffix(symbol, ".NS"), strings.HasSuffix(symbol, ".BO"):
+		return "NSE"
+	case strings.HasSuffix(symbol, ".L"):
+		return "LSE"
+	case strings.HasSuffix(symbol, "-USD"):
+		return "CRYPTO"
+	default:
+		return "US"
+	}
+}
+
+// exchangeCodes maps the exchange names providers report (Yahoo's
+// exchangeName, MIC codes) to calendar exchange codes
+var exchangeCodes = map[string]string{
+	// US
+	"US": "US", "NMS": "US", "NGM": "US", "NCM": "US", "NAS": "US", "NASDAQ": "US",
+	"NYQ": "US", "NYS": "US", "NYSE": "US", "ASE": "US", "AMEX": "US", "PCX": "US",
+	"BTS": "US", "PNK": "US", "XNAS": "US", "XNYS": "US", "ARCX": "US",
+	// India
+	"NSE": "NSE", "NSI": "NSE", "BSE": "NSE", "BOM": "NSE", "XNSE": "NSE", "XBOM": "NSE",
+	// London
+	"LSE": "LSE", "IOB": "LSE", "XLON": "LSE",
+	// Crypto
+	"CRYPTO": "CRYPTO", "CCC": "CRYPTO", "CCY": "CRYPTO",
+}
+
+// exchangeCode normalises a reported exchange name to a calendar code,
+// falling back to the symbol suffix for names it doesn't know
+func exchangeCode(reported, symbol string) string {
+	if code, ok := exchangeCodes[strings.ToUpper(strings.TrimSpace(reported))]; ok {
+		return code
+	}
+	return exchangeForSymbol(symbol)
+}
+
+// IsOpen reports whether the exchange is in its regular session at t
+func (c *MarketCalendar) IsOpen(code string, t time.Time) bool {
+	ex, ok := c.exchanges[code]
+	if !ok {
+		ex = c.exchanges["US"]
+	}
+	if ex.AlwaysOpen {
+		return true
+	}
+
+	local := t.In(ex.Location)
+	if local.Weekday() == time.Saturday || local.Weekday() == time.Sunday {
+		return false
+	}
+	if c.holidays[ex.Code][local.Format("2006-01-02")] {
+		return false
+	}
+
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, ex.Location)
+	offset := local.Sub(midnight)
+	return offset >= ex.Open && offset < ex.Close
+}
+
+// sessionExchanges are the sessions priceUpdate tracks the day's range by
+var sessionExchanges = defaultExchanges()
+
+// sessionStart returns when the exchange's latest session began at or
+// before t: the open of the latest weekday, or midnight UTC for markets that
+// are always open. Holidays are ignored, since no quotes arrive on them.
+func sessionStart(code string, t time.Time) time.Time {
+	ex, ok := sessionExchanges[code]
+	if !ok {
+		ex = sessionExchanges["US"]
+	}
+	if ex.AlwaysOpen {
+		return t.UTC().Truncate(24 * time.Hour)
+	}
+
+	local := t.In(ex.Location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, ex.Location)
+	for {
+		open := day.Add(ex.Open)
+		if wd := day.Weekday(); wd != time.Saturday && wd != time.Sunday && !open.After(t) {
+			return open
+		}
+		day = day.AddDate(0, 0, -1)
+	}
+}
+
+// FilterOpen returns only the stocks whose exchange is currently trading,
+// going by the exchange stored on the stock
+func (c *MarketCalendar) FilterOpen(stocks []Stock, now time.Time) []Stock {
+	open := make([]Stock, 0, len(stocks))
+	for _, s := range stocks {
+		if c.IsOpen(exchangeCode(s.Exchange, s.Symbol), now) {
+			open = append(open, s)
+		}
+	}
+	return open
+}
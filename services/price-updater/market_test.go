@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionStart(t *testing.T) {
+	utc := func(day, hour, min int) time.Time { return time.Date(2024, 3, day, hour, min, 0, 0, time.UTC) }
+
+	// 2024-03-04 is a Monday; New York is on EST (UTC-5) until 2024-03-10
+	tests := []struct {
+		name string
+		code string
+		at   time.Time
+		want time.Time
+	}{
+		{"US during the session", "US", utc(4, 15, 0), utc(4, 14, 30)},
+		{"US at the open", "US", utc(4, 14, 30), utc(4, 14, 30)},
+		{"US before Monday's open is still Friday's session", "US", utc(4, 14, 0), utc(1, 14, 30)},
+		{"US after the close", "US", utc(4, 23, 0), utc(4, 14, 30)},
+		{"US on Saturday", "US", utc(2, 18, 0), utc(1, 14, 30)},
+		{"US after the clocks change", "US", utc(11, 15, 0), utc(11, 13, 30)},
+		{"NSE", "NSE", utc(4, 5, 0), utc(4, 3, 45)},
+		{"LSE", "LSE", utc(4, 9, 0), utc(4, 8, 0)},
+		{"crypto starts at midnight UTC", "CRYPTO", utc(3, 18, 0), utc(3, 0, 0)},
+		{"unknown codes use US hours", "XX", utc(4, 15, 0), utc(4, 14, 30)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sessionStart(tt.code, tt.at); !got.Equal(tt.want) {
+				t.Errorf("sessionStart(%s, %v) = %v, want %v", tt.code, tt.at, got.UTC(), tt.want)
+			}
+		})
+	}
+}
+
+func TestExchangeCode(t *testing.T) {
+	tests := []struct {
+		reported, symbol, want string
+	}{
+		{"NMS", "AAPL", "US"},
+		{" nyq ", "IBM", "US"},
+		{"NSI", "RELIANCE.NS", "NSE"},
+		{"BSE", "RELIANCE.BO", "NSE"},
+		{"IOB", "BP.L", "LSE"},
+		{"CCC", "BTC-USD", "CRYPTO"},
+		{"", "VOD.L", "LSE"},
+		{"", "ETH-USD", "CRYPTO"},
+		{"Somewhere", "TCS.NS", "NSE"},
+		{"", "MSFT", "US"},
+	}
+	for _, tt := range tests {
+		if got := exchangeCode(tt.reported, tt.symbol); got != tt.want {
+			t.Errorf("exchangeCode(%q, %q) = %q, want %q", tt.reported, tt.symbol, got, tt.want)
+		}
+	}
+}
+
+func TestIsOpen(t *testing.T) {
+	cal := &MarketCalendar{
+		exchanges: defaultExchanges(),
+		holidays:  map[string]map[string]bool{"US": {"2024-03-05": true}},
+	}
+	tests := []struct {
+		code string
+		at   time.Time
+		want bool
+	}{
+		{"US", time.Date(2024, 3, 4, 15, 0, 0, 0, time.UTC), true},
+		{"US", time.Date(2024, 3, 4, 21, 0, 0, 0, time.UTC), false},
+		{"US", time.Date(2024, 3, 5, 15, 0, 0, 0, time.UTC), false}, // holiday
+		{"US", time.Date(2024, 3, 2, 15, 0, 0, 0, time.UTC), false}, // Saturday
+		{"NSE", time.Date(2024, 3, 5, 5, 0, 0, 0, time.UTC), true},
+		{"CRYPTO", time.Date(2024, 3, 2, 3, 0, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		if got := cal.IsOpen(tt.code, tt.at); got != tt.want {
+			t.Errorf("IsOpen(%s, %v) = %v, want %v", tt.code, tt.at, got, tt.want)
+		}
+	}
+}
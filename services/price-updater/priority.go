@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Refresh tiers
+const (
+	TierHot  = "hot"
+	TierWarm = "warm"
+	TierCold = "cold"
+)
+
+// Interest weights. An active alert always makes a symbol hot regardless of
+// score, since a stale price there means a late notification.
+const (
+	weightHolding   = 5
+	weightWatchlist = 3
+	weightQuestion  = 1
+	weightChat      = 0.5
+)
+
+// PriorityConfig controls how often each tier is refreshed
+type PriorityConfig struct {
+	HotScore     float64       // score at which a symbol becomes hot
+	WarmEvery    int           // refresh warm symbols every N cycles
+	ColdEvery    int           // refresh cold symbols every N cycles
+	Window       time.Duration // how far back questions and chat count
+	RescoreEvery time.Duration // how often interest is recomputed
+}
+
+func loadPriorityConfig() PriorityConfig {
+	return PriorityConfig{
+		HotScore:     envFloat("PRICE_HOT_SCORE", 5),
+		WarmEvery:    envInt("PRICE_WARM_EVERY", 3),
+		ColdEvery:    envInt("PRICE_COLD_EVERY", 10),
+		Window:       envDuration("PRICE_ACTIVITY_WINDOW", 24*time.Hour),
+		RescoreEvery: envDuration("PRICE_RESCORE_INTERVAL", 5*time.Minute),
+	}
+}
+
+// Priority scores symbols by user interest and decides which of them are
+// due in a given cycle. Hot symbols are refreshed every cycle; warm and cold
+// ones every WarmEvery / ColdEvery cycles, spread over the cycles by a hash
+// of the symbol so the load stays even.
+type Priority struct {
+	db  *mongo.Database
+	cfg PriorityConfig
+
+	mu       sync.Mutex
+	byStock  map[primitive.ObjectID]float64
+	bySymbol map[string]float64
+	scoredAt time.Time
+	cycle    int
+}
+
+func NewPriority(db *mongo.Database, cfg PriorityConfig) *Priority {
+	return &Priority{db: db, cfg: cfg}
+}
+
+// Select returns the stocks due in this cycle and how many were deferred
+func (p *Priority) Select(ctx context.Context, stocks []Stock) ([]Stock, int) {
+	if time.Since(p.scoredAt) >= p.cfg.RescoreEvery {
+		if err := p.rescore(ctx); err != nil {
+			// Keep the previous scores; with none every symbol is cold
+			log.Printf("Failed to score refresh priority: %v", err)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Never scored: refresh everything rather than treat it all as cold
+	if p.byStock == nil {
+		return stocks, 0
+	}
+
+	cycle := p.cycle
+	p.cycle++
+
+	due := make([]Stock, 0, len(stocks))
+	tiers := make(map[string]int)
+	for _, s := range stocks {
+		tier := p.tier(s)
+		tiers[tier]++
+
+		every := 1
+		switch tier {
+		case TierWarm:
+			every = p.cfg.WarmEvery
+		case TierCold:
+			every = p.cfg.ColdEvery
+		}
+
+		if every <= 1 || (cycle+symbolOffset(s.Symbol, every))%every == 0 {
+			due = append(due, s)
+		}
+	}
+
+	if cycle == 0 {
+		fmt.Printf("Refresh tiers: hot=%d warm=%d cold=%d\n", tiers[TierHot], tiers[TierWarm], tiers[TierCold])
+	}
+	return due, len(stocks) - len(due)
+}
+
+func (p *Priority) score(s Stock) float64 {
+	score := p.bySymbol[s.Symbol]
+	if id, ok := s.ID.(primitive.ObjectID); ok {
+		score += p.byStock[id]
+	}
+	return score
+}
+
+func (p *Priority) tier(s Stock) string {
+	switch score := p.score(s); {
+	case score >= p.cfg.HotScore:
+		return TierHot
+	case score > 0:
+		return TierWarm
+	default:
+		return TierCold
+	}
+}
+
+func symbolOffset(symbol string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(symbol))
+	return int(h.Sum32() % uint32(n))
+}
+
+// rescore recomputes interest from watchlists, holdings, active alerts and
+// recent questions and chat messages
+func (p *Priority) rescore(ctx context.Context) error {
+	byStock := make(map[primitive.ObjectID]float64)
+	bySymbol := make(map[string]float64)
+	since := time.Now().Add(-p.cfg.Window)
+
+	sources := []struct {
+		coll     string
+		pipeline mongo.Pipeline
+		weight   float64
+	}{
+		{"users", mongo.Pipeline{
+			{{Key: "$unwind", Value: "$watchlist"}},
+			{{Key: "$group", Value: bson.M{"_id": "$watchlist", "n": bson.M{"$sum": 1}}}},
+		}, weightWatchlist},
+		{"holdings", mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"quantity": bson.M{"$gt": 0}}}},
+			{{Key: "$group", Value: bson.M{"_id": "$stockId", "n": bson.M{"$sum": 1}}}},
+		}, weightHolding},
+		{"questions", mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"createdAt": bson.M{"$gte": since}}}},
+			{{Key: "$group", Value: bson.M{"_id": "$stockId", "n": bson.M{"$sum": 1}}}},
+		}, weightQuestion},
+		{"chatmessages", mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"createdAt": bson.M{"$gte": since}}}},
+			{{Key: "$group", Value: bson.M{"_id": "$stockId", "n": bson.M{"$sum": 1}}}},
+		}, weightChat},
+	}
+
+	for _, src := range sources {
+		cursor, err := p.db.Collection(src.coll).Aggregate(ctx, src.pipeline)
+		if err != nil {
+			return fmt.Errorf("%s: %w", src.coll, err)
+		}
+
+		var rows []struct {
+			ID primitive.ObjectID `bson:"_id"`
+			N  float64            `bson:"n"`
+		}
+		if err := cursor.All(ctx, &rows); err != nil {
+			return fmt.Errorf("%s: %w", src.coll, err)
+		}
+
+		for _, r := range rows {
+			byStock[r.ID] += r.N * src.weight
+		}
+	}
+
+	alerted, err := p.db.Collection("alerts").Distinct(ctx, "symbol", bson.M{"isActive": true})
+	if err != nil {
+		return fmt.Errorf("alerts: %w", err)
+	}
+	for _, v := range alerted {
+		if symbol, ok := v.(string); ok {
+			bySymbol[symbol] = p.cfg.HotScore
+		}
+	}
+
+	p.mu.Lock()
+	p.byStock = byStock
+	p.bySymbol = bySymbol
+	p.scoredAt = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+// runPriority prints the current score and tier of every active stock:
+//
+//	priority
+func runPriority(ctx context.Context, db *mongo.Database) error {
+	p := NewPriority(db, loadPriorityConfig())
+	if err := p.rescore(ctx); err != nil {
+		return err
+	}
+
+	cursor, err := db.Collection("stocks").Find(ctx, activeStocks)
+	if err != nil {
+		return err
+	}
+	var stocks []Stock
+	if err := cursor.All(ctx, &stocks); err != nil {
+		return err
+	}
+
+	sort.Slice(stocks, func(i, j int) bool { return p.score(stocks[i]) > p.score(stocks[j]) })
+	for _, s := range stocks {
+		fmt.Printf("%-12s %-5s %6.1f\n", s.Symbol, p.tier(s), p.score(s))
+	}
+	return nil
+}
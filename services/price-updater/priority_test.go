@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPriorityTiers(t *testing.T) {
+	held := primitive.NewObjectID()
+	p := &Priority{
+		cfg:      PriorityConfig{HotScore: 5},
+		byStock:  map[primitive.ObjectID]float64{held: 5},
+		bySymbol: map[string]float64{"MSFT": 1, "AMZN": 4, "TSLA": 6},
+	}
+
+	tests := []struct {
+		stock Stock
+		want  string
+	}{
+		{Stock{ID: held, Symbol: "AAPL"}, TierHot},
+		{Stock{ID: primitive.NewObjectID(), Symbol: "TSLA"}, TierHot},
+		{Stock{ID: primitive.NewObjectID(), Symbol: "AMZN"}, TierWarm},
+		{Stock{ID: primitive.NewObjectID(), Symbol: "MSFT"}, TierWarm},
+		{Stock{ID: primitive.NewObjectID(), Symbol: "IBM"}, TierCold},
+		{Stock{ID: "not an ObjectID", Symbol: "IBM"}, TierCold},
+	}
+	for _, tt := range tests {
+		if got := p.tier(tt.stock); got != tt.want {
+			t.Errorf("tier(%s) = %s, want %s", tt.stock.Symbol, got, tt.want)
+		}
+	}
+}
+
+func TestPrioritySelectSpreadsTiers(t *testing.T) {
+	var stocks []Stock
+	scores := make(map[string]float64)
+	for i := 0; i < 30; i++ {
+		symbol := fmt.Sprintf("S%02d", i)
+		stocks = append(stocks, Stock{ID: primitive.NewObjectID(), Symbol: symbol})
+		switch {
+		case i < 5:
+			scores[symbol] = 10 // hot
+		case i < 15:
+			scores[symbol] = 1 // warm
+		}
+	}
+
+	p := &Priority{
+		cfg:      PriorityConfig{HotScore: 5, WarmEvery: 3, ColdEvery: 5, RescoreEvery: time.Hour},
+		byStock:  map[primitive.ObjectID]float64{},
+		bySymbol: scores,
+		scoredAt: time.Now(), // no rescore, so no database
+	}
+
+	// Over ColdEvery × WarmEvery cycles each symbol comes up exactly as often
+	// as its tier says
+	refreshed := make(map[string]int)
+	for cycle := 0; cycle < 15; cycle++ {
+		due, deferred := p.Select(context.Background(), stocks)
+		if len(due)+deferred != len(stocks) {
+			t.Fatalf("cycle %d: %d due + %d deferred != %d", cycle, len(due), deferred, len(stocks))
+		}
+		for _, s := range due {
+			refreshed[s.Symbol]++
+		}
+	}
+	for i, s := range stocks {
+		want := 3 // cold: every 5th of 15 cycles
+		switch {
+		case i < 5:
+			want = 15
+		case i < 15:
+			want = 5
+		}
+		if refreshed[s.Symbol] != want {
+			t.Errorf("%s refreshed %d times in 15 cycles, want %d", s.Symbol, refreshed[s.Symbol], want)
+		}
+	}
+}
+
+func TestPrioritySelectBeforeScoring(t *testing.T) {
+	// Never scored (the rescore failed): everything is due
+	p := &Priority{cfg: PriorityConfig{RescoreEvery: time.Hour}, scoredAt: time.Now()}
+	stocks := []Stock{{Symbol: "AAPL"}, {Symbol: "MSFT"}}
+	if due, deferred := p.Select(context.Background(), stocks); len(due) != 2 || deferred != 0 {
+		t.Errorf("%d due, %d deferred; want all due", len(due), deferred)
+	}
+}
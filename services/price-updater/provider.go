@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Quote is a single price observation returned by a PriceProvider.
+// Fields other than Symbol, Price and Timestamp are optional; zero means the
+// provider did not report them.
+type Quote struct {
+	Symbol           string
+	Price            float64
+	Timestamp        time.Time
+	PreviousClose    float64
+	DayHigh          float64
+	DayLow           float64
+	Volume           float64
+	FiftyTwoWeekHigh float64
+	FiftyTwoWeekLow  float64
+	MarketCap        float64
+	Currency         string  // ISO code as reported, e.g. INR or GBp (pence); empty if unknown
+	Exchange         string  // listing exchange as reported by the provider
+	FXRate           float64 // USD per unit of Currency, filled in by the updater
+}
+
+// quoteJSON is the wire format shared by the http and file providers
+type quoteJSON struct {
+	Symbol           string    `json:"symbol"`
+	Price            float64   `json:"price"`
+	Timestamp        time.Time `json:"timestamp"`
+	PreviousClose    float64   `json:"previousClose"`
+	DayHigh          float64   `json:"dayHigh"`
+	DayLow           float64   `json:"dayLow"`
+	Volume           float64   `json:"volume"`
+	FiftyTwoWeekHigh float64   `json:"fiftyTwoWeekHigh"`
+	FiftyTwoWeekLow  float64   `json:"fiftyTwoWeekLow"`
+	MarketCap        float64   `json:"marketCap"`
+	Currency         string    `json:"currency"`
+	Exchange         string    `json:"exchange"`
+}
+
+func (q quoteJSON) quote(symbol string) Quote {
+	quote := Quote{
+		Symbol:           symbol,
+		Price:            q.Price,
+		Timestamp:        q.Timestamp,
+		PreviousClose:    q.PreviousClose,
+		DayHigh:          q.DayHigh,
+		DayLow:           q.DayLow,
+		Volume:           q.Volume,
+		FiftyTwoWeekHigh: q.FiftyTwoWeekHigh,
+		FiftyTwoWeekLow:  q.FiftyTwoWeekLow,
+		MarketCap:        q.MarketCap,
+		Currency:         q.Currency,
+		Exchange:         q.Exchange,
+	}
+	if quote.Timestamp.IsZero() {
+		quote.Timestamp = time.Now()
+	}
+	return quote
+}
+
+// PriceProvider is the source of market data for the updater.
+// Implementations must be safe for concurrent use.
+type PriceProvider interface {
+	Name() string
+	FetchQuote(ctx context.Context, symbol string) (Quote, error)
+}
+
+// BatchPriceProvider is implemented by providers that can quote several
+// symbols in a single request. Symbols missing from the result failed.
+type BatchPriceProvider interface {
+	PriceProvider
+	MaxBatchSize() int
+	FetchQuotes(ctx context.Context, symbols []string) (map[string]Quote, error)
+}
+
+// HistoryProvider is implemented by providers that can serve historical
+// OHLCV bars, used by the backfill command.
+type HistoryProvider interface {
+	FetchBars(ctx context.Context, symbol, interval string, from, to time.Time) ([]Bar, error)
+}
+
+// StreamingProvider pushes quotes over a long-lived connection instead of
+// being polled. Subscribe and Unsubscribe may be called at any time, also
+// while disconnected; the subscription set is restored on reconnect.
+type StreamingProvider interface {
+	PriceProvider
+	Subscribe(symbols []string) error
+	Unsubscribe(symbols []string) error
+	Stream(ctx context.Context, quotes chan<- Quote) error
+}
+
+// getJSON performs a GET request and decodes the JSON body into out.
+// Failures are returned as classified *ProviderError values.
+func getJSON(ctx context.Context, client *http.Client, endpoint string, headers map[string]string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return errNetwork(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return errStatus(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errParse(err)
+	}
+	return nil
+}
+
+// newProviderFromEnv builds the provider selected by PRICE_PROVIDER.
+// Supported values: "yahoo" (default), "file" and "http".
+func newProviderFromEnv() (PriceProvider, error) {
+	kind := strings.ToLower(os.Getenv("PRICE_PROVIDER"))
+
+	switch kind {
+	case "", "yahoo":
+		return NewYahooProvider(), nil
+	case "file":
+		path := os.Getenv("PRICE_PROVIDER_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("PRICE_PROVIDER_FILE is required for the file provider")
+		}
+		return NewFileProvider(path)
+	case "http":
+		baseURL := os.Getenv("PRICE_PROVIDER_URL")
+		if baseURL == "" {
+			return nil, fmt.Errorf("PRICE_PROVIDER_URL is required for the http provider")
+		}
+		return NewHTTPProvider(baseURL), nil
+	case "ws":
+		streamURL := os.Getenv("PRICE_PROVIDER_URL")
+		if streamURL == "" {
+			return nil, fmt.Errorf("PRICE_PROVIDER_URL is required for the ws provider")
+		}
+		return NewWSProvider(streamURL), nil
+	default:
+		return nil, fmt.Errorf("unknown PRICE_PROVIDER %q", kind)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileProvider replays quotes from a local CSV or JSON file. JSON files hold
+// an array of quoteJSON objects; CSV files use the same names as header
+// columns, of which only symbol and price are required.
+// Each symbol walks through its own series in file order and loops back to
+// the start once exhausted, so the updater can run offline indefinitely.
+type FileProvider struct {
+	mu      sync.Mutex
+	series  map[string][]quoteJSON
+	cursors map[string]int
+}
+
+func NewFileProvider(path string) (*FileProvider, error) {
+	records, err := loadReplayFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &FileProvider{
+		series:  make(map[string][]quoteJSON),
+		cursors: make(map[string]int),
+	}
+	for _, r := range records {
+		symbol := strings.ToUpper(r.Symbol)
+		p.series[symbol] = append(p.series[symbol], r)
+	}
+	return p, nil
+}
+
+func (p *FileProvider) Name() string { return "file" }
+
+// MaxBatchSize is effectively unbounded since no network call is made
+func (p *FileProvider) MaxBatchSize() int { return 1000 }
+
+func (p *FileProvider) FetchQuotes(ctx context.Context, symbols []string) (map[string]Quote, error) {
+	quotes := make(map[string]Quote, len(symbols))
+	for _, symbol := range symbols {
+		if q, err := p.FetchQuote(ctx, symbol); err == nil {
+			quotes[symbol] = q
+		}
+	}
+	return quotes, nil
+}
+
+func (p *FileProvider) FetchQuote(ctx context.Context, symbol string) (Quote, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := strings.ToUpper(symbol)
+	series := p.series[key]
+	if len(series) == 0 {
+		return Quote{}, errNoData()
+	}
+
+	i := p.cursors[key] % len(series)
+	p.cursors[key] = i + 1
+
+	return series[i].quote(symbol), nil
+}
+
+func loadReplayFile(path string) ([]quoteJSON, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var records []quoteJSON
+		if err := json.NewDecoder(f).Decode(&records); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		return records, nil
+	case ".csv":
+		return parseReplayCSV(f)
+	default:
+		return nil, fmt.Errorf("unsupported replay file %s (want .csv or .json)", path)
+	}
+}
+
+func parseReplayCSV(r io.Reader) ([]quoteJSON, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["symbol"]; !ok {
+		return nil, fmt.Errorf("missing symbol column")
+	}
+	if _, ok := columns["price"]; !ok {
+		return nil, fmt.Errorf("missing price column")
+	}
+
+	var records []quoteJSON
+	for i, row := range rows[1:] {
+		line := i + 2
+		field := func(name string) string {
+			if idx, ok := columns[name]; ok && idx < len(row) {
+				return strings.TrimSpace(row[idx])
+			}
+			return ""
+		}
+
+		rec := quoteJSON{
+			Symbol:   field("symbol"),
+			Currency: field("currency"),
+			Exchange: field("exchange"),
+		}
+		numbers := map[string]*float64{
+			"price":            &rec.Price,
+			"previousClose":    &rec.PreviousClose,
+			"dayHigh":          &rec.DayHigh,
+			"dayLow":           &rec.DayLow,
+			"volume":           &rec.Volume,
+			"fiftyTwoWeekHigh": &rec.FiftyTwoWeekHigh,
+			"fiftyTwoWeekLow":  &rec.FiftyTwoWeekLow,
+			"marketCap":        &rec.MarketCap,
+		}
+		for name, dst := range numbers {
+			v := field(name)
+			if v == "" {
+				continue
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %w", line, name, err)
+			}
+			*dst = f
+		}
+
+		if v := field("timestamp"); v != "" {
+			ts, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			rec.Timestamp = ts
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReplayCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []quoteJSON
+		wantErr string
+	}{
+		{
+			name: "all columns",
+			csv: "symbol,price,timestamp,previousClose,dayHigh,dayLow,volume,currency,exchange\n" +
+				"RELIANCE.NS,2950.5,2024-03-04T04:00:00Z,2900,2960,2890,12000,INR,NSI\n",
+			want: []quoteJSON{{
+				Symbol: "RELIANCE.NS", Price: 2950.5, Timestamp: time.Date(2024, 3, 4, 4, 0, 0, 0, time.UTC),
+				PreviousClose: 2900, DayHigh: 2960, DayLow: 2890, Volume: 12000, Currency: "INR", Exchange: "NSI",
+			}},
+		},
+		{
+			name: "columns in any order, blanks and short rows",
+			csv:  "price, symbol ,volume,currency\n101, AAPL ,,USD\n99.5,MSFT\n",
+			want: []quoteJSON{
+				{Symbol: "AAPL", Price: 101, Currency: "USD"},
+				{Symbol: "MSFT", Price: 99.5},
+			},
+		},
+		{name: "header only", csv: "symbol,price\n"},
+		{name: "empty", csv: ""},
+		{name: "no symbol column", csv: "ticker,price\nAAPL,1\n", wantErr: "missing symbol column"},
+		{name: "no price column", csv: "symbol,close\nAAPL,1\n", wantErr: "missing price column"},
+		{name: "bad number", csv: "symbol,price\nAAPL,1\nMSFT,abc\n", wantErr: "line 3: price"},
+		{name: "bad timestamp", csv: "symbol,price,timestamp\nAAPL,1,yesterday\n", wantErr: "line 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseReplayCSV(strings.NewReader(tt.csv))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const httpMaxBatch = 50
+
+// HTTPProvider reads quotes from a generic JSON quote API, such as a local
+// stand-in server or an internal market data gateway.
+// GET {baseURL}/quote/{symbol} returns a single quoteJSON object and
+// GET {baseURL}/quotes?symbols=A,B returns an array of them.
+type HTTPProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewHTTPProvider(baseURL string) *HTTPProvider {
+	return &HTTPProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *HTTPProvider) Name() string { return "http" }
+
+func (p *HTTPProvider) MaxBatchSize() int { return httpMaxBatch }
+
+func (p *HTTPProvider) FetchQuote(ctx context.Context, symbol string) (Quote, error) {
+	var data quoteJSON
+	if err := p.get(ctx, fmt.Sprintf("%s/quote/%s", p.baseURL, url.PathEscape(symbol)), &data); err != nil {
+		return Quote{}, err
+	}
+
+	return data.quote(symbol), nil
+}
+
+func (p *HTTPProvider) FetchQuotes(ctx context.Context, symbols []string) (map[string]Quote, error) {
+	var data []quoteJSON
+	endpoint := fmt.Sprintf("%s/quotes?symbols=%s", p.baseURL, url.QueryEscape(strings.Join(symbols, ",")))
+	if err := p.get(ctx, endpoint, &data); err != nil {
+		return nil, err
+	}
+
+	quotes := make(map[string]Quote, len(data))
+	for _, d := range data {
+		quotes[d.Symbol] = d.quote(d.Symbol)
+	}
+	return quotes, nil
+}
+
+func (p *HTTPProvider) get(ctx context.Context, endpoint string, out interface{}) error {
+	return getJSON(ctx, p.client, endpoint, map[string]string{"Accept": "application/json"}, out)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsPingInterval = 30 * time.Second
+	wsReadTimeout  = 2 * wsPingInterval
+	wsWriteTimeout = 10 * time.Second
+)
+
+// wsCommand is sent by the client to change its subscriptions:
+//
+//	{"action": "subscribe", "symbols": ["AAPL", "MSFT"]}
+//	{"action": "unsubscribe", "symbols": ["MSFT"]}
+type wsCommand struct {
+	Action  string   `json:"action"`
+	Symbols []string `json:"symbols"`
+}
+
+// WSProvider receives quotes from a WebSocket feed. The server pushes
+// quoteJSON objects, either one per message or as an array. The latest
+// quote per symbol is cached so FetchQuote (used by `once`) still works.
+type WSProvider struct {
+	url    string
+	dialer *websocket.Dialer
+	retry  RetryPolicy
+
+	mu      sync.Mutex
+	symbols map[string]bool
+	latest  map[string]Quote
+	conn    *websocket.Conn
+	writeMu sync.Mutex // gorilla allows one concurrent writer
+}
+
+func NewWSProvider(url string) *WSProvider {
+	retry := loadRetryPolicy()
+	retry.MaxDelay = envDuration("PRICE_STREAM_MAX_BACKOFF", 30*time.Second)
+
+	return &WSProvider{
+		url:     url,
+		dialer:  &websocket.Dialer{HandshakeTimeout: 10 * time.Second},
+		retry:   retry,
+		symbols: make(map[string]bool),
+		latest:  make(map[string]Quote),
+	}
+}
+
+func (p *WSProvider) Name() string { return "ws" }
+
+// FetchQuote returns the most recent streamed quote for symbol
+func (p *WSProvider) FetchQuote(ctx context.Context, symbol string) (Quote, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	q, ok := p.latest[strings.ToUpper(symbol)]
+	if !ok {
+		return Quote{}, errNoData()
+	}
+	return q, nil
+}
+
+func (p *WSProvider) Subscribe(symbols []string) error {
+	return p.update("subscribe", symbols, true)
+}
+
+func (p *WSProvider) Unsubscribe(symbols []string) error {
+	return p.update("unsubscribe", symbols, false)
+}
+
+func (p *WSProvider) update(action string, symbols []string, subscribed bool) error {
+	if len(symbols) == 0 {
+		return nil
+	}
+
+	upper := make([]string, len(symbols))
+	p.mu.Lock()
+	for i, s := range symbols {
+		s = strings.ToUpper(s)
+		upper[i] = s
+		if subscribed {
+			p.symbols[s] = true
+		} else {
+			delete(p.symbols, s)
+			delete(p.latest, s)
+		}
+	}
+	conn := p.conn
+	p.mu.Unlock()
+
+	// While disconnected the change is picked up on reconnect
+	if conn == nil {
+		return nil
+	}
+	return p.send(conn, wsCommand{Action: action, Symbols: upper})
+}
+
+func (p *WSProvider) send(conn *websocket.Conn, v interface{}) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return conn.WriteJSON(v)
+}
+
+// Stream connects to the feed and forwards quotes until ctx is cancelled,
+// reconnecting with exponential backoff whenever the connection drops.
+func (p *WSProvider) Stream(ctx context.Context, quotes chan<- Quote) error {
+	for attempt := 0; ; {
+		err := p.session(ctx, quotes)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var connected *wsSessionError
+		if errors.As(err, &connected) && connected.received {
+			attempt = 0 // the feed was healthy, start backing off from scratch
+		}
+		attempt++
+
+		delay := p.retry.backoff(attempt)
+		log.Printf("Stream %s disconnected: %v (reconnecting in %v)", p.url, err, delay.Round(time.Millisecond))
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// wsSessionError records whether a session got as far as receiving data
+type wsSessionError struct {
+	received bool
+	err      error
+}
+
+func (e *wsSessionError) Error() string { return e.err.Error() }
+func (e *wsSessionError) Unwrap() error { return e.err }
+
+// session runs a single connection: dial, resubscribe, then read until an
+// error occurs
+func (p *WSProvider) session(ctx context.Context, quotes chan<- Quote) error {
+	conn, _, err := p.dialer.DialContext(ctx, p.url, nil)
+	if err != nil {
+		return &wsSessionError{err: err}
+	}
+	defer conn.Close()
+
+	p.mu.Lock()
+	symbols := make([]string, 0, len(p.symbols))
+	for s := range p.symbols {
+		symbols = append(symbols, s)
+	}
+	p.conn = conn
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		p.conn = nil
+		p.mu.Unlock()
+	}()
+
+	if len(symbols) > 0 {
+		if err := p.send(conn, wsCommand{Action: "subscribe", Symbols: symbols}); err != nil {
+			return &wsSessionError{err: err}
+		}
+	}
+	fmt.Printf("Connected to quote stream %s (%d symbols)\n", p.url, len(symbols))
+
+	// Unblock ReadMessage on shutdown and keep idle connections alive
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				p.writeMu.Lock()
+				conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+				p.writeMu.Unlock()
+			}
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	})
+
+	received := false
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return &wsSessionError{received: received, err: err}
+		}
+		conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+
+		batch, err := decodeStreamMessage(data)
+		if err != nil {
+			log.Printf("Ignoring malformed stream message: %v", err)
+			continue
+		}
+		received = true
+
+		for _, q := range batch {
+			p.mu.Lock()
+			subscribed := p.symbols[q.Symbol]
+			if subscribed {
+				p.latest[q.Symbol] = q
+			}
+			p.mu.Unlock()
+
+			// Drop ticks that raced with an unsubscribe
+			if !subscribed {
+				continue
+			}
+
+			select {
+			case quotes <- q:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// decodeStreamMessage accepts a single quote object or an array of them
+func decodeStreamMessage(data []byte) ([]Quote, error) {
+	var list []quoteJSON
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+	} else {
+		var one quoteJSON
+		if err := json.Unmarshal(data, &one); err != nil {
+			return nil, err
+		}
+		list = append(list, one)
+	}
+
+	quotes := make([]Quote, 0, len(list))
+	for _, q := range list {
+		// Status messages from the server carry no symbol
+		if q.Symbol == "" {
+			continue
+		}
+		symbol := strings.ToUpper(q.Symbol)
+		quotes = append(quotes, q.quote(symbol))
+	}
+	return quotes, nil
+}
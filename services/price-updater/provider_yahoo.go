@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	yahooChartURL = "https://query1.finance.yahoo.com/v8/finance/chart/%s?interval=1d&range=1d"
+	yahooSparkURL = "https://query1.finance.yahoo.com/v7/finance/spark?symbols=%s&interval=1d&range=1d"
+	yahooHistURL  = "https://query1.finance.yahoo.com/v8/finance/chart/%s?interval=%s&period1=%d&period2=%d"
+	yahooEventURL = "https://query1.finance.yahoo.com/v8/finance/chart/%s?interval=1d&period1=%d&period2=%d&events=div%%2Csplits"
+	yahooMaxBatch = 20
+)
+
+// Yahoo Finance Response Structs
+type yahooMeta struct {
+	RegularMarketPrice   float64 `json:"regularMarketPrice"`
+	RegularMarketTime    int64   `json:"regularMarketTime"`
+	PreviousClose        float64 `json:"previousClose"`
+	ChartPreviousClose   float64 `json:"chartPreviousClose"`
+	RegularMarketDayHigh float64 `json:"regularMarketDayHigh"`
+	RegularMarketDayLow  float64 `json:"regularMarketDayLow"`
+	RegularMarketVolume  float64 `json:"regularMarketVolume"`
+	FiftyTwoWeekHigh     float64 `json:"fiftyTwoWeekHigh"`
+	FiftyTwoWeekLow      float64 `json:"fiftyTwoWeekLow"`
+	MarketCap            float64 `json:"marketCap"`
+	Currency             string  `json:"currency"`
+	ExchangeName         string  `json:"exchangeName"`
+}
+
+type YahooResponse struct {
+	Chart struct {
+		Result []struct {
+			Meta yahooMeta `json:"meta"`
+		} `json:"result"`
+	} `json:"chart"`
+}
+
+type yahooHistoryResponse struct {
+	Chart struct {
+		Result []struct {
+			Timestamp  []int64 `json:"timestamp"`
+			Indicators struct {
+				Quote []struct {
+					Open   []*float64 `json:"open"`
+					High   []*float64 `json:"high"`
+					Low    []*float64 `json:"low"`
+					Close  []*float64 `json:"close"`
+					Volume []*float64 `json:"volume"`
+				} `json:"quote"`
+			} `json:"indicators"`
+		} `json:"result"`
+	} `json:"chart"`
+}
+
+type yahooEventsResponse struct {
+	Chart struct {
+		Result []struct {
+			Events struct {
+				Dividends map[string]struct {
+					Amount float64 `json:"amount"`
+					Date   int64   `json:"date"`
+				} `json:"dividends"`
+				Splits map[string]struct {
+					Date        int64   `json:"date"`
+					Numerator   float64 `json:"numerator"`
+					Denominator float64 `json:"denominator"`
+				} `json:"splits"`
+			} `json:"events"`
+		} `json:"result"`
+	} `json:"chart"`
+}
+
+type yahooSparkResponse struct {
+	Spark struct {
+		Result []struct {
+			Symbol   string `json:"symbol"`
+			Response []struct {
+				Meta yahooMeta `json:"meta"`
+			} `json:"response"`
+		} `json:"result"`
+	} `json:"spark"`
+}
+
+func (m yahooMeta) quote(symbol string) Quote {
+	q := Quote{
+		Symbol:           symbol,
+		Price:            m.RegularMarketPrice,
+		Timestamp:        time.Now(),
+		PreviousClose:    m.PreviousClose,
+		DayHigh:          m.RegularMarketDayHigh,
+		DayLow:           m.RegularMarketDayLow,
+		Volume:           m.RegularMarketVolume,
+		FiftyTwoWeekHigh: m.FiftyTwoWeekHigh,
+		FiftyTwoWeekLow:  m.FiftyTwoWeekLow,
+		MarketCap:        m.MarketCap,
+		Currency:         m.Currency,
+		Exchange:         m.ExchangeName,
+	}
+	// With range=1d the chart's previous close is the prior session's close
+	if q.PreviousClose == 0 {
+		q.PreviousClose = m.ChartPreviousClose
+	}
+	if m.RegularMarketTime > 0 {
+		q.Timestamp = time.Unix(m.RegularMarketTime, 0)
+	}
+	return q
+}
+
+// YahooProvider reads quotes from the public Yahoo Finance chart endpoint
+type YahooProvider struct {
+	client *http.Client
+}
+
+func NewYahooProvider() *YahooProvider {
+	return &YahooProvider{client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (p *YahooProvider) Name() string { return "yahoo" }
+
+func (p *YahooProvider) MaxBatchSize() int { return yahooMaxBatch }
+
+func (p *YahooProvider) FetchQuote(ctx context.Context, symbol string) (Quote, error) {
+	var data YahooResponse
+	if err := p.get(ctx, fmt.Sprintf(yahooChartURL, symbol), &data); err != nil {
+		return Quote{}, err
+	}
+
+	if len(data.Chart.Result) == 0 {
+		return Quote{}, errNoData()
+	}
+
+	return data.Chart.Result[0].Meta.quote(symbol), nil
+}
+
+// FetchQuotes uses the spark endpoint, which returns chart metadata for up
+// to yahooMaxBatch symbols per call.
+func (p *YahooProvider) FetchQuotes(ctx context.Context, symbols []string) (map[string]Quote, error) {
+	var data yahooSparkResponse
+	if err := p.get(ctx, fmt.Sprintf(yahooSparkURL, url.QueryEscape(strings.Join(symbols, ","))), &data); err != nil {
+		return nil, err
+	}
+
+	quotes := make(map[string]Quote, len(symbols))
+	for _, r := range data.Spark.Result {
+		if len(r.Response) == 0 {
+			continue
+		}
+		quotes[r.Symbol] = r.Response[0].Meta.quote(r.Symbol)
+	}
+	return quotes, nil
+}
+
+// FetchBars pulls OHLCV candles from the chart endpoint. Yahoo only keeps
+// 1m data for the last 30 days.
+func (p *YahooProvider) FetchBars(ctx context.Context, symbol, interval string, from, to time.Time) ([]Bar, error) {
+	size, ok := intervalSize(interval)
+	if !ok {
+		return nil, fmt.Errorf("unsupported interval %q", interval)
+	}
+
+	var data yahooHistoryResponse
+	if err := p.get(ctx, fmt.Sprintf(yahooHistURL, symbol, interval, from.Unix(), to.Unix()), &data); err != nil {
+		return nil, err
+	}
+
+	if len(data.Chart.Result) == 0 || len(data.Chart.Result[0].Indicators.Quote) == 0 {
+		return nil, errNoData()
+	}
+
+	result := data.Chart.Result[0]
+	q := result.Indicators.Quote[0]
+	value := func(series []*float64, i int) (float64, bool) {
+		if i >= len(series) || series[i] == nil {
+			return 0, false
+		}
+		return *series[i], true
+	}
+
+	var bars []Bar
+	for i, ts := range result.Timestamp {
+		closePrice, ok := value(q.Close, i)
+		if !ok {
+			continue // Yahoo pads gaps with nulls
+		}
+		open, _ := value(q.Open, i)
+		high, _ := value(q.High, i)
+		low, _ := value(q.Low, i)
+		volume, _ := value(q.Volume, i)
+
+		bars = append(bars, Bar{
+			Symbol:   symbol,
+			Interval: interval,
+			Start:    time.Unix(ts, 0).UTC().Truncate(size),
+			Open:     open,
+			High:     high,
+			Low:      low,
+			Close:    closePrice,
+			Volume:   volume,
+		})
+	}
+	return bars, nil
+}
+
+// FetchActions reads split and dividend events from the chart endpoint
+func (p *YahooProvider) FetchActions(ctx context.Context, symbol string, from, to time.Time) ([]CorporateAction, error) {
+	var data yahooEventsResponse
+	if err := p.get(ctx, fmt.Sprintf(yahooEventURL, symbol, from.Unix(), to.Unix()), &data); err != nil {
+		return nil, err
+	}
+
+	if len(data.Chart.Result) == 0 {
+		return nil, errNoData()
+	}
+
+	events := data.Chart.Result[0].Events
+	var actions []CorporateAction
+	for _, s := range events.Splits {
+		if s.Numerator <= 0 || s.Denominator <= 0 {
+			continue
+		}
+		actions = append(actions, CorporateAction{
+			Symbol: symbol,
+			Type:   ActionSplit,
+			ExDate: time.Unix(s.Date, 0).UTC(),
+			Ratio:  s.Numerator / s.Denominator,
+		})
+	}
+	for _, d := range events.Dividends {
+		actions = append(actions, CorporateAction{
+			Symbol: symbol,
+			Type:   ActionDividend,
+			ExDate: time.Unix(d.Date, 0).UTC(),
+			Amount: d.Amount,
+		})
+	}
+	return actions, nil
+}
+
+func (p *YahooProvider) get(ctx context.Context, endpoint string, out interface{}) error {
+	return getJSON(ctx, p.client, endpoint, map[string]string{
+		"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36",
+	}, out)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// tickSchemaVersion is bumped on any breaking change to PriceTickMessage
+const tickSchemaVersion = 1
+
+// PriceTickMessage is published for every quote written to stocks. The
+// derived fields match what was stored, so consumers never need to read
+// them back from MongoDB.
+type PriceTickMessage struct {
+	Type          string    `json:"type"` // always "price.tick"
+	Version       int       `json:"version"`
+	Symbol        string    `json:"symbol"`
+	Price         float64   `json:"price"`
+	PreviousClose float64   `json:"previousClose,omitempty"`
+	Change        float64   `json:"change"`
+	ChangePercent float64   `json:"changePercent"`
+	High24h       float64   `json:"high24h"`
+	Low24h        float64   `json:"low24h"`
+	Volume        float64   `json:"volume,omitempty"`
+	Currency      string    `json:"currency,omitempty"`
+	PriceUSD      float64   `json:"priceUSD,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`   // quote time from the provider
+	PublishedAt   time.Time `json:"publishedAt"` // when it was written to stocks
+	Source        string    `json:"source"`      // provider name
+}
+
+// RedisPublisher fans accepted quotes out over Redis pub/sub and, if
+// configured, appends them to a capped Redis Stream
+type RedisPublisher struct {
+	client    *redis.Client
+	channel   string
+	stream    string // empty disables the stream
+	streamLen int64
+}
+
+// NewRedisPublisherFromEnv returns nil when REDIS_URL is not set
+func NewRedisPublisherFromEnv() (*RedisPublisher, error) {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		return nil, nil
+	}
+
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+
+	channel := os.Getenv("PRICE_REDIS_CHANNEL")
+	if channel == "" {
+		channel = "prices:ticks"
+	}
+
+	return &RedisPublisher{
+		client:    redis.NewClient(opts),
+		channel:   channel,
+		stream:    os.Getenv("PRICE_REDIS_STREAM"),
+		streamLen: int64(envInt("PRICE_REDIS_STREAM_MAXLEN", 10000)),
+	}, nil
+}
+
+func (p *RedisPublisher) Ping(ctx context.Context) error {
+	return p.client.Ping(ctx).Err()
+}
+
+func (p *RedisPublisher) Close() error {
+	return p.client.Close()
+}
+
+// Publish sends every message in one pipeline round trip
+func (p *RedisPublisher) Publish(ctx context.Context, messages []PriceTickMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	pipe := p.client.Pipeline()
+	for _, m := range messages {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+
+		pipe.Publish(ctx, p.channel, data)
+		if p.stream != "" {
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: p.stream,
+				MaxLen: p.streamLen,
+				Approx: true, // trimming in whole nodes is much cheaper
+				Values: map[string]interface{}{"symbol": m.Symbol, "data": data},
+			})
+		}
+	}
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// publishQuotes announces the quotes that were just written. Publishing is
+// best effort: Redis being down never holds up price updates.
+func (u *Updater) publishQuotes(stocks []Stock, quotes map[string]Quote) {
+	now := time.Now()
+	messages := make([]PriceTickMessage, 0, len(quotes))
+	for _, s := range stocks {
+		q, ok := quotes[s.Symbol]
+		if !ok {
+			continue
+		}
+		messages = append(messages, tickMessage(s, q, priceUpdate(s, q, now), now, u.provider.Name()))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := u.publisher.Publish(ctx, messages); err != nil {
+		log.Printf("Failed to publish %d ticks to Redis: %v", len(messages), err)
+	}
+}
+
+func tickMessage(s Stock, q Quote, set bson.M, now time.Time, source string) PriceTickMessage {
+	field := func(name string) float64 {
+		v, _ := set[name].(float64)
+		return v
+	}
+
+	return PriceTickMessage{
+		Type:          "price.tick",
+		Version:       tickSchemaVersion,
+		Symbol:        s.Symbol,
+		Price:         q.Price,
+		PreviousClose: field("previousClose"),
+		Change:        field("change"),
+		ChangePercent: field("changePercent"),
+		High24h:       field("high24h"),
+		Low24h:        field("low24h"),
+		Volume:        q.Volume,
+		Currency:      q.Currency,
+		PriceUSD:      field("priceUSD"),
+		Timestamp:     q.Timestamp.UTC(),
+		PublishedAt:   now.UTC(),
+		Source:        source,
+	}
+}
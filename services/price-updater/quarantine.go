@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const quarantineCollection = "pricequarantine"
+
+// QuarantinedQuote is a rejected quote awaiting review
+type QuarantinedQuote struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	StockID        interface{}        `bson:"stockId"`
+	Symbol         string             `bson:"symbol"`
+	Price          float64            `bson:"price"`
+	PreviousPrice  float64            `bson:"previousPrice"`
+	Reason         string             `bson:"reason"`
+	Provider       string             `bson:"provider"`
+	QuoteTimestamp time.Time          `bson:"quoteTimestamp"`
+	Status         string             `bson:"status"` // PENDING, APPROVED, REJECTED or RELEASED
+	Occurrences    int                `bson:"occurrences"`
+	Confirmations  int                `bson:"confirmations"` // consecutive quotes agreeing with price
+	CreatedAt      time.Time          `bson:"createdAt"`
+	UpdatedAt      time.Time          `bson:"updatedAt"`
+}
+
+// quarantineQuotes records rejected quotes. Each symbol keeps a single
+// PENDING entry that is refreshed with the latest suspicious quote.
+func quarantineQuotes(ctx context.Context, coll *mongo.Collection, provider string, entries []QuarantinedQuote) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(entries))
+	for _, e := range entries {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"symbol": e.Symbol, "status": "PENDING"}).
+			SetUpdate(bson.M{
+				"$set": bson.M{
+					"stockId":        e.StockID,
+					"price":          e.Price,
+					"previousPrice":  e.PreviousPrice,
+					"reason":         e.Reason,
+					"provider":       provider,
+					"quoteTimestamp": e.QuoteTimestamp,
+					"confirmations":  e.Confirmations,
+					"updatedAt":      now,
+				},
+				"$inc":         bson.M{"occurrences": 1},
+				"$setOnInsert": bson.M{"createdAt": now},
+			}).
+			SetUpsert(true))
+	}
+
+	_, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// pendingQuotes returns the PENDING entry of each of the symbols that has one
+func pendingQuotes(ctx context.Context, coll *mongo.Collection, symbols []string) (map[string]QuarantinedQuote, error) {
+	cursor, err := coll.Find(ctx, bson.M{"symbol": bson.M{"$in": symbols}, "status": "PENDING"})
+	if err != nil {
+		return nil, err
+	}
+	var entries []QuarantinedQuote
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	pending := make(map[string]QuarantinedQuote, len(entries))
+	for _, e := range entries {
+		pending[e.Symbol] = e
+	}
+	return pending, nil
+}
+
+// releaseQuotes closes the PENDING entries of symbols whose new level was
+// confirmed by consistent quotes
+func releaseQuotes(ctx context.Context, coll *mongo.Collection, symbols []string) error {
+	if len(symbols) == 0 {
+		return nil
+	}
+	_, err := coll.UpdateMany(ctx,
+		bson.M{"symbol": bson.M{"$in": symbols}, "status": "PENDING"},
+		bson.M{"$set": bson.M{"status": "RELEASED", "reviewedAt": time.Now()}},
+	)
+	return err
+}
+
+func (q QuarantinedQuote) String() string {
+	return fmt.Sprintf("%s  %-8s %-11s $%.2f (was $%.2f) x%d  %s",
+		q.ID.Hex(), q.Symbol, q.Reason, q.Price, q.PreviousPrice, q.Occurrences, q.UpdatedAt.Format(time.RFC3339))
+}
+
+// runQuarantine implements the review commands:
+//
+//	quarantine list
+//	quarantine approve <id>   apply the quoted price to the stock
+//	quarantine reject <id>    discard the quote
+//
+// An approved quote is applied like any accepted one (see applyQuotes).
+func runQuarantine(ctx context.Context, u *Updater, args []string) error {
+	coll := u.quarantine
+
+	if len(args) == 0 || args[0] == "list" {
+		cursor, err := coll.Find(ctx, bson.M{"status": "PENDING"}, options.Find().SetSort(bson.M{"updatedAt": -1}))
+		if err != nil {
+			return err
+		}
+		var entries []QuarantinedQuote
+		if err := cursor.All(ctx, &entries); err != nil {
+			return err
+		}
+
+		fmt.Printf("%d pending quotes\n", len(entries))
+		for _, e := range entries {
+			fmt.Println(e)
+		}
+		return nil
+	}
+
+	if len(args) != 2 || (args[0] != "approve" && args[0] != "reject") {
+		return fmt.Errorf("usage: quarantine [list | approve <id> | reject <id>]")
+	}
+
+	id, err := primitive.ObjectIDFromHex(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid id %q", args[1])
+	}
+
+	var entry QuarantinedQuote
+	if err := coll.FindOne(ctx, bson.M{"_id": id, "status": "PENDING"}).Decode(&entry); err != nil {
+		return fmt.Errorf("pending quote %s: %w", args[1], err)
+	}
+
+	status := "REJECTED"
+	if args[0] == "approve" {
+		status = "APPROVED"
+
+		var stock Stock
+		if err := u.stocks.FindOne(ctx, bson.M{"_id": entry.StockID}).Decode(&stock); err != nil {
+			return fmt.Errorf("stock %s: %w", entry.Symbol, err)
+		}
+
+		quotes := map[string]Quote{
+			stock.Symbol: {Symbol: stock.Symbol, Price: entry.Price, Timestamp: entry.QuoteTimestamp},
+		}
+		if u.applyQuotes(ctx, []Stock{stock}, quotes) == 0 {
+			return fmt.Errorf("failed to write %s", entry.Symbol)
+		}
+	}
+
+	_, err = coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"status":     status,
+		"reviewedAt": time.Now(),
+	}})
+	if err == nil {
+		fmt.Printf("%s %s quote for %s at $%.2f\n", status, entry.Reason, entry.Symbol, entry.Price)
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		waits int // Waits that must not block before the bucket is empty
+	}{
+		{"burst is available at once", 0.001, 3, 3},
+		{"burst is at least one", 0.001, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewTokenBucket(tt.rate, tt.burst)
+			for i := 0; i < tt.waits; i++ {
+				if err := b.Wait(context.Background()); err != nil {
+					t.Fatalf("wait %d: %v", i+1, err)
+				}
+			}
+			// A cancelled context only fails a Wait that would block
+			if err := b.Wait(cancelled); err == nil {
+				t.Errorf("wait %d didn't block", tt.waits+1)
+			}
+		})
+	}
+
+	// A zero rate disables limiting, but not cancellation
+	b := NewTokenBucket(0, 1)
+	for i := 0; i < 10; i++ {
+		if err := b.Wait(context.Background()); err != nil {
+			t.Fatalf("unlimited wait %d: %v", i+1, err)
+		}
+	}
+	if err := b.Wait(cancelled); err == nil {
+		t.Error("unlimited wait ignored a cancelled context")
+	}
+}
+
+func TestTokenBucketRefills(t *testing.T) {
+	b := NewTokenBucket(50, 1) // a token every 20ms
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := b.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Errorf("3 tokens at 50/s with a burst of 1 took %v, want at least 40ms", elapsed)
+	}
+}
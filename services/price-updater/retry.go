@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy retries transient and rate-limited provider errors with
+// exponential backoff and full jitter
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func loadRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: envInt("PRICE_RETRY_ATTEMPTS", 3),
+		BaseDelay:   envDuration("PRICE_RETRY_BASE_DELAY", 500*time.Millisecond),
+		MaxDelay:    envDuration("PRICE_RETRY_MAX_DELAY", 10*time.Second),
+	}
+}
+
+// backoff returns the delay before retry number attempt (starting at 1)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.BaseDelay << (attempt - 1)
+	if ceiling <= 0 || ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Do runs fn until it succeeds, returns a non-retryable error, the breaker
+// opens or attempts run out. Every attempt first takes a token from the
+// limiter (if any), so retries count against the rate limit too. Every
+// outcome is reported to the breaker.
+func (p RetryPolicy) Do(ctx context.Context, limiter *TokenBucket, breaker *CircuitBreaker, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		if breaker != nil && !breaker.Allow() {
+			return ErrCircuitOpen
+		}
+
+		err = fn()
+		if breaker != nil {
+			breaker.Record(err)
+		}
+
+		var pe *ProviderError
+		if err == nil || !errors.As(err, &pe) || !pe.Retryable() || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		// Honour Retry-After, but no further than the policy's longest delay
+		delay := p.backoff(attempt)
+		if pe.RetryAfter > delay {
+			delay = min(pe.RetryAfter, p.MaxDelay)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ErrCircuitOpen is returned while the breaker is rejecting requests
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitBreaker stops calling a provider after too many consecutive
+// transient, rate-limited or unauthorized failures. After the cooldown a
+// single probe request is let through; success closes the breaker again.
+type CircuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func NewCircuitBreaker(name string) *CircuitBreaker {
+	return &CircuitBreaker{
+		name:      name,
+		threshold: envInt("PRICE_BREAKER_THRESHOLD", 5),
+		cooldown:  envDuration("PRICE_BREAKER_COOLDOWN", time.Minute),
+	}
+}
+
+// Allow reports whether a request may be sent now
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+
+	// Half-open: allow exactly one probe
+	b.probing = true
+	return true
+}
+
+// Record feeds the outcome of a request into the breaker. Not-found and
+// parse errors say nothing about provider health and are ignored.
+func (b *CircuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Shutdown: release a pending probe without judging the provider
+	if err != nil && errors.Is(err, context.Canceled) {
+		b.probing = false
+		return
+	}
+
+	if err == nil {
+		if !b.openUntil.IsZero() {
+			log.Printf("Circuit breaker for %s closed", b.name)
+		}
+		b.failures = 0
+		b.openUntil = time.Time{}
+		b.probing = false
+		return
+	}
+
+	if !errorKind(err).providerFailure() {
+		if b.probing {
+			// The provider answered, so it is up
+			b.failures = 0
+			b.openUntil = time.Time{}
+			b.probing = false
+		}
+		return
+	}
+
+	b.failures++
+	if b.probing || b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		b.probing = false
+		log.Printf("Circuit breaker for %s open for %v after %d failures (last: %v)", b.name, b.cooldown, b.failures, err)
+	}
+}
+
+// Open reports whether the breaker is currently rejecting requests
+func (b *CircuitBreaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.openUntil.IsZero() && time.Now().Before(b.openUntil)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func transient() error { return &ProviderError{Kind: ErrTransient, StatusCode: 503} }
+
+func TestBackoffStaysUnderCeiling(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	ceilings := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, ceiling := range ceilings {
+		attempt := i + 1
+		for n := 0; n < 50; n++ {
+			if d := p.backoff(attempt); d < 0 || d > ceiling*time.Millisecond {
+				t.Fatalf("attempt %d: backoff %v outside [0, %v]", attempt, d, ceiling*time.Millisecond)
+			}
+		}
+	}
+	if d := (RetryPolicy{}).backoff(3); d != 0 {
+		t.Errorf("zero policy: backoff %v, want 0", d)
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	notFound := &ProviderError{Kind: ErrNotFound, StatusCode: 404}
+	unauthorized := &ProviderError{Kind: ErrUnauthorized, StatusCode: 401}
+
+	tests := []struct {
+		name      string
+		results   []error // returned by successive attempts; nil after they run out
+		attempts  int
+		wantCalls int
+		wantErr   error
+	}{
+		{"success", nil, 3, 1, nil},
+		{"transient then success", []error{transient(), transient()}, 3, 3, nil},
+		{"attempts run out", []error{transient(), transient(), transient()}, 3, 3, transient()},
+		{"rate limited is retried", []error{&ProviderError{Kind: ErrRateLimited, StatusCode: 429}}, 3, 2, nil},
+		{"not found fails fast", []error{notFound}, 3, 1, notFound},
+		{"unauthorized fails fast", []error{unauthorized}, 3, 1, unauthorized},
+		{"plain errors fail fast", []error{errors.New("boom")}, 3, 1, errors.New("boom")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := RetryPolicy{MaxAttempts: tt.attempts, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+			calls := 0
+			err := p.Do(context.Background(), nil, nil, func() error {
+				calls++
+				if calls <= len(tt.results) {
+					return tt.results[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("%d calls, want %d", calls, tt.wantCalls)
+			}
+			if (err == nil) != (tt.wantErr == nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Errorf("err %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryTakesATokenPerAttempt(t *testing.T) {
+	limiter := NewTokenBucket(0.001, 3)
+	p := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	p.Do(context.Background(), limiter, nil, transient)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.Wait(cancelled); err == nil {
+		t.Error("3 attempts left a token of a burst of 3")
+	}
+
+	// Out of tokens, the next attempt waits and gives up with the context
+	calls := 0
+	err := p.Do(cancelled, limiter, nil, func() error { calls++; return nil })
+	if calls != 0 || !errors.Is(err, context.Canceled) {
+		t.Errorf("calls=%d err=%v, want no call and context.Canceled", calls, err)
+	}
+}
+
+func TestRetryAfterIsCapped(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	calls := 0
+	start := time.Now()
+	p.Do(context.Background(), nil, nil, func() error {
+		calls++
+		if calls == 1 {
+			return &ProviderError{Kind: ErrRateLimited, StatusCode: 429, RetryAfter: time.Hour}
+		}
+		return nil
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Retry-After of 1h waited %v, want at most MaxDelay", elapsed)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := &CircuitBreaker{name: "test", threshold: 2, cooldown: 20 * time.Millisecond}
+
+	// Not-found and parse errors say nothing about the provider
+	for i := 0; i < 5; i++ {
+		b.Record(&ProviderError{Kind: ErrNotFound})
+		b.Record(&ProviderError{Kind: ErrParse})
+	}
+	if b.Open() {
+		t.Fatal("opened on not-found and parse errors")
+	}
+
+	b.Record(transient())
+	b.Record(&ProviderError{Kind: ErrUnauthorized, StatusCode: 403})
+	if !b.Open() || b.Allow() {
+		t.Fatal("still closed after reaching the threshold")
+	}
+
+	// The Do loop stops without calling fn while open
+	p := RetryPolicy{MaxAttempts: 3}
+	calls := 0
+	if err := p.Do(context.Background(), nil, b, func() error { calls++; return nil }); !errors.Is(err, ErrCircuitOpen) || calls != 0 {
+		t.Errorf("open breaker: err=%v calls=%d, want ErrCircuitOpen and no call", err, calls)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("no probe after the cooldown")
+	}
+	if b.Allow() {
+		t.Fatal("a second request allowed while probing")
+	}
+	b.Record(transient())
+	if !b.Open() {
+		t.Fatal("a failed probe didn't reopen the breaker")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("no probe after the second cooldown")
+	}
+	b.Record(nil)
+	if b.Open() || !b.Allow() || !b.Allow() {
+		t.Error("a successful probe didn't close the breaker")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Quarantine reasons
+const (
+	ReasonNonPositive = "NON_POSITIVE"
+	ReasonLargeMove   = "LARGE_MOVE"
+	ReasonStale       = "STALE"
+)
+
+// SanityChecker rejects quotes that are likely provider glitches before they
+// reach currentPrice, where they would fire alerts and resolve predictions
+type SanityChecker struct {
+	MaxMovePercent float64       // vs the last accepted price; 0 disables
+	MoveWindow     time.Duration // MaxMovePercent applies within this, longer gaps allow more
+	MaxQuoteAge    time.Duration // 0 disables
+	ReleaseAfter   int           // consistent LARGE_MOVE quotes that release the quarantine; 0 never
+}
+
+func loadSanityChecker() *SanityChecker {
+	return &SanityChecker{
+		MaxMovePercent: envFloat("PRICE_MAX_MOVE_PERCENT", 20),
+		MoveWindow:     envDuration("PRICE_MAX_MOVE_WINDOW", time.Hour),
+		MaxQuoteAge:    envDuration("PRICE_MAX_QUOTE_AGE", 0),
+		ReleaseAfter:   envInt("PRICE_QUARANTINE_RELEASE", 3),
+	}
+}
+
+// maxMove is the largest move accepted after elapsed without an accepted
+// quote. Beyond MoveWindow it grows with the square root of the gap, as
+// price swings do, so a stock that was halted or closed over a weekend can
+// reopen at a different level.
+func (c *SanityChecker) maxMove(elapsed time.Duration) float64 {
+	if c.MoveWindow <= 0 || elapsed <= c.MoveWindow {
+		return c.MaxMovePercent
+	}
+	return c.MaxMovePercent * math.Sqrt(float64(elapsed)/float64(c.MoveWindow))
+}
+
+// consistent reports whether two rejected quotes agree, suggesting the
+// price really moved rather than the provider glitching
+func (c *SanityChecker) consistent(price, previous float64) bool {
+	return previous > 0 && math.Abs(price-previous)/previous*100 <= c.MaxMovePercent
+}
+
+// Check returns the quarantine reason for q, or "" if it looks sane
+func (c *SanityChecker) Check(s Stock, q Quote, now time.Time) string {
+	if q.Price <= 0 || math.IsNaN(q.Price) || math.IsInf(q.Price, 0) {
+		return ReasonNonPositive
+	}
+
+	if c.MaxQuoteAge > 0 && now.Sub(q.Timestamp) > c.MaxQuoteAge {
+		return ReasonStale
+	}
+
+	// currentPrice only ever holds accepted quotes
+	if c.MaxMovePercent > 0 && s.CurrentPrice > 0 {
+		limit := c.MaxMovePercent
+		if !s.PriceUpdatedAt.IsZero() {
+			limit = c.maxMove(now.Sub(s.PriceUpdatedAt))
+		}
+		move := math.Abs(q.Price-s.CurrentPrice) / s.CurrentPrice * 100
+		if move > limit {
+			return ReasonLargeMove
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSanityCheck(t *testing.T) {
+	now := time.Date(2024, 3, 4, 15, 0, 0, 0, time.UTC)
+	c := &SanityChecker{MaxMovePercent: 20, MoveWindow: time.Hour, MaxQuoteAge: 15 * time.Minute}
+
+	stock := func(price float64, since time.Duration) Stock {
+		s := Stock{Symbol: "AAPL", CurrentPrice: price}
+		if since > 0 {
+			s.PriceUpdatedAt = now.Add(-since)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name  string
+		c     *SanityChecker
+		stock Stock
+		price float64
+		age   time.Duration
+		want  string
+	}{
+		{"ordinary move", c, stock(100, time.Minute), 105, 0, ""},
+		{"move at the limit", c, stock(100, time.Minute), 120, 0, ""},
+		{"zero price", c, stock(100, time.Minute), 0, 0, ReasonNonPositive},
+		{"negative price", c, stock(100, time.Minute), -5, 0, ReasonNonPositive},
+		{"NaN", c, stock(100, time.Minute), math.NaN(), 0, ReasonNonPositive},
+		{"stale quote", c, stock(100, time.Minute), 101, 20 * time.Minute, ReasonStale},
+		{"large move", c, stock(100, time.Minute), 150, 0, ReasonLargeMove},
+		{"large drop", c, stock(100, time.Minute), 70, 0, ReasonLargeMove},
+		// 64h since Friday's close allows 20% × √64 = 160%
+		{"large move after a weekend", c, stock(100, 64*time.Hour), 150, 0, ""},
+		{"no accepted quote time keeps the fixed limit", c, stock(100, 0), 150, 0, ReasonLargeMove},
+		{"first price is always accepted", c, stock(0, 0), 5000, 0, ""},
+		{"zero limit disables the move check", &SanityChecker{}, stock(100, time.Minute), 500, time.Hour, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := Quote{Symbol: "AAPL", Price: tt.price, Timestamp: now.Add(-tt.age)}
+			if got := tt.c.Check(tt.stock, q, now); got != tt.want {
+				t.Errorf("Check(%.0f -> %v) = %q, want %q", tt.stock.CurrentPrice, tt.price, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxMoveGrowsWithTheGap(t *testing.T) {
+	c := &SanityChecker{MaxMovePercent: 20, MoveWindow: time.Hour}
+	tests := []struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		{time.Minute, 20},
+		{time.Hour, 20},
+		{4 * time.Hour, 40},
+		{16 * time.Hour, 80},
+	}
+	for _, tt := range tests {
+		if got := c.maxMove(tt.elapsed); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("maxMove(%v) = %g, want %g", tt.elapsed, got, tt.want)
+		}
+	}
+
+	fixed := &SanityChecker{MaxMovePercent: 20}
+	if got := fixed.maxMove(100 * time.Hour); got != 20 {
+		t.Errorf("no window: maxMove = %g, want 20", got)
+	}
+}
+
+func TestConsistentQuotes(t *testing.T) {
+	c := &SanityChecker{MaxMovePercent: 20}
+	tests := []struct {
+		price, previous float64
+		want            bool
+	}{
+		{150, 148, true},
+		{150, 125, true},
+		{150, 100, false},
+		{150, 0, false},
+	}
+	for _, tt := range tests {
+		if got := c.consistent(tt.price, tt.previous); got != tt.want {
+			t.Errorf("consistent(%g, %g) = %v, want %v", tt.price, tt.previous, got, tt.want)
+		}
+	}
+}
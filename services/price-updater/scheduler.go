@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// SchedulerConfig controls the long-running update loop
+type SchedulerConfig struct {
+	Interval        time.Duration
+	MarketHoursOnly bool
+	HolidaysFile    string
+	Prioritize      bool
+}
+
+func loadSchedulerConfig() SchedulerConfig {
+	cfg := SchedulerConfig{
+		Interval:        time.Minute,
+		MarketHoursOnly: true,
+		HolidaysFile:    "holidays.json",
+		Prioritize:      true,
+	}
+
+	if v := os.Getenv("PRICE_UPDATE_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("Invalid PRICE_UPDATE_INTERVAL %q, using %v", v, cfg.Interval)
+		} else {
+			cfg.Interval = d
+		}
+	}
+
+	if v := os.Getenv("MARKET_HOURS_ONLY"); v == "false" || v == "0" {
+		cfg.MarketHoursOnly = false
+	}
+
+	if v := os.Getenv("PRICE_PRIORITY_ENABLED"); v == "false" || v == "0" {
+		cfg.Prioritize = false
+	}
+
+	if v, ok := os.LookupEnv("MARKET_HOLIDAYS_FILE"); ok {
+		cfg.HolidaysFile = v
+	}
+
+	return cfg
+}
+
+// runScheduler runs an update cycle immediately and then on every interval
+// until ctx is cancelled. An in-flight cycle is allowed to wind down before
+// returning.
+func runScheduler(ctx context.Context, updater *Updater, cfg SchedulerConfig) {
+	if cfg.MarketHoursOnly {
+		holidays := cfg.HolidaysFile
+		if _, err := os.Stat(holidays); err != nil {
+			holidays = ""
+		}
+
+		cal, err := NewMarketCalendar(holidays)
+		if err != nil {
+			log.Fatal("Market calendar setup failed:", err)
+		}
+		updater.calendar = cal
+	}
+
+	if cfg.Prioritize {
+		updater.priority = NewPriority(updater.stocks.Database(), loadPriorityConfig())
+	}
+
+	fmt.Printf("Price Updater started. Refreshing every %v (market hours only: %v, prioritized: %v)\n", cfg.Interval, cfg.MarketHoursOnly, cfg.Prioritize)
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	updater.runCycle(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Shutdown signal received, stopping Price Updater.")
+			return
+		case <-ticker.C:
+			updater.runCycle(ctx)
+		}
+	}
+}
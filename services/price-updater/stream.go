@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// StreamConfig controls the streaming update loop
+type StreamConfig struct {
+	FlushInterval time.Duration // how often coalesced ticks are written
+	SyncInterval  time.Duration // how often subscriptions are reconciled with stocks
+	StatsInterval time.Duration // how often a summary is logged
+}
+
+func loadStreamConfig() StreamConfig {
+	return StreamConfig{
+		FlushInterval: envDuration("PRICE_STREAM_FLUSH", time.Second),
+		SyncInterval:  envDuration("PRICE_STREAM_SYNC", 30*time.Second),
+		StatsInterval: envDuration("PRICE_STREAM_STATS", time.Minute),
+	}
+}
+
+// StreamStats accumulates between summary log lines
+type StreamStats struct {
+	Ticks     int
+	Coalesced int
+	Flushes   int
+	Written   int
+	Rejected  int
+}
+
+func (s StreamStats) String() string {
+	return fmt.Sprintf("ticks=%d coalesced=%d flushes=%d written=%d rejected=%d",
+		s.Ticks, s.Coalesced, s.Flushes, s.Written, s.Rejected)
+}
+
+// Streamer applies quotes pushed by a StreamingProvider. Ticks are coalesced
+// per symbol (the latest one wins) and flushed on a short interval through
+// the same sanity checks, BulkWrite and history path as polled quotes.
+type Streamer struct {
+	updater  *Updater
+	provider StreamingProvider
+	cfg      StreamConfig
+
+	mu      sync.Mutex
+	pending map[string]Quote
+	stats   StreamStats
+
+	subscribed map[string]bool // owned by the sync loop
+}
+
+func NewStreamer(updater *Updater, provider StreamingProvider, cfg StreamConfig) *Streamer {
+	updater.quiet = true // one line per tick would flood the logs
+
+	return &Streamer{
+		updater:    updater,
+		provider:   provider,
+		cfg:        cfg,
+		pending:    make(map[string]Quote),
+		subscribed: make(map[string]bool),
+	}
+}
+
+// Run streams until ctx is cancelled, then flushes whatever is pending
+func (s *Streamer) Run(ctx context.Context) {
+	fmt.Printf("Price Updater streaming from %s. Flushing every %v\n", s.provider.Name(), s.cfg.FlushInterval)
+
+	s.sync(ctx)
+
+	ticks := make(chan Quote, 1024)
+	streamDone := make(chan struct{})
+	go func() {
+		defer close(streamDone)
+		if err := s.provider.Stream(ctx, ticks); err != nil && ctx.Err() == nil {
+			log.Printf("Stream stopped: %v", err)
+		}
+	}()
+
+	flush := time.NewTicker(s.cfg.FlushInterval)
+	defer flush.Stop()
+	syncTicker := time.NewTicker(s.cfg.SyncInterval)
+	defer syncTicker.Stop()
+	statsTicker := time.NewTicker(s.cfg.StatsInterval)
+	defer statsTicker.Stop()
+
+	for {
+		select {
+		case q := <-ticks:
+			s.add(q)
+		case <-flush.C:
+			s.flush(ctx)
+		case <-syncTicker.C:
+			s.sync(ctx)
+		case <-statsTicker.C:
+			s.mu.Lock()
+			stats := s.stats
+			s.stats = StreamStats{}
+			s.mu.Unlock()
+			fmt.Printf("Stream: %s\n", stats)
+		case <-ctx.Done():
+			<-streamDone
+			fmt.Println("Shutdown signal received, flushing pending quotes.")
+			s.flush(context.Background())
+			return
+		}
+	}
+}
+
+// add coalesces a tick into the pending set
+func (s *Streamer) add(q Quote) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.Ticks++
+	if prev, ok := s.pending[q.Symbol]; ok {
+		s.stats.Coalesced++
+		if q.Timestamp.Before(prev.Timestamp) {
+			return // out of order, keep the newer quote
+		}
+	}
+	s.pending[q.Symbol] = q
+}
+
+// flush writes the pending quotes. Stocks are re-read for just the pending
+// symbols so the sanity checks compare against the stored price.
+func (s *Streamer) flush(ctx context.Context) {
+	s.mu.Lock()
+	quotes := s.pending
+	s.pending = make(map[string]Quote)
+	s.mu.Unlock()
+
+	if len(quotes) == 0 {
+		return
+	}
+
+	symbols := make([]string, 0, len(quotes))
+	for symbol := range quotes {
+		symbols = append(symbols, symbol)
+	}
+
+	// The flush must finish even during shutdown
+	loadCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cursor, err := s.updater.stocks.Find(loadCtx, bson.M{"symbol": bson.M{"$in": symbols}})
+	if err != nil {
+		log.Printf("Failed to load stocks for flush: %v", err)
+		return
+	}
+	var stocks []Stock
+	if err := cursor.All(loadCtx, &stocks); err != nil {
+		log.Printf("Failed to decode stocks for flush: %v", err)
+		return
+	}
+
+	var cycle CycleStats
+	quotes = s.updater.screenQuotes(stocks, quotes, &cycle)
+	written := s.updater.applyQuotes(ctx, stocks, quotes)
+
+	s.mu.Lock()
+	s.stats.Flushes++
+	s.stats.Written += written
+	s.stats.Rejected += cycle.Rejected
+	s.mu.Unlock()
+}
+
+// sync reconciles the provider's subscriptions with the stocks collection
+// and applies due corporate actions
+func (s *Streamer) sync(ctx context.Context) {
+	if s.updater.actions != nil {
+		if err := s.updater.actions.ApplyDue(ctx); err != nil {
+			log.Printf("Failed to apply corporate actions: %v", err)
+		}
+	}
+
+	symbols, err := allSymbols(ctx, s.updater.stocks)
+	if err != nil {
+		log.Printf("Failed to load symbols for subscription sync: %v", err)
+		return
+	}
+
+	wanted := make(map[string]bool, len(symbols))
+	var added, removed []string
+	for _, symbol := range symbols {
+		wanted[symbol] = true
+		if !s.subscribed[symbol] {
+			added = append(added, symbol)
+		}
+	}
+	for symbol := range s.subscribed {
+		if !wanted[symbol] {
+			removed = append(removed, symbol)
+		}
+	}
+
+	if err := s.provider.Subscribe(added); err != nil {
+		log.Printf("Failed to subscribe to %v: %v", added, err)
+	}
+	if err := s.provider.Unsubscribe(removed); err != nil {
+		log.Printf("Failed to unsubscribe from %v: %v", removed, err)
+	}
+
+	// The provider remembers the set even if sending failed and resubscribes
+	// on reconnect, so local state follows the wanted set either way
+	s.subscribed = wanted
+
+	if len(added) > 0 || len(removed) > 0 {
+		fmt.Printf("Subscriptions: %d symbols (+%d -%d)\n", len(wanted), len(added), len(removed))
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// runMockStream serves a local WebSocket quote feed speaking the same
+// protocol as WSProvider, for development without a market data vendor:
+//
+//	mockstream [-addr :8765] [-interval 250ms] [-file quotes.csv]
+//
+// Subscribed symbols get a random walk starting at 100, or replay the given
+// quote file through a FileProvider.
+func runMockStream(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("mockstream", flag.ContinueOnError)
+	addr := fs.String("addr", ":8765", "listen address")
+	interval := fs.Duration("interval", 250*time.Millisecond, "time between ticks per connection")
+	file := fs.String("file", "", "replay quotes from a .csv or .json file instead of a random walk")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var replay *FileProvider
+	if *file != "" {
+		var err error
+		if replay, err = NewFileProvider(*file); err != nil {
+			return err
+		}
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	walk := &randomWalk{prices: make(map[string]float64)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		log.Printf("mockstream: client %s connected", r.RemoteAddr)
+
+		var mu sync.Mutex
+		symbols := make(map[string]bool)
+
+		// Reader: apply subscription commands until the client goes away
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				var cmd wsCommand
+				if err := conn.ReadJSON(&cmd); err != nil {
+					return
+				}
+				mu.Lock()
+				for _, s := range cmd.Symbols {
+					s = strings.ToUpper(s)
+					switch cmd.Action {
+					case "subscribe":
+						symbols[s] = true
+					case "unsubscribe":
+						delete(symbols, s)
+					}
+				}
+				mu.Unlock()
+				log.Printf("mockstream: %s %v", cmd.Action, cmd.Symbols)
+			}
+		}()
+
+		ticker := time.NewTicker(*interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closed:
+				log.Printf("mockstream: client %s disconnected", r.RemoteAddr)
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			mu.Lock()
+			var batch []quoteJSON
+			for s := range symbols {
+				if replay != nil {
+					q, err := replay.FetchQuote(ctx, s)
+					if err != nil {
+						continue
+					}
+					batch = append(batch, quoteJSON{Symbol: s, Price: q.Price, Timestamp: time.Now().UTC(), Volume: q.Volume})
+					continue
+				}
+				batch = append(batch, quoteJSON{Symbol: s, Price: walk.next(s), Timestamp: time.Now().UTC()})
+			}
+			mu.Unlock()
+
+			if len(batch) == 0 {
+				continue
+			}
+			data, _ := json.Marshal(batch)
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		}
+	})
+
+	server := &http.Server{Addr: *addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	host := *addr
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+	fmt.Printf("Mock quote stream listening on ws://%s\n", host)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// randomWalk moves each symbol by up to ±0.5% per tick
+type randomWalk struct {
+	mu     sync.Mutex
+	prices map[string]float64
+}
+
+func (w *randomWalk) next(symbol string) float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	price, ok := w.prices[symbol]
+	if !ok {
+		price = 100
+	}
+	price *= 1 + (rand.Float64()-0.5)/100
+	price = math.Round(price*100) / 100
+	w.prices[symbol] = price
+	return price
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// activeStocks matches stocks that are still refreshed. Stocks created
+// before the isActive flag existed count as active.
+var activeStocks = bson.M{"isActive": bson.M{"$ne": false}}
+
+// Constituent is one row of an index constituent file
+type Constituent struct {
+	Symbol      string `json:"symbol"`
+	Name        string `json:"name"`
+	Sector      string `json:"sector"`
+	Industry    string `json:"industry"`
+	Description string `json:"description"`
+	Website     string `json:"website"`
+}
+
+// constituentColumns maps the header names found in common constituent
+// exports (e.g. the Wikipedia S&P 500 table) onto Constituent fields
+var constituentColumns = map[string]string{
+	"symbol":            "symbol",
+	"ticker":            "symbol",
+	"name":              "name",
+	"security":          "name",
+	"company":           "name",
+	"sector":            "sector",
+	"gics sector":       "sector",
+	"industry":          "industry",
+	"gics sub-industry": "industry",
+	"sub-industry":      "industry",
+	"description":       "description",
+	"website":           "website",
+}
+
+// loadConstituents reads a .csv or .json constituent file. Only the symbol
+// is required.
+func loadConstituents(path string) ([]Constituent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows []Constituent
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.NewDecoder(f).Decode(&rows)
+	case ".csv":
+		rows, err = parseConstituentCSV(f)
+	default:
+		return nil, fmt.Errorf("unsupported constituent file %s (want .csv or .json)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool, len(rows))
+	list := rows[:0]
+	for _, r := range rows {
+		r.Symbol = strings.ToUpper(strings.TrimSpace(r.Symbol))
+		if r.Symbol == "" || seen[r.Symbol] {
+			continue
+		}
+		seen[r.Symbol] = true
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+func parseConstituentCSV(r io.Reader) ([]Constituent, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		if field, ok := constituentColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			if _, dup := columns[field]; !dup {
+				columns[field] = i
+			}
+		}
+	}
+	if _, ok := columns["symbol"]; !ok {
+		return nil, fmt.Errorf("missing symbol column")
+	}
+
+	list := make([]Constituent, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		field := func(name string) string {
+			if idx, ok := columns[name]; ok && idx < len(row) {
+				return strings.TrimSpace(row[idx])
+			}
+			return ""
+		}
+		list = append(list, Constituent{
+			Symbol:      field("symbol"),
+			Name:        field("name"),
+			Sector:      field("sector"),
+			Industry:    field("industry"),
+			Description: field("description"),
+			Website:     field("website"),
+		})
+	}
+	return list, nil
+}
+
+// UniversePlan is the set of changes a sync will make
+type UniversePlan struct {
+	Create  []Constituent
+	Update  []Constituent
+	Remove  []string // leave this universe but stay in another one
+	Delist  []string // leave their last universe and become inactive
+	Members int      // stocks in the universe before the sync
+}
+
+type universeStock struct {
+	Symbol    string   `bson:"symbol"`
+	Universes []string `bson:"universes"`
+}
+
+// planUniverse compares a constituent list with the stored stocks. Stocks
+// are only ever delisted by the universe they were synced from; stocks
+// added by hand (no universes) are never touched.
+func planUniverse(name string, constituents []Constituent, stocks []universeStock) UniversePlan {
+	var plan UniversePlan
+
+	existing := make(map[string]universeStock, len(stocks))
+	for _, s := range stocks {
+		existing[s.Symbol] = s
+	}
+
+	wanted := make(map[string]bool, len(constituents))
+	for _, c := range constituents {
+		wanted[c.Symbol] = true
+		if _, ok := existing[c.Symbol]; ok {
+			plan.Update = append(plan.Update, c)
+		} else {
+			plan.Create = append(plan.Create, c)
+		}
+	}
+
+	for _, s := range stocks {
+		if !contains(s.Universes, name) {
+			continue
+		}
+		plan.Members++
+		if wanted[s.Symbol] {
+			continue
+		}
+		if len(s.Universes) > 1 {
+			plan.Remove = append(plan.Remove, s.Symbol)
+		} else {
+			plan.Delist = append(plan.Delist, s.Symbol)
+		}
+	}
+
+	sort.Strings(plan.Remove)
+	sort.Strings(plan.Delist)
+	return plan
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// runUniverse implements:
+//
+//	universe sync -file sp500.csv [-name sp500] [-dry-run] [-force] [-max-delist 10]
+func runUniverse(ctx context.Context, db *mongo.Database, provider PriceProvider, args []string) error {
+	if len(args) == 0 || args[0] != "sync" {
+		return fmt.Errorf("usage: universe sync -file constituents.csv [-name sp500] [-dry-run]")
+	}
+
+	fs := flag.NewFlagSet("universe sync", flag.ContinueOnError)
+	file := fs.String("file", "", "constituent list (.csv or .json)")
+	name := fs.String("name", "", "universe name, defaults to the file name (e.g. sp500)")
+	dryRun := fs.Bool("dry-run", false, "print the changes without writing them")
+	force := fs.Bool("force", false, "apply even if more than -max-delist percent would be delisted")
+	maxDelist := fs.Float64("max-delist", 10, "refuse to delist more than this percent of the universe")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *file == "" {
+		return fmt.Errorf("-file is required")
+	}
+	if *name == "" {
+		*name = strings.ToLower(strings.TrimSuffix(filepath.Base(*file), filepath.Ext(*file)))
+	}
+
+	constituents, err := loadConstituents(*file)
+	if err != nil {
+		return err
+	}
+	if len(constituents) == 0 {
+		return fmt.Errorf("%s lists no symbols", *file)
+	}
+
+	stocks := db.Collection("stocks")
+	cursor, err := stocks.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"symbol": 1, "universes": 1}))
+	if err != nil {
+		return err
+	}
+	var existing []universeStock
+	if err := cursor.All(ctx, &existing); err != nil {
+		return err
+	}
+
+	plan := planUniverse(*name, constituents, existing)
+	fmt.Printf("Universe %s: %d constituents, %d new, %d updated, %d removed, %d delisted\n",
+		*name, len(constituents), len(plan.Create), len(plan.Update), len(plan.Remove), len(plan.Delist))
+	for _, c := range plan.Create {
+		fmt.Printf("+ %s %s\n", c.Symbol, c.Name)
+	}
+	for _, s := range plan.Delist {
+		fmt.Printf("- %s (inactive)\n", s)
+	}
+
+	// A truncated or wrong file would otherwise silently switch off most of
+	// the universe
+	if plan.Members > 0 && !*force {
+		percent := float64(len(plan.Delist)+len(plan.Remove)) / float64(plan.Members) * 100
+		if percent > *maxDelist {
+			return fmt.Errorf("sync would drop %.0f%% of %s (limit %.0f%%), check the file or pass -force", percent, *name, *maxDelist)
+		}
+	}
+
+	if *dryRun {
+		return nil
+	}
+
+	now := time.Now()
+	var models []mongo.WriteModel
+
+	for _, c := range plan.Update {
+		set := bson.M{"isActive": true, "updatedAt": now}
+		metadata := map[string]string{
+			"name":        c.Name,
+			"sector":      c.Sector,
+			"industry":    c.Industry,
+			"description": c.Description,
+			"website":     c.Website,
+		}
+		for field, v := range metadata {
+			if v != "" {
+				set[field] = v
+			}
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"symbol": c.Symbol}).
+			SetUpdate(bson.M{
+				"$set":      set,
+				"$unset":    bson.M{"delistedAt": ""},
+				"$addToSet": bson.M{"universes": *name},
+			}))
+	}
+
+	for _, symbol := range plan.Remove {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"symbol": symbol}).
+			SetUpdate(bson.M{"$pull": bson.M{"universes": *name}, "$set": bson.M{"updatedAt": now}}))
+	}
+
+	for _, symbol := range plan.Delist {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"symbol": symbol}).
+			SetUpdate(bson.M{
+				"$pull": bson.M{"universes": *name},
+				"$set":  bson.M{"isActive": false, "delistedAt": now, "updatedAt": now},
+			}))
+	}
+
+	if len(plan.Create) > 0 {
+		docs, err := newStockDocs(ctx, db, provider, plan.Create, *name, now)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			models = append(models, mongo.NewInsertOneModel().SetDocument(doc))
+		}
+	}
+
+	if len(models) == 0 {
+		return nil
+	}
+
+	result, err := stocks.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Universe %s synced: %d created, %d modified\n", *name, result.InsertedCount, result.ModifiedCount)
+	return nil
+}
+
+// newStockDocs builds Stock documents for new constituents, priced with a
+// first quote where the provider has one. Stocks that couldn't be quoted
+// start at 0 and are filled in by the next update cycle.
+func newStockDocs(ctx context.Context, db *mongo.Database, provider PriceProvider, list []Constituent, universe string, now time.Time) ([]bson.M, error) {
+	stocks := make([]Stock, len(list))
+	for i, c := range list {
+		stocks[i] = Stock{Symbol: c.Symbol}
+	}
+
+	var quotes map[string]Quote
+	if _, streaming := provider.(StreamingProvider); !streaming {
+		fmt.Printf("Fetching first quotes for %d new symbols...\n", len(list))
+		var stats CycleStats
+		quotes = NewUpdater(db, provider).fetchQuotes(ctx, stocks, &stats)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	docs := make([]bson.M, 0, len(list))
+	for i, c := range list {
+		doc := bson.M{
+			"symbol":         c.Symbol,
+			"name":           orDefault(c.Name, c.Symbol),
+			"sector":         orDefault(c.Sector, "Other"),
+			"industry":       orDefault(c.Industry, "General"),
+			"description":    c.Description,
+			"website":        c.Website,
+			"currentPrice":   0.0,
+			"previousClose":  0.0,
+			"change":         0.0,
+			"changePercent":  0.0,
+			"volume":         0.0,
+			"marketCap":      0.0,
+			"high24h":        0.0,
+			"low24h":         0.0,
+			"sentimentScore": 50,
+			"sentimentLabel": "Neutral",
+			"isActive":       true,
+			"universes":      []string{universe},
+			"createdAt":      now,
+			"updatedAt":      now,
+		}
+
+		if q, ok := quotes[c.Symbol]; ok && q.Price > 0 {
+			for field, v := range priceUpdate(stocks[i], q, now) {
+				doc[field] = v
+			}
+			if doc["previousClose"] == 0.0 {
+				doc["previousClose"] = q.Price
+			}
+		} else {
+			fmt.Printf("! No quote for %s yet, created with price 0\n", c.Symbol)
+		}
+
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func orDefault(v, fallback string) string {
+	if v == "" {
+		return fallback
+	}
+	return v
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Updater owns everything needed to run one refresh cycle
+type Updater struct {
+	stocks      *mongo.Collection
+	provider    PriceProvider
+	limiter     *TokenBucket
+	calendar    *MarketCalendar   // nil refreshes regardless of market hours
+	history     *HistoryStore     // nil disables price history
+	actions     *CorporateActions // nil skips corporate actions
+	publisher   *RedisPublisher   // nil disables tick publishing
+	fx          *FXRates
+	priority    *Priority // nil refreshes every stock every cycle
+	sanity      *SanityChecker
+	quarantine  *mongo.Collection
+	retry       RetryPolicy
+	breaker     *CircuitBreaker
+	batchSize   int
+	concurrency int
+	quiet       bool // skip the per-stock log lines
+}
+
+// CycleStats summarises a single refresh cycle for logging
+type CycleStats struct {
+	Stocks    int
+	Deferred  int // cold or warm stocks not due this cycle
+	Requests  int
+	Fetched   int
+	Failed    int
+	Written   int
+	Rejected  int
+	Errors    map[ErrorKind]int
+	Paused    bool // the circuit breaker cut the cycle short
+	FetchTime time.Duration
+	WriteTime time.Duration
+	Total     time.Duration
+}
+
+func (s CycleStats) String() string {
+	out := fmt.Sprintf("stocks=%d requests=%d fetched=%d failed=%d quarantined=%d written=%d fetch=%v write=%v total=%v",
+		s.Stocks, s.Requests, s.Fetched, s.Failed, s.Rejected, s.Written,
+		s.FetchTime.Round(time.Millisecond), s.WriteTime.Round(time.Millisecond), s.Total.Round(time.Millisecond))
+	for _, kind := range []ErrorKind{ErrTransient, ErrRateLimited, ErrUnauthorized, ErrNotFound, ErrParse} {
+		if n := s.Errors[kind]; n > 0 {
+			out += fmt.Sprintf(" %s=%d", kind, n)
+		}
+	}
+	if s.Deferred > 0 {
+		out += fmt.Sprintf(" deferred=%d", s.Deferred)
+	}
+	if s.Paused {
+		out += " paused=true"
+	}
+	return out
+}
+
+// NewUpdater reads rate limiting and batching settings from the environment:
+// PRICE_RATE_LIMIT (requests/sec), PRICE_RATE_BURST, PRICE_BATCH_SIZE and
+// PRICE_CONCURRENCY. Retry and breaker settings are read by loadRetryPolicy
+// and NewCircuitBreaker.
+func NewUpdater(db *mongo.Database, provider PriceProvider) *Updater {
+	rate := envFloat("PRICE_RATE_LIMIT", 0.5) // Be polite to free APIs
+	burst := envInt("PRICE_RATE_BURST", 1)
+
+	batchSize := 1
+	if bp, ok := provider.(BatchPriceProvider); ok {
+		batchSize = envInt("PRICE_BATCH_SIZE", bp.MaxBatchSize())
+		if batchSize > bp.MaxBatchSize() {
+			batchSize = bp.MaxBatchSize()
+		}
+	}
+
+	limiter := NewTokenBucket(rate, burst)
+	retry := loadRetryPolicy()
+	breaker := NewCircuitBreaker(provider.Name())
+
+	return &Updater{
+		stocks:      db.Collection("stocks"),
+		quarantine:  db.Collection(quarantineCollection),
+		sanity:      loadSanityChecker(),
+		provider:    provider,
+		limiter:     limiter,
+		retry:       retry,
+		breaker:     breaker,
+		fx:          NewFXRates(provider, limiter, retry, breaker),
+		batchSize:   batchSize,
+		concurrency: envInt("PRICE_CONCURRENCY", 4),
+	}
+}
+
+// runCycle refreshes every stock once. Quotes are fetched in batches under
+// the rate limiter and written back with a single BulkWrite.
+func (u *Updater) runCycle(ctx context.Context) CycleStats {
+	var stats CycleStats
+	startTime := time.Now()
+
+	// Apply splits before loading stocks so the stored prices the sanity
+	// check compares against are already adjusted
+	if u.actions != nil {
+		if err := u.actions.ApplyDue(ctx); err != nil {
+			log.Printf("Failed to apply corporate actions: %v", err)
+		}
+	}
+
+	// 1. Fetch all active stocks
+	cursor, err := u.stocks.Find(ctx, activeStocks)
+	if err != nil {
+		log.Printf("Failed to load stocks: %v", err)
+		return stats
+	}
+	defer cursor.Close(context.Background())
+
+	var stocks []Stock
+	if err = cursor.All(ctx, &stocks); err != nil {
+		log.Printf("Failed to decode stocks: %v", err)
+		return stats
+	}
+
+	if u.calendar != nil {
+		stocks = u.calendar.FilterOpen(stocks, time.Now())
+	}
+	if u.priority != nil {
+		stocks, stats.Deferred = u.priority.Select(ctx, stocks)
+	}
+	stats.Stocks = len(stocks)
+
+	if u.breaker.Open() {
+		fmt.Printf("Provider %s is unavailable (circuit open), skipping cycle\n", u.provider.Name())
+		stats.Paused = true
+		return stats
+	}
+
+	fmt.Printf("Found %d stocks to update...\n", len(stocks))
+
+	// 2. Fetch quotes
+	fetchStart := time.Now()
+	quotes := u.fetchQuotes(ctx, stocks, &stats)
+	stats.FetchTime = time.Since(fetchStart)
+	stats.Fetched = len(quotes)
+	stats.Failed = len(stocks) - len(quotes)
+
+	// 3. Hold back suspicious quotes for review
+	quotes = u.screenQuotes(stocks, quotes, &stats)
+
+	// 4. Write the rest back and announce them
+	writeStart := time.Now()
+	stats.Written = u.applyQuotes(ctx, stocks, quotes)
+	stats.WriteTime = time.Since(writeStart)
+
+	stats.Total = time.Since(startTime)
+	fmt.Printf("Cycle complete: %s\n", stats)
+	return stats
+}
+
+// fetchQuotes splits the stocks into provider-sized batches and fetches them
+// with a small worker pool. Every request waits on the shared rate limiter
+// and is retried per the retry policy. If the circuit breaker opens, the
+// remaining batches are abandoned until the next cycle.
+func (u *Updater) fetchQuotes(ctx context.Context, stocks []Stock, stats *CycleStats) map[string]Quote {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	batches := make(chan []string)
+	go func() {
+		defer close(batches)
+		for i := 0; i < len(stocks); i += u.batchSize {
+			end := i + u.batchSize
+			if end > len(stocks) {
+				end = len(stocks)
+			}
+
+			symbols := make([]string, 0, end-i)
+			for _, s := range stocks[i:end] {
+				symbols = append(symbols, s.Symbol)
+			}
+
+			select {
+			case batches <- symbols:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		quotes = make(map[string]Quote, len(stocks))
+	)
+	stats.Errors = make(map[ErrorKind]int)
+
+	for i := 0; i < u.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for symbols := range batches {
+				var result map[string]Quote
+				err := u.retry.Do(ctx, u.limiter, u.breaker, func() error {
+					var err error
+					result, err = u.fetchBatch(ctx, symbols)
+					return err
+				})
+				if result == nil && ctx.Err() != nil {
+					// Cancelled while waiting for a token
+					return
+				}
+
+				mu.Lock()
+				stats.Requests++
+				for symbol, q := range result {
+					quotes[symbol] = q
+				}
+				if err != nil && !errors.Is(err, context.Canceled) {
+					if errors.Is(err, ErrCircuitOpen) {
+						stats.Paused = true
+					} else {
+						stats.Errors[errorKind(err)]++
+					}
+				}
+				mu.Unlock()
+
+				if errors.Is(err, ErrCircuitOpen) {
+					log.Printf("Provider %s is unavailable (circuit open), pausing cycle", u.provider.Name())
+					cancel()
+					return
+				}
+				if err != nil && !errors.Is(err, context.Canceled) {
+					log.Printf("Failed to fetch %v from %s: %v", symbols, u.provider.Name(), err)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	return quotes
+}
+
+// fetchBatch quotes symbols in a single request where the provider supports
+// it, otherwise one request per symbol.
+func (u *Updater) fetchBatch(ctx context.Context, symbols []string) (map[string]Quote, error) {
+	if bp, ok := u.provider.(BatchPriceProvider); ok && len(symbols) > 1 {
+		return bp.FetchQuotes(ctx, symbols)
+	}
+
+	quotes := make(map[string]Quote, len(symbols))
+	for _, symbol := range symbols {
+		q, err := u.provider.FetchQuote(ctx, symbol)
+		if err != nil {
+			return quotes, err
+		}
+		quotes[symbol] = q
+	}
+	return quotes, nil
+}
+
+// screenQuotes runs every quote through the sanity checks and moves the
+// rejected ones into the quarantine collection instead of applying them.
+// A large move confirmed by sanity.ReleaseAfter consistent quotes in a row
+// is a real one, so the last of them is accepted and the entry released.
+func (u *Updater) screenQuotes(stocks []Stock, quotes map[string]Quote, stats *CycleStats) map[string]Quote {
+	now := time.Now()
+	accepted := make(map[string]Quote, len(quotes))
+	var rejected []QuarantinedQuote
+
+	for _, s := range stocks {
+		q, ok := quotes[s.Symbol]
+		if !ok {
+			continue
+		}
+
+		reason := u.sanity.Check(s, q, now)
+		if reason == "" {
+			accepted[s.Symbol] = q
+			continue
+		}
+
+		rejected = append(rejected, QuarantinedQuote{
+			StockID:        s.ID,
+			Symbol:         s.Symbol,
+			Price:          q.Price,
+			PreviousPrice:  s.CurrentPrice,
+			Reason:         reason,
+			QuoteTimestamp: q.Timestamp,
+			Confirmations:  1,
+		})
+	}
+	if len(rejected) == 0 {
+		return accepted
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var pending map[string]QuarantinedQuote
+	if u.sanity.ReleaseAfter > 0 {
+		symbols := make([]string, len(rejected))
+		for i, r := range rejected {
+			symbols[i] = r.Symbol
+		}
+		var err error
+		if pending, err = pendingQuotes(ctx, u.quarantine, symbols); err != nil {
+			log.Printf("Failed to load quarantined quotes: %v", err)
+		}
+	}
+
+	var held []QuarantinedQuote
+	var released []string
+	for _, r := range rejected {
+		if p, ok := pending[r.Symbol]; ok && r.Reason == ReasonLargeMove && p.Reason == ReasonLargeMove &&
+			u.sanity.consistent(r.Price, p.Price) {
+			r.Confirmations = p.Confirmations + 1
+		}
+		if r.Reason == ReasonLargeMove && u.sanity.ReleaseAfter > 0 && r.Confirmations >= u.sanity.ReleaseAfter {
+			log.Printf("Released %s quote for %s after %d consistent quotes: $%.2f (stored $%.2f)",
+				r.Reason, r.Symbol, r.Confirmations, r.Price, r.PreviousPrice)
+			accepted[r.Symbol] = quotes[r.Symbol]
+			released = append(released, r.Symbol)
+			continue
+		}
+		log.Printf("Quarantined %s quote for %s: $%.2f (stored $%.2f)", r.Reason, r.Symbol, r.Price, r.PreviousPrice)
+		held = append(held, r)
+	}
+	stats.Rejected = len(held)
+
+	if err := quarantineQuotes(ctx, u.quarantine, u.provider.Name(), held); err != nil {
+		log.Printf("Failed to record quarantined quotes: %v", err)
+	}
+	if err := releaseQuotes(ctx, u.quarantine, released); err != nil {
+		log.Printf("Failed to release quarantined quotes: %v", err)
+	}
+
+	return accepted
+}
+
+// applyQuotes takes accepted quotes the rest of the way: converts them to
+// USD, writes them back in one round trip, appends them to price history
+// and lets other services know without polling MongoDB. It returns the
+// number of stocks written.
+func (u *Updater) applyQuotes(ctx context.Context, stocks []Stock, quotes map[string]Quote) int {
+	u.applyFX(ctx, stocks, quotes)
+	written := u.writeQuotes(stocks, quotes)
+	if u.history != nil {
+		u.recordHistory(quotes)
+	}
+	if u.publisher != nil && written > 0 {
+		u.publishQuotes(stocks, quotes)
+	}
+	return written
+}
+
+// writeQuotes applies all fetched prices with one unordered BulkWrite and
+// returns the number of documents modified.
+func (u *Updater) writeQuotes(stocks []Stock, quotes map[string]Quote) int {
+	now := time.Now()
+	var models []mongo.WriteModel
+	var updated []Stock
+
+	for _, s := range stocks {
+		q, ok := quotes[s.Symbol]
+		if !ok {
+			continue
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": s.ID}).
+			SetUpdate(priceWrite(s, q, now)))
+		updated = append(updated, s)
+	}
+
+	if len(models) == 0 {
+		return 0
+	}
+
+	// Use a fresh context so a shutdown signal doesn't discard fetched quotes
+	writeCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := u.stocks.BulkWrite(writeCtx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		log.Printf("Bulk update failed: %v", err)
+		if result == nil {
+			return 0
+		}
+		return int(result.ModifiedCount)
+	}
+
+	if !u.quiet {
+		for _, s := range updated {
+			fmt.Printf("✓ Updated %s: $%.2f -> $%.2f\n", s.Symbol, s.CurrentPrice, quotes[s.Symbol].Price)
+		}
+	}
+	return int(result.ModifiedCount)
+}
+
+func (u *Updater) recordHistory(quotes map[string]Quote) {
+	list := make([]Quote, 0, len(quotes))
+	for _, q := range quotes {
+		list = append(list, q)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := u.history.Record(ctx, list); err != nil {
+		log.Printf("Failed to record price history: %v", err)
+	}
+}
+
+// priceWrite is the stock update for an accepted quote. Without an exchange
+// rate the USD view is removed, rather than left at the previous price's.
+func priceWrite(s Stock, q Quote, now time.Time) bson.M {
+	update := bson.M{"$set": priceUpdate(s, q, now)}
+	if q.FXRate <= 0 {
+		update["$unset"] = bson.M{"priceUSD": "", "fxRate": ""}
+	}
+	return update
+}
+
+// priceUpdate builds the $set document for a quote. change and changePercent
+// are derived here because the Mongoose pre('save') hook that normally
+// maintains them never runs for writes made by this service.
+func priceUpdate(s Stock, q Quote, now time.Time) bson.M {
+	set := bson.M{
+		"currentPrice":   q.Price,
+		"priceUpdatedAt": now,
+		"updatedAt":      now,
+	}
+
+	previousClose := q.PreviousClose
+	if previousClose <= 0 {
+		previousClose = s.PreviousClose
+	}
+	if previousClose > 0 {
+		change := q.Price - previousClose
+		set["previousClose"] = previousClose
+		set["change"] = change
+		set["changePercent"] = math.Round(change/previousClose*100*100) / 100
+	}
+
+	// Fall back to tracking the range ourselves when the provider has no
+	// session high/low. The stored range carries over only within the
+	// session it was tracked in; the first quote of a new one starts afresh.
+	tracked := s.PriceUpdatedAt
+	if tracked.IsZero() {
+		tracked = s.UpdatedAt
+	}
+	sameSession := !tracked.Before(sessionStart(exchangeCode(s.Exchange, s.Symbol), now))
+
+	high, low := q.DayHigh, q.DayLow
+	if high <= 0 && sameSession {
+		high = s.High24h
+	}
+	if low <= 0 && sameSession {
+		low = s.Low24h
+	}
+	set["high24h"] = math.Max(high, q.Price)
+	if low <= 0 || q.Price < low {
+		low = q.Price
+	}
+	set["low24h"] = low
+
+	// Native currency plus a USD view for valuations across exchanges
+	if q.Currency != "" {
+		set["currency"] = q.Currency
+	}
+	set["exchange"] = exchangeCode(q.Exchange, s.Symbol)
+	if q.FXRate > 0 {
+		set["fxRate"] = q.FXRate
+		set["priceUSD"] = math.Round(q.Price*q.FXRate*10000) / 10000
+	}
+
+	optional := map[string]float64{
+		"volume":           q.Volume,
+		"fiftyTwoWeekHigh": q.FiftyTwoWeekHigh,
+		"fiftyTwoWeekLow":  q.FiftyTwoWeekLow,
+		"marketCap":        q.MarketCap,
+	}
+	for field, v := range optional {
+		if v > 0 {
+			set[field] = v
+		}
+	}
+
+	return set
+}
+
+func envInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid %s %q, using %d", key, v, fallback)
+		return fallback
+	}
+	return n
+}
+
+func envFloat(key string, fallback float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		log.Printf("Invalid %s %q, using %v", key, v, fallback)
+		return fallback
+	}
+	return f
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPriceUpdate(t *testing.T) {
+	// Monday 2024-03-04, an hour into the US session
+	open := time.Date(2024, 3, 4, 14, 30, 0, 0, time.UTC)
+	now := open.Add(time.Hour)
+
+	stock := Stock{
+		Symbol: "AAPL", Exchange: "US", CurrentPrice: 100, PreviousClose: 100,
+		High24h: 110, Low24h: 90, PriceUpdatedAt: open.Add(30 * time.Minute),
+	}
+	lastSession := stock
+	lastSession.PriceUpdatedAt = open.Add(-20 * time.Hour)
+	legacy := stock
+	legacy.PriceUpdatedAt = time.Time{}
+	legacy.UpdatedAt = open.Add(-20 * time.Hour)
+
+	tests := []struct {
+		name  string
+		stock Stock
+		quote Quote
+		want  bson.M
+	}{
+		{
+			name:  "tracked range carries over within the session",
+			stock: stock,
+			quote: Quote{Price: 105},
+			want:  bson.M{"previousClose": 100.0, "change": 5.0, "changePercent": 5.0, "high24h": 110.0, "low24h": 90.0},
+		},
+		{
+			name:  "a new price extends the tracked range",
+			stock: stock,
+			quote: Quote{Price: 112},
+			want:  bson.M{"high24h": 112.0, "low24h": 90.0},
+		},
+		{
+			name:  "the first quote of a session starts the range over",
+			stock: lastSession,
+			quote: Quote{Price: 105},
+			want:  bson.M{"high24h": 105.0, "low24h": 105.0},
+		},
+		{
+			name:  "updatedAt stands in for a missing priceUpdatedAt",
+			stock: legacy,
+			quote: Quote{Price: 105},
+			want:  bson.M{"high24h": 105.0, "low24h": 105.0},
+		},
+		{
+			name:  "provider range and previous close win",
+			stock: stock,
+			quote: Quote{Price: 98, PreviousClose: 97, DayHigh: 120, DayLow: 95, Volume: 5000},
+			want:  bson.M{"previousClose": 97.0, "change": 1.0, "changePercent": 1.03, "high24h": 120.0, "low24h": 95.0, "volume": 5000.0},
+		},
+		{
+			name:  "USD view at the quote's rate",
+			stock: stock,
+			quote: Quote{Price: 250, Currency: "GBp", Exchange: "IOB", FXRate: 0.0127},
+			want:  bson.M{"currency": "GBp", "exchange": "LSE", "fxRate": 0.0127, "priceUSD": 3.175},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := priceUpdate(tt.stock, tt.quote, now)
+			if set["currentPrice"] != tt.quote.Price || set["priceUpdatedAt"] != now {
+				t.Errorf("currentPrice %v at %v, want %v at %v", set["currentPrice"], set["priceUpdatedAt"], tt.quote.Price, now)
+			}
+			for field, want := range tt.want {
+				if got := set[field]; got != want {
+					t.Errorf("%s = %v, want %v", field, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPriceWriteWithoutRate(t *testing.T) {
+	now := time.Now()
+	stock := Stock{Symbol: "TCS.NS", Exchange: "NSE", CurrentPrice: 3900, PriceUpdatedAt: now}
+
+	update := priceWrite(stock, Quote{Price: 3950, Currency: "INR"}, now)
+	set := update["$set"].(bson.M)
+	if _, ok := set["priceUSD"]; ok {
+		t.Error("priceUSD set without a rate")
+	}
+	unset, ok := update["$unset"].(bson.M)
+	if _, stale := unset["priceUSD"]; !ok || !stale {
+		t.Errorf("priceUSD not removed: %v", update)
+	}
+
+	update = priceWrite(stock, Quote{Price: 3950, Currency: "INR", FXRate: 0.012}, now)
+	if _, ok := update["$unset"]; ok {
+		t.Errorf("USD view removed despite a rate: %v", update)
+	}
+	if got := update["$set"].(bson.M)["priceUSD"]; got != 47.4 {
+		t.Errorf("priceUSD = %v, want 47.4", got)
+	}
+}